@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"golang.org/x/time/rate"
+)
+
+// AccountErrors collects the accounts in a multi account file that couldn't be emailed, keyed by
+// account id. The other accounts in the file were still processed.
+type AccountErrors map[string]error
+
+func (e AccountErrors) Error() string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	msgs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, fmt.Sprintf("account %s: %v", id, e[id]))
+	}
+	return fmt.Sprintf("%d accounts failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// maxMissingAccountRows caps how many rows ErrMissingAccount lists.
+const maxMissingAccountRows = 10
+
+// groupByAccount splits `ts` by their Account, keeping the order accounts first appear in. It returns
+// no ids when the file has no Account column, and ErrMissingAccount when only some rows have one,
+// since those rows would otherwise belong to nobody's email.
+func groupByAccount(ts []TransactionCSV) ([]string, map[string][]TransactionCSV, error) {
+	var ids []string
+	groups := make(map[string][]TransactionCSV)
+	var missing []string
+	for i, t := range ts {
+		if t.Account == "" {
+			missing = append(missing, strconv.Itoa(t.rowNumber(i)))
+			continue
+		}
+		if _, ok := groups[t.Account]; !ok {
+			ids = append(ids, t.Account)
+		}
+		groups[t.Account] = append(groups[t.Account], t)
+	}
+	if len(ids) > 0 && len(missing) > 0 {
+		rows := strings.Join(missing, ", ")
+		if len(missing) > maxMissingAccountRows {
+			rows = strings.Join(missing[:maxMissingAccountRows], ", ") + fmt.Sprintf(" and %d more", len(missing)-maxMissingAccountRows)
+		}
+		return nil, nil, parseError{fmt.Errorf("%w: rows %s", ErrMissingAccount, rows)}
+	}
+
+	return ids, groups, nil
+}
+
+// handleAccounts summarizes and emails each account in a multi account file separately, with the
+// recipient for each coming from `lookup`. Up to SEND_CONCURRENCY accounts are sent at once, each
+// worker over its own SMTP connection so the server's connection limit is the only thing to size it
+// against, and all of them together stay under SEND_RATE. Accounts in SUPPRESS_ACCOUNTS are
+// summarized but not emailed. A failure for one account doesn't stop the rest. Permanent ones, like
+// an account with no recipient, are only logged, since a retry would fail the same way; transient
+// ones are returned together as AccountErrors so the invocation is retried. Before that the accounts
+// already sent are recorded in `svc`, and the retry skips them. Accounts not yet started when `ctx`
+// is cancelled fail with the context's error.
+func handleAccounts(ctx context.Context, sess *session.Session, svc s3iface.S3API, obj s3Object, opts summaryOptions, lookup RecipientLookup, ids []string, groups map[string][]TransactionCSV) error {
+	suppressed, err := suppressedAccounts(svc)
+	if err != nil {
+		return err
+	}
+	delivered, err := loadDelivered(svc, obj)
+	if err != nil {
+		return err
+	}
+	resumed := len(delivered) > 0
+	if resumed {
+		f := obj.fields()
+		f["accounts"] = len(delivered)
+		logJSON("info", "skipping accounts already sent", f)
+		todo := make([]string, 0, len(ids))
+		for _, id := range ids {
+			if !delivered[id] {
+				todo = append(todo, id)
+			}
+		}
+		ids = todo
+	}
+	var signKey []byte
+	if features().WriteSummary && features().SignSummary {
+		if signKey, err = signingKey(secretsmanager.New(sess)); err != nil {
+			return err
+		}
+	}
+	es, err := newEmailSender(ctx)
+	if err != nil {
+		return err
+	}
+	defer es.Close()
+
+	var mu sync.Mutex
+	failed := AccountErrors{}
+	fail := func(id string, err error) {
+		mu.Lock()
+		failed[id] = err
+		mu.Unlock()
+	}
+	done := func(id string) {
+		mu.Lock()
+		if delivered == nil {
+			delivered = map[string]bool{}
+		}
+		delivered[id] = true
+		mu.Unlock()
+	}
+
+	limiter := sendLimiter()
+	workers := envInt("SEND_CONCURRENCY", 1)
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		ws := es
+		if w > 0 {
+			ws = es.fork(ctx)
+		}
+
+		wg.Add(1)
+		go func(ws *emailSender) {
+			defer wg.Done()
+			if ws != es {
+				defer ws.Close()
+			}
+			for id := range jobs {
+				if !suppressed[id] {
+					if err := limiter.Wait(ctx); err != nil {
+						fail(id, err)
+						continue
+					}
+				}
+				if err := sendAccount(sess, obj, opts, lookup, ws, id, groups[id], suppressed[id], signKey); err != nil {
+					fail(id, err)
+					continue
+				}
+				done(id)
+			}
+		}(ws)
+	}
+
+feed:
+	for i, id := range ids {
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			for _, rest := range ids[i:] {
+				fail(rest, ctx.Err())
+			}
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	retry := AccountErrors{}
+	for id, err := range failed {
+		f := obj.fields()
+		f["account"] = id
+		f["error"] = err.Error()
+		transient := !permanentAccountError(err)
+		f["transient"] = transient
+		logJSON("error", "account failed", f)
+		if transient {
+			retry[id] = err
+		}
+	}
+
+	if len(retry) == 0 {
+		if resumed {
+			if err := clearDelivered(svc, obj); err != nil {
+				f := obj.fields()
+				f["error"] = err.Error()
+				logJSON("warn", "clearing sent accounts failed", f)
+			}
+		}
+		return nil
+	}
+	if err := saveDelivered(svc, obj, delivered); err != nil {
+		f := obj.fields()
+		f["error"] = err.Error()
+		logJSON("warn", "recording sent accounts failed", f)
+	}
+	return retry
+}
+
+// permanentAccountError reports whether an account failed in a way that retrying the file can't fix:
+// there is no recipient for it, its rows can't be summarized, or the mail server refused it for good.
+func permanentAccountError(err error) bool {
+	return errors.Is(err, ErrNoRecipient) || errors.As(err, &parseError{}) || !transientSMTP(err)
+}
+
+// deliveredKey is where handleAccounts records the accounts of `obj` already sent. It is keyed on the
+// object's idempotencyKey, so a new version of the file is sent to every account again.
+func deliveredKey(obj s3Object) string {
+	return "delivered/" + obj.idempotencyKey() + ".json"
+}
+
+// loadDelivered returns the accounts recorded by saveDelivered, or nil when there is no record.
+func loadDelivered(svc s3iface.S3API, obj s3Object) (map[string]bool, error) {
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(deliveredKey(obj)),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading sent accounts: %w", err)
+	}
+	defer out.Body.Close()
+
+	var ids []string
+	if err := json.NewDecoder(out.Body).Decode(&ids); err != nil {
+		return nil, fmt.Errorf("reading sent accounts: %w", err)
+	}
+	delivered := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		delivered[id] = true
+	}
+	return delivered, nil
+}
+
+// saveDelivered records `delivered`, the accounts of `obj` sent so far, for a retry to skip.
+func saveDelivered(svc s3iface.S3API, obj s3Object, delivered map[string]bool) error {
+	ids := make([]string, 0, len(delivered))
+	for id := range delivered {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	b, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(obj.Bucket),
+		Key:         aws.String(deliveredKey(obj)),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// clearDelivered removes the record once nothing is left to retry.
+func clearDelivered(svc s3iface.S3API, obj s3Object) error {
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(deliveredKey(obj)),
+	})
+	return err
+}
+
+// sendAccount summarizes `ts`, the transactions of account `id`, with `opts` and emails them to its
+// recipient. A `suppress`ed account is summarized, written and published like any other, just never
+// emailed. With WRITE_SUMMARY the summary is written first, signed with `signKey` when there is one.
+func sendAccount(sess *session.Session, obj s3Object, opts summaryOptions, lookup RecipientLookup, es *emailSender, id string, ts []TransactionCSV, suppress bool, signKey []byte) error {
+	sums, err := getSummaries(ts, opts)
+	if err != nil {
+		return err
+	}
+	if features().WriteSummary {
+		at := opts.asOf
+		if at.IsZero() {
+			at = time.Now()
+		}
+		if err := writeAccountSummary(s3manager.NewUploader(sess), obj, id, sums, signKey, at); err != nil {
+			return err
+		}
+	}
+	if suppress {
+		f := obj.fields()
+		f["account"] = id
+		f["transactions"] = len(ts)
+		logJSON("info", "account email suppressed", f)
+		return maybePublish(sess, obj, id, sums)
+	}
+
+	rc, err := lookup.Lookup(id)
+	if err != nil {
+		return err
+	}
+	// published first, like deliver, so a fatal publish failure can't cause a second email on retry
+	if err := maybePublish(sess, obj, id, sums); err != nil {
+		return err
+	}
+
+	sent, err := es.send(sums, rc)
+	if err != nil {
+		return err
+	}
+
+	if features().UploadRendered {
+		if err := uploadRendered(s3.New(sess), obj, sent); err != nil {
+			f := obj.fields()
+			f["account"] = id
+			f["error"] = err.Error()
+			logJSON("warn", "uploading rendered email failed", f)
+		}
+	}
+
+	return nil
+}
+
+// sendLimiter paces sends to SEND_RATE messages per second, allowing bursts of SEND_BURST, so a
+// large batch doesn't trip the relay's rate limit. Sends are unlimited when SEND_RATE isn't set.
+func sendLimiter() *rate.Limiter {
+	r := envFloat("SEND_RATE", 0)
+	if r <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(r), envInt("SEND_BURST", 1))
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// accountTransactions are sampleTransactions spread over three accounts.
+func accountTransactions() []TransactionCSV {
+	ts := append([]TransactionCSV(nil), sampleTransactions...)
+	for i, acct := range []string{"acct-1", "acct-2", "acct-1", "acct-3"} {
+		ts[i].Account = acct
+	}
+	return ts
+}
+
+func TestGroupByAccount(t *testing.T) {
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "acct-1,acct-2,acct-3" || len(groups["acct-1"]) != 2 {
+		t.Errorf("groupByAccount = %v, %v, want accounts in first seen order", ids, groups)
+	}
+
+	if ids, _, err := groupByAccount(sampleTransactions); len(ids) != 0 || err != nil {
+		t.Errorf("groupByAccount without accounts = %v, %v, want none", ids, err)
+	}
+}
+
+func TestGroupByAccountMissing(t *testing.T) {
+	ts := accountTransactions()
+	ts[1].Account, ts[3].Account = "", ""
+	ts[1].Row, ts[3].Row = 2, 5
+
+	_, _, err := groupByAccount(ts)
+	if !errors.Is(err, ErrMissingAccount) || !strings.Contains(err.Error(), "rows 2, 5") {
+		t.Errorf("groupByAccount error = %v, want ErrMissingAccount for rows 2 and 5", err)
+	}
+	// the file is at fault, so it is quarantined rather than retried
+	if !errors.As(err, &parseError{}) {
+		t.Errorf("error %v is not a parseError", err)
+	}
+}
+
+// testSession is a session that never needs credentials, for code that only uses it to make clients
+// it won't call.
+func testSession(t *testing.T) *session.Session {
+	t.Helper()
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess
+}
+
+func TestHandleAccountsLookup(t *testing.T) {
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	logs := captureLogs(t)
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := fakeRecipients{
+		"acct-1": {AccountID: "acct-1", Email: "one@example.com"},
+		"acct-2": {AccountID: "acct-2", Email: "two@example.com"},
+	}
+
+	// a missing recipient fails the same way on every retry, so it is only logged
+	store := &fakeS3{}
+	if err := handleAccounts(context.Background(), testSession(t), store, s3Object{Bucket: "b", Key: "multi.csv"}, summaryOptions{}, lookup, ids, groups); err != nil {
+		t.Fatalf("handleAccounts error = %v, want acct-3's missing recipient only logged", err)
+	}
+	if out := logs.String(); !strings.Contains(out, `"account":"acct-3"`) || !strings.Contains(out, `"transient":false`) {
+		t.Errorf("acct-3's failure wasn't logged as permanent:\n%s", out)
+	}
+	if keys := store.keys("b", "delivered/"); len(keys) != 0 {
+		t.Errorf("recorded %v, want nothing kept when there is nothing to retry", keys)
+	}
+
+	sent := map[string]string{}
+	for _, msg := range m.Messages() {
+		_, body := msg.Parse(t)
+		sent[msg.To[0]] = body
+	}
+	if len(sent) != 2 {
+		t.Fatalf("sent to %v, want the two accounts with a recipient", sent)
+	}
+	if !strings.Contains(sent["one@example.com"], "Total debits: -$20.46") {
+		t.Errorf("acct-1's email doesn't have its own totals:\n%s", sent["one@example.com"])
+	}
+	if !strings.Contains(sent["two@example.com"], "Total debits: -$10.30") {
+		t.Errorf("acct-2's email doesn't have its own totals:\n%s", sent["two@example.com"])
+	}
+}
+
+// flakyRecipients is a fakeRecipients whose lookups of the accounts in `fail` return the error once.
+type flakyRecipients struct {
+	fakeRecipients
+	mu   sync.Mutex
+	fail map[string]error
+}
+
+func (r *flakyRecipients) Lookup(id string) (Recipient, error) {
+	r.mu.Lock()
+	err := r.fail[id]
+	delete(r.fail, id)
+	r.mu.Unlock()
+	if err != nil {
+		return Recipient{}, err
+	}
+	return r.fakeRecipients.Lookup(id)
+}
+
+func TestHandleAccountsRetrySkipsSent(t *testing.T) {
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := &flakyRecipients{fakeRecipients: fakeRecipients{}, fail: map[string]error{"acct-2": errors.New("throttled")}}
+	for _, id := range ids {
+		lookup.fakeRecipients[id] = Recipient{AccountID: id, Email: id + "@example.com"}
+	}
+	store := &fakeS3{}
+	obj := s3Object{Bucket: "b", Key: "multi.csv", ETag: "e1"}
+
+	err = handleAccounts(context.Background(), testSession(t), store, obj, summaryOptions{}, lookup, ids, groups)
+	var failed AccountErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || failed["acct-2"] == nil {
+		t.Fatalf("handleAccounts error = %v, want only acct-2 to fail", err)
+	}
+	if n := len(m.Messages()); n != 2 {
+		t.Fatalf("sent %d emails, want acct-1's and acct-3's", n)
+	}
+
+	// the retry only sends what failed
+	if err := handleAccounts(context.Background(), testSession(t), store, obj, summaryOptions{}, lookup, ids, groups); err != nil {
+		t.Fatal(err)
+	}
+	msgs := m.Messages()
+	if len(msgs) != 3 || msgs[2].To[0] != "acct-2@example.com" {
+		var to []string
+		for _, msg := range msgs {
+			to = append(to, msg.To[0])
+		}
+		t.Errorf("sent to %v, want acct-2 added on the retry and nobody emailed twice", to)
+	}
+	if keys := store.keys("b", "delivered/"); len(keys) != 0 {
+		t.Errorf("kept %v after the retry succeeded", keys)
+	}
+
+	// a new version of the file is sent to everyone
+	store.put("b", deliveredKey(obj), storedObject{Body: []byte(`["acct-1","acct-2","acct-3"]`)})
+	obj.ETag = "e2"
+	if err := handleAccounts(context.Background(), testSession(t), store, obj, summaryOptions{}, lookup, ids, groups); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.Messages()); n != 6 {
+		t.Errorf("sent %d emails in total, want a new version sent to all three accounts", n)
+	}
+}
+
+func TestPermanentAccountError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("acct-1: %w", ErrNoRecipient), true},
+		{parseError{errors.New("bad amount")}, true},
+		{&textproto.Error{Code: 550, Msg: "no such mailbox"}, true},
+		{&textproto.Error{Code: 421, Msg: "try again later"}, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("connection reset"), false},
+	} {
+		if got := permanentAccountError(tt.err); got != tt.want {
+			t.Errorf("permanentAccountError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSendLimiterPaces(t *testing.T) {
+	t.Setenv("SEND_RATE", "20")
+	withConfig(t, nil)
+	limiter := sendLimiter()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first send goes straight out and each of the other four waits 50ms
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("5 sends at 20/s took %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestHandleAccountsPaced(t *testing.T) {
+	t.Setenv("SEND_RATE", "20")
+	t.Setenv("SEND_CONCURRENCY", "4")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := fakeRecipients{}
+	for _, id := range ids {
+		lookup[id] = Recipient{AccountID: id, Email: id + "@example.com"}
+	}
+
+	start := time.Now()
+	if err := handleAccounts(context.Background(), testSession(t), &fakeS3{}, s3Object{Bucket: "b", Key: "multi.csv"}, summaryOptions{}, lookup, ids, groups); err != nil {
+		t.Fatal(err)
+	}
+	// every worker shares the one limiter, so three accounts take two intervals however many send
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("3 accounts at 20/s took %v, want at least 100ms", elapsed)
+	}
+	if n := len(m.Messages()); n != 3 {
+		t.Errorf("sent %d emails, want 3", n)
+	}
+}
+
+func TestHandleAccountsCancelled(t *testing.T) {
+	t.Setenv("SEND_RATE", "0.001")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := fakeRecipients{}
+	for _, id := range ids {
+		lookup[id] = Recipient{AccountID: id, Email: id + "@example.com"}
+	}
+
+	// the burst lets the first account out; the rest would wait far longer than the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = handleAccounts(ctx, testSession(t), &fakeS3{}, s3Object{Bucket: "b", Key: "multi.csv"}, summaryOptions{}, lookup, ids, groups)
+	var failed AccountErrors
+	if !errors.As(err, &failed) || len(failed) != 2 {
+		t.Fatalf("handleAccounts error = %v, want the two waiting accounts to fail", err)
+	}
+	if n := len(m.Messages()); n != 1 {
+		t.Errorf("sent %d emails, want only the first", n)
+	}
+}
+
+func TestHandleAccountsSuppressed(t *testing.T) {
+	t.Setenv("SUPPRESS_ACCOUNTS", "acct-2, acct-3")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	logs := captureLogs(t)
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// suppressed accounts are never looked up, so acct-3 having no recipient doesn't matter
+	lookup := fakeRecipients{
+		"acct-1": {AccountID: "acct-1", Email: "one@example.com"},
+		"acct-2": {AccountID: "acct-2", Email: "two@example.com"},
+	}
+
+	if err := handleAccounts(context.Background(), testSession(t), &fakeS3{}, s3Object{Bucket: "b", Key: "multi.csv"}, summaryOptions{}, lookup, ids, groups); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := m.Messages(); len(msgs) != 1 || msgs[0].To[0] != "one@example.com" {
+		t.Errorf("sent %d messages, want just acct-1's", len(msgs))
+	}
+
+	suppressed := map[string]float64{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		i := strings.IndexByte(line, '{')
+		if i < 0 || !strings.Contains(line, `"account email suppressed"`) {
+			continue
+		}
+		var f map[string]interface{}
+		if err := json.Unmarshal([]byte(line[i:]), &f); err != nil {
+			t.Fatal(err)
+		}
+		suppressed[f["account"].(string)] = f["transactions"].(float64)
+	}
+	if len(suppressed) != 2 || suppressed["acct-2"] != 1 || suppressed["acct-3"] != 1 {
+		t.Errorf("suppressed accounts logged = %v, want acct-2 and acct-3 with 1 transaction each", suppressed)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "fmt"
+
+// accountType decides which statement a recipient gets. Personal accounts get the layout picked by
+// EMAIL_TIER, business accounts always get the business layout.
+type accountType string
+
+const (
+	accountPersonal accountType = "personal"
+	accountBusiness accountType = "business"
+)
+
+// businessTemplate is the layout rendered for business accounts.
+const businessTemplate = "business"
+
+// BusinessSummary holds what only business statements show.
+type BusinessSummary struct {
+	// ReceiptCount and PaymentCount are how many credits and debits there were.
+	ReceiptCount int
+	PaymentCount int
+}
+
+// getAccountType returns the recipient's AccountType, falling back to ACCOUNT_TYPE and then to personal.
+func getAccountType(rc Recipient) (accountType, error) {
+	v := rc.AccountType
+	if v == "" {
+		v = getenv("ACCOUNT_TYPE")
+	}
+
+	switch at := accountType(v); at {
+	case "", accountPersonal:
+		return accountPersonal, nil
+	case accountBusiness:
+		return at, nil
+	default:
+		return "", fmt.Errorf("unsupported ACCOUNT_TYPE %q", v)
+	}
+}
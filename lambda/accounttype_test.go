@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetAccountType(t *testing.T) {
+	for _, tc := range []struct {
+		env, recipient string
+		want           accountType
+	}{
+		{"", "", accountPersonal},
+		{"business", "", accountBusiness},
+		{"", "business", accountBusiness},
+		{"business", "personal", accountPersonal},
+		{"personal", "business", accountBusiness},
+	} {
+		t.Setenv("ACCOUNT_TYPE", tc.env)
+		withConfig(t, nil)
+
+		if got, err := getAccountType(Recipient{AccountType: tc.recipient}); err != nil || got != tc.want {
+			t.Errorf("ACCOUNT_TYPE=%q, recipient %q: getAccountType = %q, %v, want %q", tc.env, tc.recipient, got, err, tc.want)
+		}
+	}
+
+	withConfig(t, nil)
+	if _, err := getAccountType(Recipient{AccountType: "Business"}); err == nil || !strings.Contains(err.Error(), "ACCOUNT_TYPE") {
+		t.Errorf("getAccountType error = %v, want the type rejected", err)
+	}
+}
+
+func TestRenderEmailAccountTypes(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(append(append([]TransactionCSV{}, sampleTransactions...), subscriptions...), summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func(at string) string {
+		r, err := RenderEmail(sm, Recipient{AccountType: at}, "statements@example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Body
+	}
+
+	personal := render("personal")
+	for _, want := range []string{"Total credits: $70.50", "Subscriptions/Recurring:"} {
+		if !strings.Contains(personal, want) {
+			t.Errorf("personal body is missing %q:\n%s", want, personal)
+		}
+	}
+
+	business := render("business")
+	for _, want := range []string{"Total receipts: $70.50, 2 transactions", "Total payments: -$180.73, 10 transactions"} {
+		if !strings.Contains(business, want) {
+			t.Errorf("business body is missing %q:\n%s", want, business)
+		}
+	}
+	for _, unwanted := range []string{"Total credits", "Subscriptions/Recurring:"} {
+		if strings.Contains(business, unwanted) {
+			t.Errorf("business body has %q:\n%s", unwanted, business)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// allowedSources reads ALLOWED_SOURCES, a comma separated list of `bucket` or `bucket/prefix` entries.
+func allowedSources() []string {
+	var out []string
+	for _, s := range strings.Split(getenv("ALLOWED_SOURCES"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// checkSource makes sure `bucket` and `key` match one of the `allowed` entries. An empty allowlist
+// accepts everything, which keeps the original behavior for deployments that don't set it.
+func checkSource(bucket, key string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	for _, a := range allowed {
+		parts := strings.SplitN(a, "/", 2)
+		prefix := ""
+		if len(parts) == 2 {
+			prefix = parts[1]
+		}
+		if parts[0] == bucket && strings.HasPrefix(key, prefix) {
+			return nil
+		}
+	}
+
+	logJSON("warn", "rejected event source", map[string]interface{}{"bucket": bucket, "key": key})
+	return fmt.Errorf("%w: s3://%s/%s", ErrSourceNotAllowed, bucket, key)
+}
+
+// checkEvent makes sure `rec` came from our own bucket notification rather than a hand made invoke.
+// With EVENT_CONFIGURATION_ID set, the record has to name that notification configuration, which acts
+// as a shared secret between the bucket and the function; with EVENT_MAX_AGE, it has to be recent,
+// which stops an old event from being replayed. Both are off by default, and with either one set the
+// record also has to come from S3 at all.
+//
+// The age is measured from the record's eventTime, which Lambda keeps when it retries an asynchronous
+// invoke. Retries can't be told apart from replays, so one that arrives after EVENT_MAX_AGE is rejected
+// like any other old event: keep EVENT_MAX_AGE above the function's maximum event age.
+func checkEvent(rec events.S3EventRecord, now time.Time) error {
+	id := getenv("EVENT_CONFIGURATION_ID")
+	maxAge := envDuration("EVENT_MAX_AGE", 0)
+	if id == "" && maxAge <= 0 {
+		return nil
+	}
+
+	var reason string
+	switch {
+	case rec.EventSource != "aws:s3":
+		reason = fmt.Sprintf("event source %q", rec.EventSource)
+	case id != "" && subtle.ConstantTimeCompare([]byte(rec.S3.ConfigurationID), []byte(id)) != 1:
+		// the configured id is a secret, so only say that it didn't match
+		reason = "unknown notification configuration"
+	case maxAge > 0 && (rec.EventTime.IsZero() || now.Sub(rec.EventTime) > maxAge):
+		reason = fmt.Sprintf("event from %s is older than EVENT_MAX_AGE", rec.EventTime.UTC().Format(time.RFC3339))
+	default:
+		return nil
+	}
+
+	logJSON("warn", "rejected unverified event", map[string]interface{}{"bucket": rec.S3.Bucket.Name, "key": rec.S3.Object.Key, "reason": reason})
+	return fmt.Errorf("%w: %s", ErrUnauthorizedEvent, reason)
+}
+
+// checkSMTPHost makes sure `host` from EMAIL_SECRET is one of SMTP_HOST_ALLOWLIST, so a tampered
+// secret can't send statements to a server of its choosing. An empty allowlist accepts any host unless
+// SMTP_HOST_STRICT is set, in which case nothing is.
+func checkSMTPHost(host string) error {
+	var allowed []string
+	for _, h := range strings.Split(getenv("SMTP_HOST_ALLOWLIST"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			allowed = append(allowed, h)
+		}
+	}
+	if len(allowed) == 0 && !features().SMTPHostStrict {
+		return nil
+	}
+
+	for _, a := range allowed {
+		if strings.EqualFold(a, host) {
+			return nil
+		}
+	}
+
+	logJSON("error", "rejected smtp host", map[string]interface{}{"host": host})
+	return fmt.Errorf("%w: %q", ErrSMTPHostNotAllowed, host)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// s3Record is a record from bucket notification `id` sent at `at`.
+func s3Record(id string, at time.Time) events.S3EventRecord {
+	rec := events.S3EventRecord{EventSource: "aws:s3", EventTime: at}
+	rec.S3.ConfigurationID = id
+	rec.S3.Bucket.Name = "uploads"
+	rec.S3.Object.Key = "a.csv"
+	return rec
+}
+
+func TestCheckEventConfigurationID(t *testing.T) {
+	t.Setenv("EVENT_CONFIGURATION_ID", "s3-notify-7f3a")
+	withConfig(t, nil)
+	now := time.Now()
+
+	if err := checkEvent(s3Record("s3-notify-7f3a", now), now); err != nil {
+		t.Errorf("checkEvent = %v for our own notification", err)
+	}
+	for name, rec := range map[string]events.S3EventRecord{
+		"other notification": s3Record("s3-notify-0000", now),
+		"no notification":    s3Record("", now),
+		"not from S3":        {EventSource: "aws:sqs"},
+	} {
+		if err := checkEvent(rec, now); !errors.Is(err, ErrUnauthorizedEvent) {
+			t.Errorf("%s: checkEvent = %v, want ErrUnauthorizedEvent", name, err)
+		}
+	}
+}
+
+func TestCheckEventMaxAge(t *testing.T) {
+	t.Setenv("EVENT_MAX_AGE", "6h")
+	withConfig(t, nil)
+	now := time.Date(2021, 8, 30, 12, 0, 0, 0, time.UTC)
+
+	if err := checkEvent(s3Record("", now.Add(-5*time.Hour)), now); err != nil {
+		t.Errorf("checkEvent = %v for a recent event", err)
+	}
+	// a late retry keeps its original event time, so it is rejected like a replay
+	for name, at := range map[string]time.Time{
+		"too old":       now.Add(-6*time.Hour - time.Second),
+		"no event time": {},
+	} {
+		if err := checkEvent(s3Record("", at), now); !errors.Is(err, ErrUnauthorizedEvent) {
+			t.Errorf("%s: checkEvent = %v, want ErrUnauthorizedEvent", name, err)
+		}
+	}
+}
+
+func TestCheckEventOff(t *testing.T) {
+	withConfig(t, nil)
+
+	if err := checkEvent(events.S3EventRecord{}, time.Now()); err != nil {
+		t.Errorf("checkEvent = %v with neither check configured", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// validationReport is the API response: every bad row alongside the summary of the rows that were fine.
+type validationReport struct {
+	Valid   bool       `json:"valid"`
+	Errors  []RowError `json:"errors"`
+	Summary Summaries  `json:"summary"`
+}
+
+// HandleAPIRequest summarizes a CSV posted as the request body and reports every row that couldn't
+// be parsed, instead of stopping at the first like the S3 path does. Nothing is emailed.
+func HandleAPIRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	defer flush()
+
+	ctx, sp := tracer.Start(extractTrace(ctx, req.Headers), "HandleAPIRequest")
+	defer sp.End()
+
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err), nil
+	}
+	if err := loadConfig(s3.New(sess)); err != nil {
+		return apiError(http.StatusInternalServerError, err), nil
+	}
+
+	body := req.Body
+	if req.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return apiError(http.StatusBadRequest, err), nil
+		}
+		body = string(b)
+	}
+
+	// rows that don't even parse are reported like any other bad row
+	_, psp := tracer.Start(ctx, "parse")
+	ts, rowErrs, err := parseCSV(strings.NewReader(body), true)
+	endSpan(psp, err)
+	if err != nil {
+		return apiError(http.StatusBadRequest, err), nil
+	}
+
+	exclude, err := excludedIDs(s3.New(sess))
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err), nil
+	}
+
+	months, err := monthNames()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err), nil
+	}
+
+	// uploads here have no key, so only AMOUNT_LOCALE applies
+	number, err := amountFormat("")
+	if err != nil {
+		return apiError(http.StatusInternalServerError, err), nil
+	}
+
+	_, ssp := tracer.Start(ctx, "summarize")
+	sums, err := getSummaries(ts, summaryOptions{lenient: true, exclude: exclude, months: months, number: number})
+	endSpan(ssp, err)
+	if err != nil {
+		return apiError(http.StatusUnprocessableEntity, err), nil
+	}
+
+	sums.RowErrors = append(rowErrs, sums.RowErrors...)
+	sort.SliceStable(sums.RowErrors, func(i, j int) bool { return sums.RowErrors[i].Row < sums.RowErrors[j].Row })
+	rep := validationReport{Valid: len(sums.RowErrors) == 0, Errors: sums.RowErrors, Summary: sums}
+	if rep.Errors == nil {
+		rep.Errors = []RowError{}
+	}
+
+	return apiJSON(http.StatusOK, rep), nil
+}
+
+// apiError reports a failure that prevented summarizing the file at all.
+func apiError(status int, err error) events.APIGatewayProxyResponse {
+	return apiJSON(status, map[string]string{"error": err.Error()})
+}
+
+func apiJSON(status int, v interface{}) events.APIGatewayProxyResponse {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(b),
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// badRows is a file with one good row, then a short row, a row with a stray quote and a row whose
+// amount isn't a number, then another good row.
+const badRows = "Id,Date,Transaction\n" +
+	"0,7/15,+60.5\n" +
+	"1,7/28\n" +
+	"2,\"8/2\"x,-20.46\n" +
+	"3,8/13,abc\n" +
+	"4,8/14,+10\n"
+
+func TestHandleAPIRequestStructuralErrors(t *testing.T) {
+	for _, variable := range []string{"", "true"} {
+		t.Run("VARIABLE_FIELDS="+variable, func(t *testing.T) {
+			t.Setenv("VARIABLE_FIELDS", variable)
+			withConfig(t, nil)
+
+			resp, err := HandleAPIRequest(context.Background(), events.APIGatewayProxyRequest{Body: badRows})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200 with every bad row reported: %s", resp.StatusCode, resp.Body)
+			}
+
+			var rep validationReport
+			if err := json.Unmarshal([]byte(resp.Body), &rep); err != nil {
+				t.Fatal(err)
+			}
+			var rows []int
+			for _, e := range rep.Errors {
+				rows = append(rows, e.Row)
+			}
+			if rep.Valid || len(rows) != 3 || rows[0] != 2 || rows[1] != 3 || rows[2] != 4 {
+				t.Errorf("errors at rows %v, valid %v, want rows 2, 3 and 4", rows, rep.Valid)
+			}
+			if rep.Summary.CreditCount != 2 || rep.Summary.CreditTotal != 70.5 {
+				t.Errorf("summary = %d credits of %v, want the two good rows", rep.Summary.CreditCount, rep.Summary.CreditTotal)
+			}
+		})
+	}
+}
+
+func TestReadCSVStrictShortRow(t *testing.T) {
+	t.Setenv("VARIABLE_FIELDS", "true")
+	withConfig(t, nil)
+
+	if _, err := readCSV(strings.NewReader("Id,Date,Transaction\n0,7/15,+60.5\n1,7/28\n")); !errors.Is(err, ErrShortRow) {
+		t.Errorf("readCSV error = %v, want ErrShortRow outside lenient mode", err)
+	}
+}
+
+func TestParseCSVRowNumbers(t *testing.T) {
+	t.Setenv("REPEATED_HEADERS", "skip")
+	withConfig(t, nil)
+
+	// the repeated header still takes up a row
+	ts, _, err := parseCSV(strings.NewReader("Id,Date,Transaction\n1,7/1,+1\nId,Date,Transaction\n2,7/2,+2\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 || ts[0].Row != 1 || ts[1].Row != 3 {
+		t.Errorf("rows = %+v, want numbers 1 and 3", ts)
+	}
+}
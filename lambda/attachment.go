@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+)
+
+// transactionsAttachment is the file name the transactions table is attached as when it doesn't fit
+// in the body.
+const transactionsAttachment = "transactions.csv"
+
+// transactionsCSV writes the transactions table as a CSV with the same columns as the email's table,
+// plus descriptions.
+func transactionsCSV(txs []NotableTransaction) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{"Id", "Date", "Amount", "Description"})
+	for _, tx := range txs {
+		w.Write([]string{tx.ID, tx.Date, strconv.FormatFloat(tx.Amount, 'f', 2, 64), tx.Description})
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// multipartBody wraps the message body `body`, of type `ct`, and a CSV attachment into a multipart/mixed
+// message body. It returns the Content-Type header for the message along with the body.
+func multipartBody(ct string, body []byte, name string, att []byte) (string, []byte, error) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {ct}})
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := pw.Write(body); err != nil {
+		return "", nil, err
+	}
+
+	aw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/csv; charset="UTF-8"; name="` + name + `"`},
+		"Content-Disposition":       {`attachment; filename="` + name + `"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if err := writeBase64(aw, att); err != nil {
+		return "", nil, err
+	}
+
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return "multipart/mixed; boundary=" + mw.Boundary(), b.Bytes(), nil
+}
+
+// relatedBody wraps the HTML `body` and a PNG it shows as `cid:<id>` into a multipart/related body,
+// which mail clients display inline rather than as an attachment. It returns the Content-Type along
+// with the body, like multipartBody.
+func relatedBody(body string, id string, png []byte) (string, []byte, error) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {`text/html; charset="UTF-8"`}})
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := pw.Write([]byte(body)); err != nil {
+		return "", nil, err
+	}
+
+	iw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`image/png; name="` + id + `.png"`},
+		"Content-Disposition":       {`inline; filename="` + id + `.png"`},
+		"Content-ID":                {"<" + id + ">"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if err := writeBase64(iw, png); err != nil {
+		return "", nil, err
+	}
+
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return `multipart/related; type="text/html"; boundary=` + mw.Boundary(), b.Bytes(), nil
+}
+
+// writeBase64 writes `data` base64 encoded, in lines of the 76 characters RFC 2045 caps them at.
+func writeBase64(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		if _, err := io.WriteString(w, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err := io.WriteString(w, enc+"\r\n")
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import "sort"
+
+// defaultCategory collects the transactions without a category, including every transaction of a file
+// without a Category column.
+const defaultCategory = "Uncategorized"
+
+// CategoryTotals are the credits and debits of one category. Debit is negative, like DebitTotal.
+type CategoryTotals struct {
+	Credit float64
+	Debit  float64
+}
+
+// CategoryRow is one line of the category cross-tab in the email.
+type CategoryRow struct {
+	Category string
+	Credit   float64
+	Debit    float64
+}
+
+// addCategory adds `amt` to the credits or debits of `category`, or of defaultCategory when it is empty.
+func (sm *Summaries) addCategory(category string, amt float64) {
+	if category == "" {
+		category = defaultCategory
+	}
+	ct := sm.Categories[category]
+	if amt > 0 {
+		ct.Credit += amt
+	}
+	if amt < 0 {
+		ct.Debit += amt
+	}
+	sm.Categories[category] = ct
+}
+
+// categoryRows orders the categories of `m` by name for display, with defaultCategory, labelled
+// `uncategorized`, last.
+func categoryRows(m map[string]CategoryTotals, rm roundingMode, uncategorized string) []CategoryRow {
+	rows := make([]CategoryRow, 0, len(m))
+	var other *CategoryRow
+	for c, ct := range m {
+		row := CategoryRow{Category: c, Credit: rm.cents(ct.Credit), Debit: rm.cents(ct.Debit)}
+		if c == defaultCategory {
+			row.Category = uncategorized
+			other = &row
+			continue
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Category < rows[j].Category })
+	if other != nil {
+		rows = append(rows, *other)
+	}
+
+	return rows
+}
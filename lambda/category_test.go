@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// categorized spreads sampleTransactions and a few more over three categories, with two rows left
+// without one.
+func categorized() []TransactionCSV {
+	ts := append([]TransactionCSV{}, sampleTransactions...)
+	for i, c := range []string{"Salary", "groceries", " Groceries ", ""} {
+		ts[i].Category = c
+	}
+	return append(ts,
+		TransactionCSV{ID: "4", Date: "8/20", Transaction: "+5", Category: "groceries"},
+		TransactionCSV{ID: "5", Date: "8/21", Transaction: "-3", Category: " "},
+	)
+}
+
+func TestGetSummariesCategoryTotals(t *testing.T) {
+	withConfig(t, nil)
+	sm, err := getSummaries(categorized(), summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Categories != nil {
+		t.Errorf("Categories = %v without CATEGORY_TOTALS, want nil", sm.Categories)
+	}
+
+	t.Setenv("CATEGORY_TOTALS", "true")
+	withConfig(t, nil)
+	if sm, err = getSummaries(categorized(), summaryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// categories are matched exactly once trimmed, so case still tells them apart
+	want := map[string]CategoryTotals{
+		"Salary":        {Credit: 60.5},
+		"groceries":     {Credit: 5, Debit: -10.3},
+		"Groceries":     {Debit: -20.46},
+		defaultCategory: {Credit: 10, Debit: -3},
+	}
+	if !reflect.DeepEqual(sm.Categories, want) {
+		t.Errorf("Categories = %v, want %v", sm.Categories, want)
+	}
+}
+
+func TestCategoryRows(t *testing.T) {
+	rows := categoryRows(map[string]CategoryTotals{
+		defaultCategory: {Credit: 10},
+		"Salary":        {Credit: 60.504},
+		"Groceries":     {Debit: -20.456},
+	}, roundHalfUp, "Sin categoría")
+
+	want := []CategoryRow{
+		{Category: "Groceries", Debit: -20.46},
+		{Category: "Salary", Credit: 60.5},
+		{Category: "Sin categoría", Credit: 10},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("categoryRows = %+v, want %+v", rows, want)
+	}
+}
+
+func TestRenderEmailCategoryTotals(t *testing.T) {
+	t.Setenv("CATEGORY_TOTALS", "true")
+	withConfig(t, nil)
+
+	sm, err := getSummaries(categorized(), summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []string{
+		"<tr><td>Groceries</td><td>$0.00</td><td>-$20.46</td></tr>",
+		"<tr><td>Salary</td><td>$60.50</td><td>$0.00</td></tr>",
+		"<tr><td>groceries</td><td>$5.00</td><td>-$10.30</td></tr>",
+		"<tr><td>Uncategorized</td><td>$10.00</td><td>-$3.00</td></tr>",
+	}
+	last := -1
+	for _, row := range rows {
+		i := strings.Index(r.Body, row)
+		if i < 0 {
+			t.Errorf("body is missing %q:\n%s", row, r.Body)
+			continue
+		}
+		if i < last {
+			t.Errorf("%q is out of order:\n%s", row, r.Body)
+		}
+		last = i
+	}
+}
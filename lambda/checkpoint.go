@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// checkpoint is the partial summary of a file after its first Rows rows, stored so that a retried
+// invocation can resume instead of starting over.
+type checkpoint struct {
+	ETag    string
+	Rows    int
+	Summary Summaries
+	summaryState
+}
+
+// summaryState is what getSummaries tracks besides the Summaries themselves.
+type summaryState struct {
+	// Quantiles is the state of the estimators behind Summaries.AmountPercentiles.
+	Quantiles []p2Quantile `json:",omitempty"`
+	// Recurring tracks description and amount pairs for Summaries.Recurring, keyed by recurringKey.
+	Recurring map[string]*recurringSeen `json:",omitempty"`
+	// Debits are the dates of the debits no refund has matched yet, keyed by the recurringKey of their
+	// description and absolute amount.
+	Debits map[string][]string `json:",omitempty"`
+	// CreditSum and DebitSum are the compensated sums behind the totals. Resuming from the totals alone
+	// would lose the compensation and let a resumed run drift from an uninterrupted one.
+	CreditSum kahanSum
+	DebitSum  kahanSum
+}
+
+// checkpointer saves and restores the progress of a single S3 object in `checkpoints/<key>.json`
+// in the same bucket. Only summarizing is checkpointed: a retry still downloads and parses the whole
+// file again before skipping the rows already counted.
+type checkpointer struct {
+	svc    s3iface.S3API
+	bucket string
+	key    string
+	etag   string
+	every  int
+}
+
+// newCheckpointer returns a checkpointer for the object in `ev`, or nil when CHECKPOINT_INTERVAL,
+// the number of rows between saves, isn't set. Checkpointing is opt in.
+func newCheckpointer(svc s3iface.S3API, ev events.S3Event) *checkpointer {
+	every, err := strconv.Atoi(getenv("CHECKPOINT_INTERVAL"))
+	if err != nil || every <= 0 {
+		return nil
+	}
+
+	obj := ev.Records[0].S3.Object
+	return &checkpointer{
+		svc:    svc,
+		bucket: ev.Records[0].S3.Bucket.Name,
+		key:    "checkpoints/" + obj.URLDecodedKey + ".json",
+		etag:   obj.ETag,
+		every:  every,
+	}
+}
+
+// Load returns the saved checkpoint, if any. A checkpoint left by a different version of the object
+// is ignored, since its row offsets no longer line up.
+func (c *checkpointer) Load() (checkpoint, bool, error) {
+	out, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return checkpoint{}, false, nil
+		}
+		return checkpoint{}, false, err
+	}
+	defer out.Body.Close()
+
+	var cp checkpoint
+	if err := json.NewDecoder(out.Body).Decode(&cp); err != nil {
+		return checkpoint{}, false, err
+	}
+	if cp.ETag != c.etag {
+		return checkpoint{}, false, nil
+	}
+
+	logJSON("info", "resuming from checkpoint", map[string]interface{}{"bucket": c.bucket, "key": c.key, "etag": c.etag, "rows": cp.Rows})
+	return cp, true, nil
+}
+
+// Save stores `sm` and `st` as the state after the first `rows` rows.
+func (c *checkpointer) Save(rows int, sm Summaries, st summaryState) error {
+	b, err := json.Marshal(checkpoint{ETag: c.etag, Rows: rows, Summary: sm, summaryState: st})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(c.key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// Clear removes the checkpoint once the file has been fully processed.
+func (c *checkpointer) Clear() error {
+	_, err := c.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key),
+	})
+	return err
+}
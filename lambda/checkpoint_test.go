@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// flakyS3 is a fakeS3 whose PutObject fails once `puts` objects have been written, like an invocation
+// timing out partway through a file.
+type flakyS3 struct {
+	*fakeS3
+	puts int
+}
+
+func (f *flakyS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if f.puts == 0 {
+		return nil, errors.New("invocation timed out")
+	}
+	f.puts--
+	return f.fakeS3.PutObject(in)
+}
+
+// driftingTransactions are rows whose running total loses low order digits without compensation: a
+// large opening credit followed by many cent amounts.
+func driftingTransactions() []TransactionCSV {
+	ts := []TransactionCSV{{ID: "0", Date: "7/1/2021", Transaction: "+35184372088832.00"}}
+	for i := 1; i <= 3000; i++ {
+		amt := "+0.01"
+		if i%3 == 0 {
+			amt = "-0.07"
+		}
+		ts = append(ts, TransactionCSV{ID: fmt.Sprint(i), Date: fmt.Sprintf("7/%d/2021", 1+i%28), Transaction: amt, Description: fmt.Sprintf("row %d", i%5)})
+	}
+	return ts
+}
+
+func TestCheckpointResumeMatchesOnePass(t *testing.T) {
+	t.Setenv("CHECKPOINT_INTERVAL", "250")
+	withConfig(t, nil)
+	ts := driftingTransactions()
+
+	want, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeS3{}
+	ev := s3Event("b", "ledger.csv", "etag-1", 0)
+	if _, err := getSummaries(ts, summaryOptions{checkpoint: newCheckpointer(&flakyS3{fakeS3: store, puts: 5}, ev)}); err == nil {
+		t.Fatal("first run finished, want it to fail after 5 checkpoints")
+	}
+	cp := newCheckpointer(store, ev)
+	saved, ok, err := cp.Load()
+	if err != nil || !ok || saved.Rows != 5*250 {
+		t.Fatalf("checkpoint = %d rows, %v, %v, want 1250 rows", saved.Rows, ok, err)
+	}
+
+	got, err := getSummaries(ts, summaryOptions{checkpoint: cp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CreditTotal != want.CreditTotal || got.DebitTotal != want.DebitTotal {
+		t.Errorf("resumed totals = %v, %v, want exactly the one pass %v, %v", got.CreditTotal, got.DebitTotal, want.CreditTotal, want.DebitTotal)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed summary = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestCheckpointIgnoresOtherVersion(t *testing.T) {
+	t.Setenv("CHECKPOINT_INTERVAL", "1")
+	withConfig(t, nil)
+	store := &fakeS3{}
+
+	if _, err := getSummaries(sampleTransactions, summaryOptions{checkpoint: newCheckpointer(store, s3Event("b", "a.csv", "v1", 0))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := newCheckpointer(store, s3Event("b", "a.csv", "v2", 0)).Load(); ok || err != nil {
+		t.Errorf("Load for a new ETag = %v, %v, want no checkpoint", ok, err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultHeaderAliases are the header names we recognize for each canonical field. Matching ignores
+// case and surrounding space.
+var defaultHeaderAliases = map[string][]string{
+	"ID":          {"id", "transactionid", "transaction_id", "ref"},
+	"Date":        {"date", "transactiondate", "transaction_date"},
+	"Transaction": {"transaction", "amount"},
+	"Status":      {"status"},
+	"Description": {"description", "memo", "reference"},
+	"Account":     {"account", "accountid", "account_id"},
+	"Category":    {"category"},
+}
+
+// columns holds the index of every canonical field in a file. Optional fields are -1 when absent.
+type columns struct {
+	ID          int
+	Date        int
+	Transaction int
+	Status      int
+	Description int
+	Account     int
+	Category    int
+}
+
+// headerAliases returns the alias map, with any entries from HEADER_ALIASES replacing the defaults
+// for that field. HEADER_ALIASES is JSON, e.g. `{"ID": ["Id", "TransactionId", "ref"]}`.
+func headerAliases() (map[string][]string, error) {
+	aliases := make(map[string][]string, len(defaultHeaderAliases))
+	for k, v := range defaultHeaderAliases {
+		aliases[k] = v
+	}
+
+	v := getenv("HEADER_ALIASES")
+	if v == "" {
+		return aliases, nil
+	}
+
+	var custom map[string][]string
+	if err := json.Unmarshal([]byte(v), &custom); err != nil {
+		return nil, fmt.Errorf("invalid HEADER_ALIASES: %w", err)
+	}
+	for k, names := range custom {
+		if _, ok := defaultHeaderAliases[k]; !ok {
+			return nil, fmt.Errorf("invalid HEADER_ALIASES: unknown field %q", k)
+		}
+		aliases[k] = names
+	}
+
+	return aliases, nil
+}
+
+// mapColumns builds the column index map for `header`. When none of the aliases for a required field
+// match, the field falls back to its original position (ID, Date, Transaction), as in files from
+// before headers were read at all.
+func mapColumns(header []string, aliases map[string][]string) columns {
+	c := columns{
+		ID:          findColumn(header, aliases["ID"]...),
+		Date:        findColumn(header, aliases["Date"]...),
+		Transaction: findColumn(header, aliases["Transaction"]...),
+		Status:      findColumn(header, aliases["Status"]...),
+		Description: findColumn(header, aliases["Description"]...),
+		Account:     findColumn(header, aliases["Account"]...),
+		Category:    findColumn(header, aliases["Category"]...),
+	}
+	if c.ID < 0 {
+		c.ID = 0
+	}
+	if c.Date < 0 {
+		c.Date = 1
+	}
+	if c.Transaction < 0 {
+		c.Transaction = 2
+	}
+
+	return c
+}
+
+// findColumn returns the index of the first header matching any of `names`, ignoring case and
+// surrounding space, or -1 when the file doesn't have that column.
+func findColumn(header []string, names ...string) int {
+	for i, h := range header {
+		for _, n := range names {
+			if strings.EqualFold(strings.TrimSpace(h), n) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// columnSampleSize is how many rows checkColumns looks at.
+const columnSampleSize = 20
+
+// checkColumns looks at the first rows of a file for dates in the amount column and amounts in the
+// date column, which is what a partner swapping the two columns produces. Left alone, those rows
+// fail with a confusing date error or, worse, add up to garbage. The columns are only reported as
+// swapped when most of the sample reads that way, so a few bad rows are still left to the row checks.
+func checkColumns(ts []TransactionCSV, header []string, cols columns) error {
+	sample := ts
+	if len(sample) > columnSampleSize {
+		sample = sample[:columnSampleSize]
+	}
+
+	swapped := 0
+	for _, t := range sample {
+		if !isDate(t.Date) && isAmount(t.Date) && isDate(t.Transaction) && !isAmount(t.Transaction) {
+			swapped++
+		}
+	}
+	if len(sample) > 0 && swapped*2 > len(sample) {
+		return fmt.Errorf("%w: %d of the first %d rows have a date in column %q and an amount in column %q",
+			ErrSwappedColumns, swapped, len(sample), columnName(header, cols.Transaction), columnName(header, cols.Date))
+	}
+
+	return nil
+}
+
+func isDate(s string) bool {
+	_, ok := getDate(s)
+	return ok
+}
+
+func isAmount(s string) bool {
+	raw, _ := normalizeAmount(s)
+	_, err := strconv.ParseFloat(raw, 64)
+	return err == nil
+}
+
+// columnName is the header of column `i`, or its 1 based position when the header is too short.
+func columnName(header []string, i int) string {
+	if i < len(header) {
+		return strings.TrimSpace(header[i])
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
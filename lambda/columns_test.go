@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapColumnsDefaultAliases(t *testing.T) {
+	withConfig(t, nil)
+	aliases, err := headerAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := mapColumns([]string{"Amount", " REF ", "Transaction_Date", "Memo", "Account_ID"}, aliases)
+	want := columns{ID: 1, Date: 2, Transaction: 0, Status: -1, Description: 3, Account: 4, Category: -1}
+	if got != want {
+		t.Errorf("mapColumns = %+v, want %+v", got, want)
+	}
+
+	// headers we don't recognize leave the required fields where the original format had them
+	got = mapColumns([]string{"a", "b", "c"}, aliases)
+	if got.ID != 0 || got.Date != 1 || got.Transaction != 2 || got.Status != -1 {
+		t.Errorf("mapColumns of unknown headers = %+v, want the original positions", got)
+	}
+}
+
+func TestReadCSVHeaderAliases(t *testing.T) {
+	t.Setenv("HEADER_ALIASES", `{"ID": ["Nr"], "Transaction": ["Betrag"], "Date": ["Datum"]}`)
+	withConfig(t, nil)
+
+	ts, err := readCSV(strings.NewReader("Betrag,Datum,Nr\n+60.5,7/15,A1\n-10.3,7/28,A2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TransactionCSV{
+		{ID: "A1", Date: "7/15", Transaction: "+60.5", Row: 1},
+		{ID: "A2", Date: "7/28", Transaction: "-10.3", Row: 2},
+	}
+	if !reflect.DeepEqual(ts, want) {
+		t.Errorf("readCSV = %+v, want %+v", ts, want)
+	}
+}
+
+func TestHeaderAliasesReplaceDefaults(t *testing.T) {
+	t.Setenv("HEADER_ALIASES", `{"Transaction": ["Betrag"]}`)
+	withConfig(t, nil)
+
+	aliases, err := headerAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(aliases["Transaction"], []string{"Betrag"}) || !reflect.DeepEqual(aliases["ID"], defaultHeaderAliases["ID"]) {
+		t.Errorf("aliases = %v, want only Transaction replaced", aliases)
+	}
+}
+
+func TestHeaderAliasesInvalid(t *testing.T) {
+	for _, v := range []string{`{"Transaction": "Betrag"}`, `{"Amount": ["Betrag"]}`, `not json`} {
+		t.Setenv("HEADER_ALIASES", v)
+		withConfig(t, nil)
+
+		if _, err := headerAliases(); err == nil || !strings.Contains(err.Error(), "HEADER_ALIASES") {
+			t.Errorf("%s: headerAliases error = %v, want it rejected", v, err)
+		}
+	}
+}
+
+func TestReadCSVSwappedColumns(t *testing.T) {
+	withConfig(t, nil)
+
+	// the header says Date then Transaction, but the rows have them the other way round
+	_, err := readCSV(strings.NewReader("Id,Date,Transaction\n0,+60.5,7/15\n1,-10.3,7/28\n2,-20.46,8/2\n"))
+	if !errors.Is(err, ErrSwappedColumns) || !strings.Contains(err.Error(), `date in column "Transaction" and an amount in column "Date"`) {
+		t.Errorf("readCSV error = %v, want ErrSwappedColumns naming both columns", err)
+	}
+
+	// a minority of odd rows is left to the row checks
+	ts, err := readCSV(strings.NewReader("Id,Date,Transaction\n0,7/15,+60.5\n1,7/28,-10.3\n2,-20.46,8/2\n"))
+	if err != nil || len(ts) != 3 {
+		t.Errorf("readCSV = %d rows, %v, want all 3 read", len(ts), err)
+	}
+}
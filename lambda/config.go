@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Config holds the settings that can live in a single JSON document in S3 instead of one environment
+// variable each. Every field is tagged with the environment variable it stands in for, and that
+// variable still wins when it is set. Fields left out of the document keep the usual defaults.
+// Switches and numbers are pointers, so a document can set one to false or 0 explicitly.
+type Config struct {
+	EmailTier                string              `json:"email_tier,omitempty" env:"EMAIL_TIER"`
+	CSVEncoding              string              `json:"csv_encoding,omitempty" env:"CSV_ENCODING"`
+	CSVDelimiter             string              `json:"csv_delimiter,omitempty" env:"CSV_DELIMITER"`
+	TrimFields               *bool               `json:"trim_fields,omitempty" env:"TRIM_FIELDS"`
+	HeaderAliases            map[string][]string `json:"header_aliases,omitempty" env:"HEADER_ALIASES"`
+	RepeatedHeaders          string              `json:"repeated_headers,omitempty" env:"REPEATED_HEADERS"`
+	SettledStatuses          []string            `json:"settled_statuses,omitempty" env:"SETTLED_STATUSES"`
+	AmountSignConvention     string              `json:"amount_sign_convention,omitempty" env:"AMOUNT_SIGN_CONVENTION"`
+	StrictAmountFormat       *bool               `json:"strict_amount_format,omitempty" env:"STRICT_AMOUNT_FORMAT"`
+	MinAbsAmount             *float64            `json:"min_abs_amount,omitempty" env:"MIN_ABS_AMOUNT"`
+	MonthFormat              string              `json:"month_format,omitempty" env:"MONTH_FORMAT"`
+	CurrencyLocale           string              `json:"currency_locale,omitempty" env:"CURRENCY_LOCALE"`
+	RoundingMode             string              `json:"rounding_mode,omitempty" env:"ROUNDING_MODE"`
+	TotalLabel               string              `json:"total_label,omitempty" env:"TOTAL_LABEL"`
+	Greeting                 string              `json:"greeting,omitempty" env:"GREETING"`
+	SignOff                  string              `json:"signoff,omitempty" env:"SIGNOFF"`
+	SubjectPrefix            string              `json:"subject_prefix,omitempty" env:"SUBJECT_PREFIX"`
+	ReplyTo                  string              `json:"reply_to,omitempty" env:"REPLY_TO"`
+	BCCAddress               string              `json:"bcc_address,omitempty" env:"BCC_ADDRESS"`
+	NoActivityEmail          *bool               `json:"no_activity_email,omitempty" env:"NO_ACTIVITY_EMAIL"`
+	IncludeDayOfMonth        *bool               `json:"include_day_of_month,omitempty" env:"INCLUDE_DAY_OF_MONTH"`
+	IncludeVelocity          *bool               `json:"include_velocity,omitempty" env:"INCLUDE_VELOCITY"`
+	PerYearAverages          *bool               `json:"per_year_averages,omitempty" env:"PER_YEAR_AVERAGES"`
+	AllowedSources           []string            `json:"allowed_sources,omitempty" env:"ALLOWED_SOURCES"`
+	RecipientsTable          string              `json:"recipients_table,omitempty" env:"RECIPIENTS_TABLE"`
+	MailSink                 string              `json:"mail_sink,omitempty" env:"MAIL_SINK"`
+	MailFilePath             string              `json:"mail_file_path,omitempty" env:"MAIL_FILE_PATH"`
+	SMTPPort                 string              `json:"smtp_port,omitempty" env:"SMTP_PORT"`
+	SMTPTimeout              string              `json:"smtp_timeout,omitempty" env:"SMTP_TIMEOUT"`
+	CheckpointInterval       *int                `json:"checkpoint_interval,omitempty" env:"CHECKPOINT_INTERVAL"`
+	WriteSummary             *bool               `json:"write_summary,omitempty" env:"WRITE_SUMMARY"`
+	CompressOutput           *bool               `json:"compress_output,omitempty" env:"COMPRESS_OUTPUT"`
+	UploadRendered           *bool               `json:"upload_rendered,omitempty" env:"UPLOAD_RENDERED"`
+	Dedup                    string              `json:"dedup,omitempty" env:"DEDUP"`
+	LargeTxnThreshold        *float64            `json:"large_txn_threshold,omitempty" env:"LARGE_TXN_THRESHOLD"`
+	DownloadPartSize         *int                `json:"download_part_size,omitempty" env:"DOWNLOAD_PART_SIZE"`
+	DownloadConcurrency      *int                `json:"download_concurrency,omitempty" env:"DOWNLOAD_CONCURRENCY"`
+	DownloadProgressInterval string              `json:"download_progress_interval,omitempty" env:"DOWNLOAD_PROGRESS_INTERVAL"`
+	SignSummary              *bool               `json:"sign_summary,omitempty" env:"SIGN_SUMMARY"`
+	SigningSecret            string              `json:"signing_secret,omitempty" env:"SIGNING_SECRET"`
+	DescriptionMaxLength     *int                `json:"description_max_length,omitempty" env:"DESCRIPTION_MAX_LENGTH"`
+	MaxRetries               *int                `json:"max_retries,omitempty" env:"MAX_RETRIES"`
+	RetryBaseMS              *int                `json:"retry_base_ms,omitempty" env:"RETRY_BASE_MS"`
+	Locale                   string              `json:"locale,omitempty" env:"LOCALE"`
+	RejectFutureDates        string              `json:"reject_future_dates,omitempty" env:"REJECT_FUTURE_DATES"`
+	SendConcurrency          *int                `json:"send_concurrency,omitempty" env:"SEND_CONCURRENCY"`
+	ExcludeIDs               []string            `json:"exclude_ids,omitempty" env:"EXCLUDE_IDS"`
+	FromName                 string              `json:"from_name,omitempty" env:"FROM_NAME"`
+	DigestWindow             string              `json:"digest_window,omitempty" env:"DIGEST_WINDOW"`
+	DigestBucket             string              `json:"digest_bucket,omitempty" env:"DIGEST_BUCKET"`
+	SMTPHostAllowlist        []string            `json:"smtp_host_allowlist,omitempty" env:"SMTP_HOST_ALLOWLIST"`
+	SMTPHostStrict           *bool               `json:"smtp_host_strict,omitempty" env:"SMTP_HOST_STRICT"`
+	FXBaseCurrency           string              `json:"fx_base_currency,omitempty" env:"FX_BASE_CURRENCY"`
+	FXRate                   *float64            `json:"fx_rate,omitempty" env:"FX_RATE"`
+	FXRateDate               string              `json:"fx_rate_date,omitempty" env:"FX_RATE_DATE"`
+	FXRateURL                string              `json:"fx_rate_url,omitempty" env:"FX_RATE_URL"`
+	IncludeTransactions      *bool               `json:"include_transactions,omitempty" env:"INCLUDE_TRANSACTIONS"`
+	TransactionsMaxRows      *int                `json:"transactions_max_rows,omitempty" env:"TRANSACTIONS_MAX_ROWS"`
+	DeferOnSecretError       *bool               `json:"defer_on_secret_error,omitempty" env:"DEFER_ON_SECRET_ERROR"`
+	VariableFields           *bool               `json:"variable_fields,omitempty" env:"VARIABLE_FIELDS"`
+	RecurringMinMonths       *int                `json:"recurring_min_months,omitempty" env:"RECURRING_MIN_MONTHS"`
+	TagProcessed             *bool               `json:"tag_processed,omitempty" env:"TAG_PROCESSED"`
+	TagKeys                  map[string]string   `json:"tag_keys,omitempty" env:"TAG_KEYS"`
+	MaxRows                  *int                `json:"max_rows,omitempty" env:"MAX_ROWS"`
+	PublishEvents            *bool               `json:"publish_events,omitempty" env:"PUBLISH_EVENTS"`
+	EventBusName             string              `json:"event_bus_name,omitempty" env:"EVENT_BUS_NAME"`
+	EventDetailType          string              `json:"event_detail_type,omitempty" env:"EVENT_DETAIL_TYPE"`
+	EventPublishFatal        *bool               `json:"event_publish_fatal,omitempty" env:"EVENT_PUBLISH_FATAL"`
+	EmailExtra               map[string]string   `json:"email_extra,omitempty" env:"EMAIL_EXTRA"`
+	SendRate                 *float64            `json:"send_rate,omitempty" env:"SEND_RATE"`
+	SendBurst                *int                `json:"send_burst,omitempty" env:"SEND_BURST"`
+	MonthNames               []string            `json:"month_names,omitempty" env:"MONTH_NAMES"`
+	IncludeEmptyMonths       *bool               `json:"include_empty_months,omitempty" env:"INCLUDE_EMPTY_MONTHS"`
+	SourceRoleARN            string              `json:"source_role_arn,omitempty" env:"SOURCE_ROLE_ARN"`
+	AccountType              string              `json:"account_type,omitempty" env:"ACCOUNT_TYPE"`
+	StatementDate            string              `json:"statement_date,omitempty" env:"STATEMENT_DATE"`
+	Quarantine               *bool               `json:"quarantine,omitempty" env:"QUARANTINE"`
+	QuarantinePrefix         string              `json:"quarantine_prefix,omitempty" env:"QUARANTINE_PREFIX"`
+	AmountLocale             string              `json:"amount_locale,omitempty" env:"AMOUNT_LOCALE"`
+	AmountLocales            map[string]string   `json:"amount_locales,omitempty" env:"AMOUNT_LOCALES"`
+	RefundWindowDays         *int                `json:"refund_window_days,omitempty" env:"REFUND_WINDOW_DAYS"`
+	MaxBodyBytes             *int                `json:"max_body_bytes,omitempty" env:"MAX_BODY_BYTES"`
+	SuppressAccounts         []string            `json:"suppress_accounts,omitempty" env:"SUPPRESS_ACCOUNTS"`
+	TransferThreshold        *float64            `json:"transfer_threshold,omitempty" env:"TRANSFER_THRESHOLD"`
+	TransferRoundTo          *float64            `json:"transfer_round_to,omitempty" env:"TRANSFER_ROUND_TO"`
+	TransferDescriptions     []string            `json:"transfer_descriptions,omitempty" env:"TRANSFER_DESCRIPTIONS"`
+	HighWaterTable           string              `json:"high_water_table,omitempty" env:"HIGH_WATER_TABLE"`
+	HighWaterBy              string              `json:"high_water_by,omitempty" env:"HIGH_WATER_BY"`
+	AccountSummaryKey        string              `json:"account_summary_key,omitempty" env:"ACCOUNT_SUMMARY_KEY"`
+	DryRun                   *bool               `json:"dry_run,omitempty" env:"DRY_RUN"`
+	PreviewAddress           string              `json:"preview_address,omitempty" env:"PREVIEW_ADDRESS"`
+	EventConfigurationID     string              `json:"event_configuration_id,omitempty" env:"EVENT_CONFIGURATION_ID"`
+	EventMaxAge              string              `json:"event_max_age,omitempty" env:"EVENT_MAX_AGE"`
+	IncludeStatementLink     *bool               `json:"include_statement_link,omitempty" env:"INCLUDE_STATEMENT_LINK"`
+	StatementURL             string              `json:"statement_url,omitempty" env:"STATEMENT_URL"`
+	IncludeStatementQR       *bool               `json:"include_statement_qr,omitempty" env:"INCLUDE_STATEMENT_QR"`
+	CategoryTotals           *bool               `json:"category_totals,omitempty" env:"CATEGORY_TOTALS"`
+	MaxLineBytes             *int                `json:"max_line_bytes,omitempty" env:"MAX_LINE_BYTES"`
+}
+
+// config is the document loaded by loadConfig. It stays cached for the life of the execution
+// environment, so warm invocations don't fetch it again.
+var config struct {
+	mu     sync.Mutex
+	loaded *Config
+	// features are read from `loaded` on first use.
+	features *Features
+}
+
+// loadConfig fetches the JSON document at CONFIG_BUCKET/CONFIG_KEY the first time it is called.
+// Without CONFIG_BUCKET and CONFIG_KEY every setting comes from the environment alone.
+func loadConfig(svc s3iface.S3API) error {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	if config.loaded != nil {
+		return nil
+	}
+
+	bucket, key := os.Getenv("CONFIG_BUCKET"), os.Getenv("CONFIG_KEY")
+	if bucket == "" || key == "" {
+		config.loaded = &Config{}
+		return nil
+	}
+
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("loading config s3://%s/%s: %w", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	var c Config
+	dec := json.NewDecoder(out.Body)
+	// a typo in a setting name should fail loudly rather than silently falling back to a default
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return fmt.Errorf("parsing config s3://%s/%s: %w", bucket, key, err)
+	}
+
+	config.loaded = &c
+	return nil
+}
+
+// getenv returns the setting `name` from the environment, falling back to the loaded config document.
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	config.mu.Lock()
+	c := config.loaded
+	config.mu.Unlock()
+	if c == nil {
+		return ""
+	}
+
+	v, _ := c.lookup(name)
+	return v
+}
+
+// lookup returns the field tagged `env:"name"` in the same text form the environment variable takes.
+// Unset fields report false.
+func (c *Config) lookup(name string) (string, bool) {
+	rv := reflect.ValueOf(c).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).Tag.Get("env") != name {
+			continue
+		}
+
+		f := rv.Field(i)
+		if f.IsZero() {
+			return "", false
+		}
+		if f.Kind() == reflect.Ptr {
+			f = f.Elem()
+		}
+
+		switch f.Kind() {
+		case reflect.String:
+			return f.String(), true
+		case reflect.Bool:
+			return strconv.FormatBool(f.Bool()), true
+		case reflect.Int:
+			return strconv.FormatInt(f.Int(), 10), true
+		case reflect.Float64:
+			return strconv.FormatFloat(f.Float(), 'f', -1, 64), true
+		case reflect.Slice:
+			return strings.Join(f.Interface().([]string), ","), true
+		case reflect.Map:
+			b, err := json.Marshal(f.Interface())
+			if err != nil {
+				return "", false
+			}
+			return string(b), true
+		}
+	}
+
+	return "", false
+}
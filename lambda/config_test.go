@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// useConfigDocument points CONFIG_BUCKET and CONFIG_KEY at `doc` in the returned fakeS3, with nothing
+// loaded yet.
+func useConfigDocument(t *testing.T, doc string) *fakeS3 {
+	t.Helper()
+
+	t.Setenv("CONFIG_BUCKET", "settings")
+	t.Setenv("CONFIG_KEY", "stori.json")
+	withConfig(t, nil)
+	store := &fakeS3{}
+	store.put("settings", "stori.json", storedObject{Body: []byte(doc), ContentType: "application/json"})
+	return store
+}
+
+func TestLoadConfig(t *testing.T) {
+	store := useConfigDocument(t, `{
+		"csv_delimiter": ";",
+		"trim_fields": false,
+		"settled_statuses": ["settled", "posted"],
+		"header_aliases": {"Transaction": ["Amount"]},
+		"send_rate": 2.5,
+		"send_concurrency": 4
+	}`)
+	if err := loadConfig(store); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"CSV_DELIMITER":    ";",
+		"TRIM_FIELDS":      "false",
+		"SETTLED_STATUSES": "settled,posted",
+		"HEADER_ALIASES":   `{"Transaction":["Amount"]}`,
+		"SEND_RATE":        "2.5",
+		"SEND_CONCURRENCY": "4",
+		"MONTH_FORMAT":     "",
+	} {
+		if got := getenv(name); got != want {
+			t.Errorf("getenv(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigExplicitZero(t *testing.T) {
+	store := useConfigDocument(t, `{"retry_base_ms": 0, "min_abs_amount": 0, "max_retries": 0}`)
+	if err := loadConfig(store); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"RETRY_BASE_MS", "MIN_ABS_AMOUNT", "MAX_RETRIES"} {
+		if got := getenv(name); got != "0" {
+			t.Errorf("getenv(%s) = %q, want the document's 0", name, got)
+		}
+	}
+	if got := envIntMin("RETRY_BASE_MS", defaultRetryBaseMS, 0); got != 0 {
+		t.Errorf("RETRY_BASE_MS = %d, want 0 rather than the default", got)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	store := useConfigDocument(t, `{"month_format": "short", "send_concurrency": 4, "retry_base_ms": 0}`)
+	t.Setenv("MONTH_FORMAT", "long")
+	t.Setenv("RETRY_BASE_MS", "50")
+	if err := loadConfig(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// the environment wins when it is set, and the document fills in the rest
+	for name, want := range map[string]string{
+		"MONTH_FORMAT":     "long",
+		"RETRY_BASE_MS":    "50",
+		"SEND_CONCURRENCY": "4",
+	} {
+		if got := getenv(name); got != want {
+			t.Errorf("getenv(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigCached(t *testing.T) {
+	store := useConfigDocument(t, `{"month_format": "short"}`)
+	if err := loadConfig(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// a warm invocation keeps the document it already has
+	store.put("settings", "stori.json", storedObject{Body: []byte(`{"month_format": "long"}`)})
+	if err := loadConfig(store); err != nil {
+		t.Fatal(err)
+	}
+	if got := getenv("MONTH_FORMAT"); got != "short" {
+		t.Errorf("MONTH_FORMAT = %q, want the first document's", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	store := useConfigDocument(t, `{"month_fromat": "short"}`)
+	if err := loadConfig(store); err == nil || !strings.Contains(err.Error(), "month_fromat") {
+		t.Errorf("loadConfig = %v, want the unknown key rejected", err)
+	}
+
+	store = useConfigDocument(t, `{}`)
+	store.objects = nil
+	err := loadConfig(store)
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != s3.ErrCodeNoSuchKey || !strings.Contains(err.Error(), "s3://settings/stori.json") {
+		t.Errorf("loadConfig = %v, want NoSuchKey for the document", err)
+	}
+}
+
+func TestLoadConfigWithoutDocument(t *testing.T) {
+	withConfig(t, nil)
+
+	// nothing is fetched, so a nil client is fine
+	if err := loadConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := getenv("MONTH_FORMAT"); got != "" {
+		t.Errorf("MONTH_FORMAT = %q, want unset", got)
+	}
+}
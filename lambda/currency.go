@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// currencyFormat describes how a locale writes money: which symbol, on which side, and which
+// characters separate thousands and decimals.
+type currencyFormat struct {
+	Symbol  string
+	Suffix  bool
+	Group   string
+	Decimal string
+}
+
+// currencyFormats are the locales we know how to render, keyed by lower case BCP 47 tag.
+var currencyFormats = map[string]currencyFormat{
+	"en-us": {Symbol: "$", Group: ",", Decimal: "."},
+	"es-mx": {Symbol: "$", Group: ",", Decimal: "."},
+	"en-gb": {Symbol: "£", Group: ",", Decimal: "."},
+	"de-de": {Symbol: "€", Suffix: true, Group: ".", Decimal: ","},
+	"es-es": {Symbol: "€", Suffix: true, Group: ".", Decimal: ","},
+	"fr-fr": {Symbol: "€", Suffix: true, Group: " ", Decimal: ","},
+}
+
+// defaultCurrencyLocale is used when CURRENCY_LOCALE isn't set.
+const defaultCurrencyLocale = "en-US"
+
+// getCurrencyFormat returns the format for `loc`, falling back to CURRENCY_LOCALE when it's empty.
+func getCurrencyFormat(loc string) (currencyFormat, error) {
+	if loc == "" {
+		loc = getenv("CURRENCY_LOCALE")
+	}
+	if loc == "" {
+		loc = defaultCurrencyLocale
+	}
+
+	cf, ok := currencyFormats[strings.ToLower(strings.ReplaceAll(loc, "_", "-"))]
+	if !ok {
+		return currencyFormat{}, fmt.Errorf("unsupported currency locale %q", loc)
+	}
+	return cf, nil
+}
+
+// Format renders `v`, already rounded to cents with the ROUNDING_MODE, e.g. `-$1,234.50` or `-1.234,50 €`.
+func (cf currencyFormat) Format(v float64) string {
+	sign, num := cf.number(v)
+	if cf.Suffix {
+		return sign + num + " " + cf.Symbol
+	}
+	return sign + cf.Symbol + num
+}
+
+// FormatCode renders `v` with the locale's separators but an ISO currency code instead of its symbol,
+// e.g. `-1,234.50 USD`, for amounts that aren't in the locale's own currency.
+func (cf currencyFormat) FormatCode(v float64, code string) string {
+	sign, num := cf.number(v)
+	return sign + num + " " + code
+}
+
+// number returns the sign and the grouped digits of `v` with two decimals. It doesn't round `v`
+// itself, which is the ROUNDING_MODE's job before the value reaches a template. The sign is left off
+// when every digit is zero, so the -0 that rounding can leave is `0.00` rather than `-0.00`.
+func (cf currencyFormat) number(v float64) (string, string) {
+	s := strconv.FormatFloat(math.Abs(v), 'f', 2, 64)
+	sign := ""
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		sign = "-"
+	}
+	whole, frac := s[:len(s)-3], s[len(s)-2:]
+
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(cf.Group)
+		}
+		b.WriteRune(r)
+	}
+	return sign, b.String() + cf.Decimal + frac
+}
+
+// amountFormat returns the locale amounts in the file at `key` are written in. AMOUNT_LOCALES maps key
+// prefixes to locales, e.g. `{"csv/acme/": "de-DE"}`, so partners with different number formats can
+// share a deployment; the longest matching prefix wins. Keys without a match use AMOUNT_LOCALE. With
+// neither, it returns nil and amounts are read as plain `1234.50` numbers.
+func amountFormat(key string) (*currencyFormat, error) {
+	loc := getenv("AMOUNT_LOCALE")
+	if v := getenv("AMOUNT_LOCALES"); v != "" {
+		var prefixes map[string]string
+		if err := json.Unmarshal([]byte(v), &prefixes); err != nil {
+			return nil, fmt.Errorf("invalid AMOUNT_LOCALES: %w", err)
+		}
+		best := -1
+		for p, l := range prefixes {
+			if strings.HasPrefix(key, p) && len(p) > best {
+				best, loc = len(p), l
+			}
+		}
+	}
+	if loc == "" {
+		return nil, nil
+	}
+
+	cf, ok := currencyFormats[strings.ToLower(strings.ReplaceAll(loc, "_", "-"))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported amount locale %q", loc)
+	}
+	return &cf, nil
+}
+
+// plainAmount rewrites an amount in the locale's format, like `1.234,50`, as `1234.50`. Locales that
+// group with a space of some kind accept any of them, since exports mix plain, non-breaking and narrow
+// spaces.
+func (cf currencyFormat) plainAmount(s string) string {
+	if strings.TrimSpace(cf.Group) == "" {
+		s = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, s)
+	} else {
+		s = strings.ReplaceAll(s, cf.Group, "")
+	}
+	return strings.ReplaceAll(s, cf.Decimal, ".")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCurrencyFormat(t *testing.T) {
+	withConfig(t, nil)
+
+	for _, tc := range []struct {
+		locale string
+		v      float64
+		want   string
+	}{
+		{"en-US", 1234.5, "$1,234.50"},
+		{"en-US", -1234.5, "-$1,234.50"},
+		{"en_us", 1234567.89, "$1,234,567.89"},
+		{"de-DE", 1234.5, "1.234,50 €"},
+		{"de-DE", -1234.5, "-1.234,50 €"},
+		{"fr-FR", 1000, "1\u202f000,00 €"},
+		{"en-GB", 0.5, "£0.50"},
+		// the -0 that rounding a small debit leaves has no sign
+		{"en-US", math.Copysign(0, -1), "$0.00"},
+		{"de-DE", -0.001, "0,00 €"},
+		{"en-US", -0.01, "-$0.01"},
+	} {
+		cf, err := getCurrencyFormat(tc.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cf.Format(tc.v); got != tc.want {
+			t.Errorf("%s Format(%v) = %q, want %q", tc.locale, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestCurrencyFormatCode(t *testing.T) {
+	cf, err := getCurrencyFormat("de-DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.FormatCode(-1234.5, "USD"); got != "-1.234,50 USD" {
+		t.Errorf("FormatCode = %q", got)
+	}
+}
+
+func TestCurrencyFormatDefault(t *testing.T) {
+	withConfig(t, nil)
+
+	cf, err := getCurrencyFormat("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.Format(-30.76); got != "-$30.76" {
+		t.Errorf("default Format = %q, want en-US", got)
+	}
+
+	t.Setenv("CURRENCY_LOCALE", "es-ES")
+	if cf, _ = getCurrencyFormat(""); cf.Format(-30.76) != "-30,76 €" {
+		t.Errorf("CURRENCY_LOCALE Format = %q, want es-ES", cf.Format(-30.76))
+	}
+	if _, err := getCurrencyFormat("xx-XX"); err == nil {
+		t.Error("getCurrencyFormat accepted an unknown locale")
+	}
+}
+
+func TestAmountFormatPartners(t *testing.T) {
+	t.Setenv("AMOUNT_LOCALES", `{"csv/acme/": "de-DE", "csv/acme/paris/": "fr-FR", "csv/globex/": "en_US"}`)
+	withConfig(t, nil)
+
+	for key, want := range map[string]*currencyFormat{
+		"csv/acme/july.csv":       {Symbol: "€", Suffix: true, Group: ".", Decimal: ","},
+		"csv/acme/paris/july.csv": {Symbol: "€", Suffix: true, Group: "\u202f", Decimal: ","},
+		"csv/globex/july.csv":     {Symbol: "$", Group: ",", Decimal: "."},
+		"csv/initech/july.csv":    nil,
+	} {
+		got, err := amountFormat(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Errorf("amountFormat(%s) = %+v, want %+v", key, got, want)
+		}
+	}
+
+	// AMOUNT_LOCALE covers the keys no prefix matches
+	t.Setenv("AMOUNT_LOCALE", "en-GB")
+	withConfig(t, nil)
+	if got, err := amountFormat("csv/initech/july.csv"); err != nil || got == nil || got.Symbol != "£" {
+		t.Errorf("amountFormat = %+v, %v, want en-GB", got, err)
+	}
+}
+
+func TestAmountFormatInvalid(t *testing.T) {
+	for _, v := range []string{`{"csv/acme/": "de-CH"}`, `["de-DE"]`} {
+		t.Setenv("AMOUNT_LOCALES", v)
+		withConfig(t, nil)
+
+		if _, err := amountFormat("csv/acme/july.csv"); err == nil {
+			t.Errorf("AMOUNT_LOCALES=%s: amountFormat succeeded", v)
+		}
+	}
+}
+
+func TestGetSummariesPartnerLocales(t *testing.T) {
+	t.Setenv("AMOUNT_LOCALES", `{"csv/acme/": "de-DE", "csv/paris/": "fr-FR"}`)
+	withConfig(t, nil)
+
+	for key, amounts := range map[string][2]string{
+		"csv/acme/july.csv":  {"+1.234,50", "-60,25"},
+		"csv/paris/july.csv": {"+1 234,50", "-60,25"},
+	} {
+		number, err := amountFormat(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := []TransactionCSV{{ID: "0", Date: "7/15", Transaction: amounts[0]}, {ID: "1", Date: "7/28", Transaction: amounts[1]}}
+		sm, err := getSummaries(ts, summaryOptions{number: number})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.CreditTotal != 1234.5 || sm.DebitTotal != -60.25 {
+			t.Errorf("%s: credits, debits = %v, %v, want 1234.5, -60.25", key, sm.CreditTotal, sm.DebitTotal)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dedupMode decides which rows count as duplicates of an earlier one.
+type dedupMode string
+
+const (
+	// dedupOff keeps every row.
+	dedupOff dedupMode = ""
+	// dedupID drops rows whose Id was already seen.
+	dedupID dedupMode = "id"
+	// dedupTuple drops rows whose Id, Date and Transaction all match an earlier row.
+	dedupTuple dedupMode = "tuple"
+)
+
+// getDedupMode reads DEDUP, which is off by default.
+func getDedupMode() (dedupMode, error) {
+	m := dedupMode(strings.ToLower(getenv("DEDUP")))
+	switch m {
+	case dedupOff, dedupID, dedupTuple:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("unsupported DEDUP %q", m)
+}
+
+// key returns what `t` is compared on. The fields are joined with a separator that can't appear in
+// a CSV field after parsing.
+func (m dedupMode) key(t TransactionCSV) string {
+	if m == dedupID {
+		return t.ID
+	}
+	return t.ID + "\x00" + t.Date + "\x00" + t.Transaction
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// duplicated is sampleTransactions with transaction 1 exported twice and a second row reusing Id 2
+// for a different amount.
+var duplicated = append(append([]TransactionCSV{}, sampleTransactions...),
+	TransactionCSV{ID: "1", Date: "7/28", Transaction: "-10.3"},
+	TransactionCSV{ID: "2", Date: "8/3", Transaction: "-5"},
+)
+
+func TestGetSummariesDedup(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		deduped int
+		debits  float64
+	}{
+		{"", 0, -46.06},
+		{"id", 2, -30.76},
+		{"ID", 2, -30.76},
+		{"tuple", 1, -35.76},
+	} {
+		t.Setenv("DEDUP", tc.mode)
+		withConfig(t, nil)
+
+		sm, err := getSummaries(duplicated, summaryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Deduped != tc.deduped || math.Abs(sm.DebitTotal-tc.debits) > 1e-9 {
+			t.Errorf("DEDUP=%q: Deduped, DebitTotal = %d, %v, want %d, %v", tc.mode, sm.Deduped, sm.DebitTotal, tc.deduped, tc.debits)
+		}
+	}
+}
+
+func TestGetSummariesDedupInvalid(t *testing.T) {
+	t.Setenv("DEDUP", "description")
+	withConfig(t, nil)
+
+	if _, err := getSummaries(sampleTransactions, summaryOptions{}); err == nil || !strings.Contains(err.Error(), "DEDUP") {
+		t.Errorf("getSummaries error = %v, want DEDUP rejected", err)
+	}
+}
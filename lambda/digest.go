@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+// digestPrefix is where summaries wait for the scheduled digest, under `digests/<window>/<account>/`.
+const digestPrefix = "digests/"
+
+// digestWindowLayout formats the start of a window in digest keys.
+const digestWindowLayout = "20060102T150405Z"
+
+// noAccount stands in for the account of files without an Account column, which are emailed to the
+// address in EMAIL_SECRET like a single file would be.
+const noAccount = "_"
+
+// digestEntry is one file's summary for one account, stored until its window is emailed.
+type digestEntry struct {
+	Bucket  string
+	Key     string
+	ETag    string
+	Account string
+	Summary Summaries
+}
+
+// digestWindow reads DIGEST_WINDOW. Files are only summarized into digests when it is set.
+func digestWindow() (time.Duration, bool) {
+	w := envDuration("DIGEST_WINDOW", 0)
+	return w, w > 0
+}
+
+// digestBucket reads DIGEST_BUCKET, where digest entries are kept. Uploads and the digest handler both
+// require it, so entries are never stored somewhere the handler doesn't look.
+func digestBucket() (string, error) {
+	b := getenv("DIGEST_BUCKET")
+	if b == "" {
+		return "", fmt.Errorf("DIGEST_BUCKET must be set with DIGEST_WINDOW")
+	}
+	return b, nil
+}
+
+// windowKey returns the key prefix for the window of length `w` that `t` falls in.
+func windowKey(t time.Time, w time.Duration) string {
+	return digestPrefix + t.UTC().Truncate(w).Format(digestWindowLayout) + "/"
+}
+
+// storeDigest saves the summary of each account in `ts` for the window the upload falls in, instead of
+// emailing it straight away. Entries are keyed on the object's idempotencyKey, so a retried upload
+// replaces its own entry but a re-upload with different content is summarized as well.
+func storeDigest(up s3manageriface.UploaderAPI, obj s3Object, opts summaryOptions, ts []TransactionCSV, w time.Duration) error {
+	bucket, err := digestBucket()
+	if err != nil {
+		return err
+	}
+
+	ids, groups, err := groupByAccount(ts)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		ids, groups = []string{noAccount}, map[string][]TransactionCSV{noAccount: ts}
+	}
+
+	at := opts.asOf
+	if at.IsZero() {
+		at = time.Now()
+	}
+	prefix := windowKey(at, w)
+	for _, id := range ids {
+		sums, err := getSummaries(groups[id], opts)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", id, err)
+		}
+
+		b, err := json.Marshal(digestEntry{Bucket: obj.Bucket, Key: obj.Key, ETag: obj.ETag, Account: id, Summary: sums})
+		if err != nil {
+			return err
+		}
+		key := prefix + url.PathEscape(id) + "/" + url.PathEscape(obj.idempotencyKey()) + ".json"
+		if err := writeOutput(up, bucket, key, "application/json", b, false); err != nil {
+			return err
+		}
+	}
+
+	f := obj.fields()
+	f["window"] = prefix
+	f["accounts"] = len(ids)
+	logJSON("info", "stored for digest", f)
+	return nil
+}
+
+// HandleDigest is the scheduled handler for digest mode. Every run emails each account one summary per
+// complete window still stored before the event time, oldest first, then removes them so a retried
+// run doesn't send twice. Windows a late or failed run missed go out on the next one. Schedule it
+// at least once per DIGEST_WINDOW.
+func HandleDigest(ctx context.Context, ev events.CloudWatchEvent) error {
+	defer flush()
+
+	ctx, sp := tracer.Start(ctx, "HandleDigest")
+	err := handleDigest(ctx, ev)
+	endSpan(sp, err)
+	if err != nil {
+		logJSON("error", "digest failed", map[string]interface{}{"error": err.Error()})
+	}
+	return err
+}
+
+func handleDigest(ctx context.Context, ev events.CloudWatchEvent) error {
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return err
+	}
+	svc := s3.New(sess)
+	if err := loadConfig(svc); err != nil {
+		return err
+	}
+
+	w, ok := digestWindow()
+	if !ok {
+		return fmt.Errorf("DIGEST_WINDOW must be set for the digest handler")
+	}
+	bucket, err := digestBucket()
+	if err != nil {
+		return err
+	}
+
+	at := ev.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	var lookup RecipientLookup
+	if table := getenv("RECIPIENTS_TABLE"); table != "" {
+		lookup = newDynamoRecipients(dynamodb.New(sess), table)
+	}
+
+	return sendDigests(ctx, svc, bucket, windowKey(at, w), lookup)
+}
+
+// sendDigests emails every window stored in `bucket` before `current`, the window still open, in order.
+// An account that fails in one window is skipped in the later ones, so its windows still go out in
+// order once it is fixed.
+func sendDigests(ctx context.Context, svc s3iface.S3API, bucket, current string, lookup RecipientLookup) error {
+	windows, err := pendingWindows(svc, bucket, current)
+	if err != nil {
+		return err
+	}
+
+	var (
+		es         *emailSender
+		suppressed map[string]bool
+		limiter    = sendLimiter()
+		failed     = AccountErrors{}
+	)
+	for _, prefix := range windows {
+		entries, err := listDigest(svc, bucket, prefix)
+		if err != nil {
+			return err
+		}
+		logJSON("info", "sending digest", map[string]interface{}{"bucket": bucket, "window": prefix, "accounts": len(entries)})
+		if len(entries) == 0 {
+			continue
+		}
+
+		if es == nil {
+			if es, err = newEmailSender(ctx); err != nil {
+				return err
+			}
+			defer es.Close()
+			if suppressed, err = suppressedAccounts(svc); err != nil {
+				return err
+			}
+		}
+
+		ids := make([]string, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			if failed[id] != nil {
+				continue
+			}
+			if suppressed[id] {
+				logJSON("info", "account email suppressed", map[string]interface{}{"bucket": bucket, "window": prefix, "account": id})
+				removeDigest(svc, bucket, id, entries[id])
+				continue
+			}
+			if err := limiter.Wait(ctx); err != nil {
+				failed[id] = err
+				continue
+			}
+			if err := sendDigest(svc, bucket, id, entries[id], es, lookup); err != nil {
+				failed[id] = fmt.Errorf("window %s: %w", prefix, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// pendingWindows lists the window prefixes stored in `bucket` that sort before `current`, oldest first.
+// Window keys are fixed width UTC timestamps, so their order is the order of the windows.
+func pendingWindows(svc s3iface.S3API, bucket, current string) ([]string, error) {
+	var windows []string
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(digestPrefix),
+		Delimiter: aws.String("/"),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		for _, p := range out.CommonPrefixes {
+			if prefix := aws.StringValue(p.Prefix); prefix < current {
+				windows = append(windows, prefix)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, digestPrefix, err)
+	}
+
+	sort.Strings(windows)
+	return windows, nil
+}
+
+// listDigest returns the keys stored under `prefix`, grouped by account.
+func listDigest(svc s3iface.S3API, bucket, prefix string) (map[string][]string, error) {
+	entries := make(map[string][]string)
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(out *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range out.Contents {
+			key := aws.StringValue(o.Key)
+			rest := strings.TrimPrefix(key, prefix)
+			i := strings.IndexByte(rest, '/')
+			if i < 0 {
+				continue
+			}
+			id, err := url.PathUnescape(rest[:i])
+			if err != nil {
+				continue
+			}
+			entries[id] = append(entries[id], key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing s3://%s/%s: %w", bucket, prefix, err)
+	}
+
+	return entries, nil
+}
+
+// sendDigest merges the entries at `keys` and emails the result to account `id`, then deletes them.
+func sendDigest(svc s3iface.S3API, bucket, id string, keys []string, es *emailSender, lookup RecipientLookup) error {
+	var sm Summaries
+	listMax := transactionsListMax()
+	for _, key := range keys {
+		out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", key, err)
+		}
+		var de digestEntry
+		err = json.NewDecoder(out.Body).Decode(&de)
+		out.Body.Close()
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", key, err)
+		}
+		sm.merge(de.Summary, listMax)
+	}
+	sm.derive()
+
+	rc := Recipient{Email: es.ea.Username}
+	if id != noAccount {
+		if lookup == nil {
+			return fmt.Errorf("%w: account %s and no RECIPIENTS_TABLE", ErrNoRecipient, id)
+		}
+		var err error
+		if rc, err = lookup.Lookup(id); err != nil {
+			return err
+		}
+	}
+	if _, err := es.send(sm, rc); err != nil {
+		return err
+	}
+
+	removeDigest(svc, bucket, id, keys)
+	return nil
+}
+
+// removeDigest deletes the entries at `keys` once account `id` is done with them, so the window isn't
+// emailed again.
+func removeDigest(svc s3iface.S3API, bucket, id string, keys []string) {
+	var objs []*s3.ObjectIdentifier
+	for _, key := range keys {
+		objs = append(objs, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+	if _, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{Objects: objs, Quiet: aws.Bool(true)},
+	}); err != nil {
+		// the email is out, so this only risks a duplicate if the window is digested again
+		logJSON("warn", "removing digest entries failed", map[string]interface{}{"bucket": bucket, "account": id, "error": err.Error()})
+	}
+}
+
+// merge adds the aggregates of `o` into `sm`. Fields computed from the aggregates need a derive
+// afterwards. Percentile estimates and recurring charges can't be combined from the stored
+// results, so a merged summary has neither. The transactions of `o` are listed after those already
+// in `sm` up to `listMax` in all, like a single file's, with the rest counted as omitted. Its
+// sections are numbered on from those already in `sm`.
+func (sm *Summaries) merge(o Summaries, listMax int) {
+	sm.CreditCount += o.CreditCount
+	sm.CreditTotal += o.CreditTotal
+	sm.DebitCount += o.DebitCount
+	sm.DebitTotal += o.DebitTotal
+	sm.Excluded += o.Excluded
+	sm.FutureDated += o.FutureDated
+	sm.Deduped += o.Deduped
+	sm.Refunds += o.Refunds
+	sm.RefundTotal += o.RefundTotal
+	sm.Transfers += o.Transfers
+	sm.TransferTotal += o.TransferTotal
+	sm.RowErrors = append(sm.RowErrors, o.RowErrors...)
+	sm.LargeTransactions = append(sm.LargeTransactions, o.LargeTransactions...)
+	sm.TransactionsOmitted += o.TransactionsOmitted
+	for _, tx := range o.Transactions {
+		if len(sm.Transactions) < listMax {
+			sm.Transactions = append(sm.Transactions, tx)
+		} else {
+			sm.TransactionsOmitted++
+		}
+	}
+	for _, st := range o.Sections {
+		st.Section = len(sm.Sections) + 1
+		sm.Sections = append(sm.Sections, st)
+	}
+	sm.AmountPercentiles = nil
+	sm.Weekdays.Count += o.Weekdays.Count
+	sm.Weekdays.Net += o.Weekdays.Net
+	sm.Weekend.Count += o.Weekend.Count
+	sm.Weekend.Net += o.Weekend.Net
+
+	if sm.MonthlyTransactions == nil {
+		sm.MonthlyTransactions = make(map[string]int)
+	}
+	for m, c := range o.MonthlyTransactions {
+		sm.MonthlyTransactions[m] += c
+	}
+	if sm.DailyTransactions == nil {
+		sm.DailyTransactions = make(map[int]DayActivity)
+	}
+	for d, da := range o.DailyTransactions {
+		cur := sm.DailyTransactions[d]
+		cur.Day = d
+		cur.Count += da.Count
+		cur.Net += da.Net
+		sm.DailyTransactions[d] = cur
+	}
+	if sm.Yearly == nil {
+		sm.Yearly = make(map[int]YearTotals)
+	}
+	for y, yt := range o.Yearly {
+		cur := sm.Yearly[y]
+		cur.CreditCount += yt.CreditCount
+		cur.CreditTotal += yt.CreditTotal
+		cur.DebitCount += yt.DebitCount
+		cur.DebitTotal += yt.DebitTotal
+		sm.Yearly[y] = cur
+	}
+	if sm.Categories == nil {
+		sm.Categories = make(map[string]CategoryTotals)
+	}
+	for c, ct := range o.Categories {
+		cur := sm.Categories[c]
+		cur.Credit += ct.Credit
+		cur.Debit += ct.Debit
+		sm.Categories[c] = cur
+	}
+	if sm.DailyNet == nil {
+		sm.DailyNet = make(map[string]float64)
+	}
+	for d, n := range o.DailyNet {
+		sm.DailyNet[d] += n
+	}
+
+	if first, ok := getDate(o.FirstDate); ok {
+		if cur, ok := getDate(sm.FirstDate); !ok || first.Before(cur) {
+			sm.FirstDate = o.FirstDate
+		}
+	}
+	if last, ok := getDate(o.LastDate); ok {
+		if cur, ok := getDate(sm.LastDate); !ok || last.After(cur) {
+			sm.LastDate = o.LastDate
+		}
+	}
+	if o.LargestCredit != nil && (sm.LargestCredit == nil || o.LargestCredit.Amount > sm.LargestCredit.Amount) {
+		sm.LargestCredit = o.LargestCredit
+	}
+	if o.LargestDebit != nil && (sm.LargestDebit == nil || o.LargestDebit.Amount < sm.LargestDebit.Amount) {
+		sm.LargestDebit = o.LargestDebit
+	}
+}
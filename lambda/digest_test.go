@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRecipients is a RecipientLookup over a fixed map. Unknown accounts fail with ErrNoRecipient.
+type fakeRecipients map[string]Recipient
+
+func (r fakeRecipients) Lookup(id string) (Recipient, error) {
+	rc, ok := r[id]
+	if !ok {
+		return Recipient{}, fmt.Errorf("%w: %s", ErrNoRecipient, id)
+	}
+	return rc, nil
+}
+
+// storeWindows stores one transaction of sampleTransactions per hour from 09:00 in `store`, under
+// account `account` when it isn't empty.
+func storeWindows(t *testing.T, store *fakeS3, account string, hours int) {
+	t.Helper()
+
+	for i := 0; i < hours; i++ {
+		tr := sampleTransactions[i]
+		tr.Account = account
+		at := time.Date(2021, 8, 30, 9+i, 30, 0, 0, time.UTC)
+		obj := s3Object{Bucket: "uploads", Key: fmt.Sprintf("csv/%d.csv", i), ETag: "e"}
+		if err := storeDigest(store, obj, summaryOptions{asOf: at}, []TransactionCSV{tr}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreDigestRequiresBucket(t *testing.T) {
+	withConfig(t, nil)
+	store := &fakeS3{}
+
+	err := storeDigest(store, s3Object{Bucket: "uploads", Key: "a.csv"}, summaryOptions{}, sampleTransactions, time.Hour)
+	if err == nil || !strings.Contains(err.Error(), "DIGEST_BUCKET") {
+		t.Errorf("storeDigest error = %v, want DIGEST_BUCKET to be required", err)
+	}
+	if n := len(store.keys("uploads", "")); n != 0 {
+		t.Errorf("stored %d entries in the source bucket", n)
+	}
+}
+
+func TestStoreDigestReupload(t *testing.T) {
+	t.Setenv("DIGEST_BUCKET", "digests")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	store := &fakeS3{}
+	at := time.Date(2021, 8, 30, 9, 30, 0, 0, time.UTC)
+
+	// a retry of the first upload, then the same key uploaded again with other rows
+	for i, etag := range []string{"e1", "e1", "e2"} {
+		obj := s3Object{Bucket: "uploads", Key: "a.csv", ETag: etag}
+		ts := sampleTransactions[:1]
+		if i == 2 {
+			ts = sampleTransactions[1:2]
+		}
+		if err := storeDigest(store, obj, summaryOptions{asOf: at}, ts, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keys := store.keys("digests", ""); len(keys) != 2 {
+		t.Fatalf("entries = %v, want one per version of the object", keys)
+	}
+
+	if err := sendDigests(context.Background(), store, "digests", windowKey(at.Add(time.Hour), time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	msgs := m.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d digests, want 1", len(msgs))
+	}
+	_, body := msgs[0].Parse(t)
+	for _, want := range []string{"Total credits: $60.50", "Total debits: -$10.30"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest is missing %q from one of the uploads:\n%s", want, body)
+		}
+	}
+}
+
+func TestSendDigestsEveryPendingWindow(t *testing.T) {
+	t.Setenv("DIGEST_BUCKET", "digests")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	store := &fakeS3{}
+	storeWindows(t, store, "", 3)
+
+	// the 11:00 window is still open at 11:45, and the two before it were never sent
+	current := windowKey(time.Date(2021, 8, 30, 11, 45, 0, 0, time.UTC), time.Hour)
+	if err := sendDigests(context.Background(), store, "digests", current, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := m.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d digests, want one per complete window", len(msgs))
+	}
+	for i, want := range []string{"Total credits: $60.50", "Total debits: -$10.30"} {
+		if _, body := msgs[i].Parse(t); !strings.Contains(body, want) {
+			t.Errorf("digest %d is missing %q, want the oldest window first:\n%s", i, want, body)
+		}
+	}
+	if keys := store.keys("digests", ""); len(keys) != 1 || !strings.HasPrefix(keys[0], current) {
+		t.Errorf("entries left = %v, want only the open window's", keys)
+	}
+}
+
+func TestSendDigestsFailedAccountWaits(t *testing.T) {
+	t.Setenv("DIGEST_BUCKET", "digests")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	store := &fakeS3{}
+	storeWindows(t, store, "acct-1", 2)
+
+	current := windowKey(time.Date(2021, 8, 30, 12, 0, 0, 0, time.UTC), time.Hour)
+	err := sendDigests(context.Background(), store, "digests", current, fakeRecipients{})
+	if _, ok := err.(AccountErrors); !ok {
+		t.Fatalf("sendDigests error = %v, want AccountErrors", err)
+	}
+	if n := len(m.Messages()); n != 0 {
+		t.Errorf("sent %d digests without a recipient", n)
+	}
+	// both windows stay for the next run, which sends them in order
+	if keys := store.keys("digests", ""); len(keys) != 2 {
+		t.Errorf("entries left = %v, want both windows", keys)
+	}
+
+	lookup := fakeRecipients{"acct-1": {AccountID: "acct-1", Email: "one@example.com"}}
+	if err := sendDigests(context.Background(), store, "digests", current, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.Messages()); n != 2 {
+		t.Errorf("sent %d digests once the recipient exists, want 2", n)
+	}
+}
+
+func TestPendingWindows(t *testing.T) {
+	store := &fakeS3{}
+	for _, k := range []string{
+		"digests/20210830T100000Z/_/a.json",
+		"digests/20210830T090000Z/_/b.json",
+		"digests/20210830T110000Z/_/c.json",
+		"other/20210830T080000Z/_/d.json",
+	} {
+		store.put("digests", k, storedObject{})
+	}
+
+	got, err := pendingWindows(store, "digests", "digests/20210830T110000Z/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"digests/20210830T090000Z/", "digests/20210830T100000Z/"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("pendingWindows = %v, want %v", got, want)
+	}
+}
+
+func TestSendDigestsTransactionsAndSections(t *testing.T) {
+	t.Setenv("DIGEST_BUCKET", "digests")
+	t.Setenv("INCLUDE_TRANSACTIONS", "true")
+	t.Setenv("TRANSACTIONS_MAX_ROWS", "3")
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	store := &fakeS3{}
+
+	// two concatenated files in the same window, the first with two statements
+	a := append([]TransactionCSV{}, sampleTransactions[:2]...)
+	a[1].Section = 1
+	b := append([]TransactionCSV{}, sampleTransactions[2:]...)
+	for i, ts := range [][]TransactionCSV{a, b} {
+		obj := s3Object{Bucket: "uploads", Key: fmt.Sprintf("csv/%c.csv", 'a'+i), ETag: "e"}
+		at := time.Date(2021, 8, 30, 9, 10+30*i, 0, 0, time.UTC)
+		if err := storeDigest(store, obj, summaryOptions{asOf: at, sections: true}, ts, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current := windowKey(time.Date(2021, 8, 30, 10, 5, 0, 0, time.UTC), time.Hour)
+	if err := sendDigests(context.Background(), store, "digests", current, nil); err != nil {
+		t.Fatal(err)
+	}
+	msgs := m.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d digests, want 1", len(msgs))
+	}
+	_, body := msgs[0].Parse(t)
+	for _, want := range []string{
+		"<td>0</td><td>7/15</td><td>$60.50</td>",
+		"<td>1</td><td>7/28</td><td>-$10.30</td>",
+		"<td>2</td><td>8/2</td><td>-$20.46</td>",
+		"and 1 more...",
+		"Statement 1: 1 transactions, credits $60.50, debits $0.00",
+		"Statement 2: 1 transactions, credits $0.00, debits -$10.30",
+		"Statement 3: 2 transactions, credits $10.00, debits -$20.46",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest is missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "<td>3</td>") {
+		t.Errorf("digest lists more than TRANSACTIONS_MAX_ROWS transactions:\n%s", body)
+	}
+}
+
+func TestSummariesMergeOmitted(t *testing.T) {
+	var sm Summaries
+	sm.merge(Summaries{Transactions: []NotableTransaction{{ID: "0"}, {ID: "1"}}, TransactionsOmitted: 5}, 3)
+	sm.merge(Summaries{Transactions: []NotableTransaction{{ID: "2"}, {ID: "3"}}, TransactionsOmitted: 1}, 3)
+
+	if len(sm.Transactions) != 3 || sm.Transactions[2].ID != "2" || sm.TransactionsOmitted != 7 {
+		t.Errorf("Transactions, TransactionsOmitted = %+v, %d, want 0-2 and 7 omitted", sm.Transactions, sm.TransactionsOmitted)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+// sourceSession returns the session to read uploaded files with. When SOURCE_ROLE_ARN is set the
+// files live in another account's bucket, and are read with that role's credentials, which are
+// refreshed as they expire. Otherwise the function's own session is used.
+func sourceSession(sess *session.Session) *session.Session {
+	arn := getenv("SOURCE_ROLE_ARN")
+	if arn == "" {
+		return sess
+	}
+	return sess.Copy(&aws.Config{Credentials: stscreds.NewCredentials(sess, arn)})
+}
+
+// newDownloader returns an s3manager.Downloader for the source bucket using DOWNLOAD_PART_SIZE and
+// DOWNLOAD_CONCURRENCY, which fall back to the SDK defaults. It is a variable so the handler can read
+// files from somewhere other than S3.
+var newDownloader = func(sess *session.Session) s3manageriface.DownloaderAPI {
+	return s3manager.NewDownloader(sourceSession(sess), func(d *s3manager.Downloader) {
+		d.PartSize = int64(envInt("DOWNLOAD_PART_SIZE", int(s3manager.DefaultDownloadPartSize)))
+		d.Concurrency = envInt("DOWNLOAD_CONCURRENCY", s3manager.DefaultDownloadConcurrency)
+	})
+}
+
+// progressWriter counts the bytes written through it and logs how far along the download is at most
+// once every `every`, so a slow invocation can be told apart from a hung one. The downloader writes
+// parts from several goroutines at once.
+type progressWriter struct {
+	w     io.WriterAt
+	obj   s3Object
+	total int64
+	every time.Duration
+
+	mu      sync.Mutex
+	written int64
+	last    time.Time
+}
+
+func newProgressWriter(w io.WriterAt, obj s3Object, total int64) *progressWriter {
+	return &progressWriter{
+		w:     w,
+		obj:   obj,
+		total: total,
+		every: envDuration("DOWNLOAD_PROGRESS_INTERVAL", 10*time.Second),
+		last:  time.Now(),
+	}
+}
+
+func (pw *progressWriter) WriteAt(p []byte, off int64) (int, error) {
+	n, err := pw.w.WriteAt(p, off)
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.written += int64(n)
+	if time.Since(pw.last) >= pw.every {
+		pw.last = time.Now()
+		f := pw.obj.fields()
+		f["downloaded"] = pw.written
+		f["total"] = pw.total
+		if pw.total > 0 {
+			f["percent"] = float64(pw.written) / float64(pw.total) * 100
+		}
+		logJSON("info", "download progress", f)
+	}
+
+	return n, err
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"mime"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+type EmailAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+}
+
+type EmailSummary struct {
+	Greeting string
+	SignOff  string
+	// Extra are custom fields for templates, read as `{{ index .Extra "accountNumber" }}`. They come
+	// from EMAIL_EXTRA with the recipient's own Extra on top. Missing keys render empty.
+	Extra map[string]string
+	// CustomerName is the recipient's name from the lookup, or `Customer` when it isn't known.
+	CustomerName string
+	// Base has the totals converted to FX_BASE_CURRENCY, nil unless it is set.
+	Base *BaseTotals
+	// Period is the range of dates covered, e.g. `6/1/2024 – 6/30/2024`, or a single date when there is
+	// only one. It is empty without any dated transactions.
+	Period string
+	// StatementDate is when the statement was produced, written out for the recipient's language, e.g.
+	// `July 1, 2024`.
+	StatementDate string
+	// NetChange is credits plus debits for the period, which is not the account balance.
+	NetChange      float64
+	NetChangeLabel string
+	CreditTotal    float64
+	DebitTotal     float64
+	// MonthlyTransactions is the monthly breakdown in period order, oldest first.
+	MonthlyTransactions []MonthCount
+	CreditAverage       float64
+	DebitAverage        float64
+	MonthlyAverage      float64
+	// NetPercentOfCredits is rounded to one decimal, and nil when there were no credits.
+	NetPercentOfCredits *float64
+	Sections            []SectionTotals
+	LargestCredit       *NotableTransaction
+	LargestDebit        *NotableTransaction
+	// Transactions is the table of transactions, empty unless INCLUDE_TRANSACTIONS is set, and
+	// MoreTransactions how many didn't fit in it.
+	Transactions     []NotableTransaction
+	MoreTransactions int
+	// StatementURL links to the web version of the statement, empty unless INCLUDE_STATEMENT_LINK is on,
+	// and StatementQR is set when a QR code of it is inlined as `cid:statement-qr`.
+	StatementURL string
+	StatementQR  bool
+	// TransactionsAttached is set when the table pushed the body over MAX_BODY_BYTES and was sent as an
+	// attached CSV instead, leaving Transactions empty. The attachment has the same rows the table
+	// would have, so MoreTransactions still counts the ones left out of both.
+	TransactionsAttached bool
+	// Recurring lists likely subscriptions, most frequent first.
+	Recurring []RecurringCharge
+	// LargeTransactions is empty unless LARGE_TXN_THRESHOLD is set and something went over it.
+	LargeTransactions []NotableTransaction
+	// Refunds is how many credits refunded an earlier debit, and RefundTotal their sum.
+	Refunds     int
+	RefundTotal float64
+	// Transfers is how many credits looked like transfers in, and TransferTotal their sum. IncomeTotal is
+	// the rest of the credits, and only worth showing when there were transfers.
+	Transfers     int
+	TransferTotal float64
+	IncomeTotal   float64
+	// Weekdays and Weekend are both zero when no transaction had a full date.
+	Weekdays WeekActivity
+	Weekend  WeekActivity
+	// Business is only set for business accounts, and Recurring only for personal ones.
+	Business *BusinessSummary
+	// LargestSwing is only set when INCLUDE_VELOCITY is enabled.
+	LargestSwing *DaySwing
+	// DayOfMonth is only set when INCLUDE_DAY_OF_MONTH is enabled, ordered by day.
+	DayOfMonth []DayActivity
+	// Categories is only set when CATEGORY_TOTALS is enabled, ordered by name with Uncategorized last.
+	Categories []CategoryRow
+	// YearlyAverages is only set when PER_YEAR_AVERAGES is enabled, ordered by year.
+	YearlyAverages []YearAverage
+}
+
+// sentEmail records what sendEmail delivered, for anything that needs to audit it afterwards.
+type sentEmail struct {
+	// Account is empty for single account files.
+	Account string
+	To      string
+	Body    string
+	SentAt  time.Time
+	// MessageID is the Message-ID header we set, and ProviderID what the mail server calls the
+	// message, e.g. the SES message id. ProviderID is empty for MAIL_SINK=file.
+	MessageID  string
+	ProviderID string
+}
+
+// emailSender sends summaries through a single Mailer with the credentials in EMAIL_SECRET, so a file
+// with many accounts costs one secret lookup and one SMTP connection.
+type emailSender struct {
+	ea     EmailAuth
+	mailer Mailer
+	// run is the invocation's tally from the context the sender was made with, nil outside HandleRequest.
+	run *runSummary
+	// fx is the FX_BASE_CURRENCY conversion, fetched once for every email the sender renders.
+	fx *fxRate
+}
+
+// defaultPreviewAddress stands in for the EMAIL_SECRET account in dry runs when PREVIEW_ADDRESS isn't set.
+const defaultPreviewAddress = "preview@example.com"
+
+// newEmailSender fetches the email credentials and the FX rate and prepares the Mailer. Close it when
+// done. Emails that only go to a file don't need credentials, so dry runs and MAIL_SINK=file never
+// touch Secrets Manager and send from PREVIEW_ADDRESS instead.
+func newEmailSender(ctx context.Context) (*emailSender, error) {
+	// one rate for the whole invocation, so every account in a file is converted alike
+	fx, err := getFXRate()
+	if err != nil {
+		return nil, err
+	}
+
+	if fileSink() {
+		ea := EmailAuth{Username: envDefault("PREVIEW_ADDRESS", defaultPreviewAddress)}
+		logJSON("info", "writing emails to file, skipping email secret", map[string]interface{}{"from": ea.Username})
+		return &emailSender{ea: ea, mailer: newMailer(ctx, ea), run: runSummaryFrom(ctx), fx: fx}, nil
+	}
+
+	ea, err := loadEmailAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	return &emailSender{ea: ea, mailer: newMailer(ctx, ea), run: runSummaryFrom(ctx), fx: fx}, nil
+}
+
+// loadEmailAuth fetches the credentials in EMAIL_SECRET with a session of its own. It is a variable so
+// the handler can run against an in-memory Mailer without Secrets Manager.
+var loadEmailAuth = func() (EmailAuth, error) {
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return EmailAuth{}, err
+	}
+	return emailAuth(secretsmanager.New(sess))
+}
+
+// emailAuth fetches and checks the credentials in EMAIL_SECRET.
+func emailAuth(svc secretsmanageriface.SecretsManagerAPI) (EmailAuth, error) {
+	input := secretsmanager.GetSecretValueInput{
+		SecretId: aws.String("EMAIL_SECRET"),
+	}
+	sv, err := svc.GetSecretValue(&input)
+	if err != nil {
+		return EmailAuth{}, fmt.Errorf("%w: %v", ErrSecretUnavailable, err)
+	}
+
+	raw, err := secretBytes(sv)
+	if err != nil {
+		return EmailAuth{}, err
+	}
+
+	var ea EmailAuth
+	if err = json.Unmarshal(raw, &ea); err != nil {
+		return EmailAuth{}, err
+	}
+	if err := checkSMTPHost(ea.Host); err != nil {
+		return EmailAuth{}, err
+	}
+
+	return ea, nil
+}
+
+// fork returns a sender with the same credentials and a Mailer of its own, for sending from another
+// goroutine. The file sink has no connection to hold and numbers its files, so it is shared instead.
+func (es *emailSender) fork(ctx context.Context) *emailSender {
+	if _, ok := es.mailer.(*fileMailer); ok {
+		return es
+	}
+	return &emailSender{ea: es.ea, mailer: newMailer(ctx, es.ea), run: es.run, fx: es.fx}
+}
+
+// Close releases the Mailer's connection, if it holds one.
+func (es *emailSender) Close() error {
+	if c, ok := es.mailer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// sendEmail uses `s` to send a formatted email from a template to the address in EMAIL_SECRET.
+func sendEmail(ctx context.Context, s Summaries) (sentEmail, error) {
+	es, err := newEmailSender(ctx)
+	if err != nil {
+		return sentEmail{}, err
+	}
+	defer es.Close()
+
+	return es.send(s, Recipient{Email: es.ea.Username})
+}
+
+// RenderedEmail is a summary email ready to go out.
+type RenderedEmail struct {
+	// Body is the rendered HTML, without headers or attachments.
+	Body      string
+	MessageID string
+	Date      time.Time
+	// Message is the full MIME message, headers and all, exactly as it is handed to the mail server.
+	Message []byte
+}
+
+// RenderEmail renders `s` for `rc` into the message sent from `from`, without touching the network, so
+// the exact bytes can be checked or previewed without a mail server. Totals are also shown converted
+// with `fx` when it isn't nil.
+func RenderEmail(s Summaries, rc Recipient, from string, fx *fxRate) (RenderedEmail, error) {
+	ft := features()
+	rm, err := getRoundingMode()
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+
+	// round the values out to hundreths
+	to := rm.cents(s.CreditTotal + s.DebitTotal)
+	ct := rm.cents(s.CreditTotal)
+	dt := rm.cents(s.DebitTotal)
+	// a side without transactions averages 0, like yearlyAverages, rather than NaN
+	var ca, da float64
+	if s.CreditCount > 0 {
+		ca = rm.cents(s.CreditTotal / float64(s.CreditCount))
+	}
+	if s.DebitCount > 0 {
+		da = rm.cents(s.DebitTotal / float64(s.DebitCount))
+	}
+
+	lang, err := getLanguage(rc.Locale)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	name := rc.Name
+	if name == "" {
+		name = lang.T("Customer")
+	}
+	names, err := monthNames()
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	months := s.MonthlyTransactions
+	if ft.IncludeEmptyMonths {
+		months = fillMonths(months, names)
+	}
+	monthly := monthlyBreakdown(months, names, lang)
+
+	extra, err := extraFields(rc)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+
+	data := EmailSummary{
+		Extra:               extra,
+		CustomerName:        name,
+		Greeting:            envDefault("GREETING", lang.T("Hello")),
+		SignOff:             envDefault("SIGNOFF", lang.T("Thank you!")),
+		NetChange:           to,
+		NetChangeLabel:      netChangeLabel(lang),
+		CreditTotal:         ct,
+		DebitTotal:          dt,
+		MonthlyTransactions: monthly,
+		Period:              period(s.FirstDate, s.LastDate),
+		CreditAverage:       ca,
+		DebitAverage:        da,
+		MonthlyAverage:      math.Round(s.MonthlyAverage*100) / 100,
+		LargestCredit:       roundNotable(s.LargestCredit, rm),
+		LargestDebit:        roundNotable(s.LargestDebit, rm),
+		Weekdays:            WeekActivity{Count: s.Weekdays.Count, Net: rm.cents(s.Weekdays.Net)},
+		Weekend:             WeekActivity{Count: s.Weekend.Count, Net: rm.cents(s.Weekend.Net)},
+		Refunds:             s.Refunds,
+		RefundTotal:         rm.cents(s.RefundTotal),
+		Transfers:           s.Transfers,
+		TransferTotal:       rm.cents(s.TransferTotal),
+		IncomeTotal:         rm.cents(s.CreditTotal - s.TransferTotal),
+	}
+	if s.NetPercentOfCredits != nil {
+		p := math.Round(*s.NetPercentOfCredits*10) / 10
+		data.NetPercentOfCredits = &p
+	}
+	for _, tx := range s.Transactions {
+		tx.Amount = rm.cents(tx.Amount)
+		data.Transactions = append(data.Transactions, tx)
+	}
+	data.MoreTransactions = s.TransactionsOmitted
+	sd, err := statementDate(time.Now().UTC())
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	data.StatementDate = lang.longDate(sd)
+	at, err := getAccountType(rc)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	if at == accountBusiness {
+		data.Business = &BusinessSummary{ReceiptCount: s.CreditCount, PaymentCount: s.DebitCount}
+	} else {
+		// subscriptions are a personal finance concern, a business tracks its suppliers elsewhere
+		for _, rc := range s.Recurring {
+			rc.Amount = rm.cents(rc.Amount)
+			data.Recurring = append(data.Recurring, rc)
+		}
+	}
+	for _, lt := range s.LargeTransactions {
+		lt.Amount = rm.cents(lt.Amount)
+		data.LargeTransactions = append(data.LargeTransactions, lt)
+	}
+	for _, st := range s.Sections {
+		st.CreditTotal, st.DebitTotal = rm.cents(st.CreditTotal), rm.cents(st.DebitTotal)
+		data.Sections = append(data.Sections, st)
+	}
+	if ft.IncludeDayOfMonth {
+		data.DayOfMonth = dayOfMonth(s.DailyTransactions, rm)
+	}
+	if ft.IncludeVelocity && s.LargestSwing != nil {
+		ls := *s.LargestSwing
+		ls.Change = rm.cents(ls.Change)
+		data.LargestSwing = &ls
+	}
+	if ft.PerYearAverages {
+		data.YearlyAverages = yearlyAverages(s.Yearly, rm)
+	}
+	if ft.CategoryTotals && len(s.Categories) > 0 {
+		data.Categories = categoryRows(s.Categories, rm, lang.T(defaultCategory))
+	}
+
+	cf, err := getCurrencyFormat(rc.Locale)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	if fx != nil {
+		data.Base = fx.convert(s.CreditTotal+s.DebitTotal, s.CreditTotal, s.DebitTotal, rm)
+	}
+
+	data.StatementURL, err = statementURL(rc.AccountID, s, sd)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	var qr []byte
+	if data.StatementURL != "" && ft.StatementQR {
+		if qr, err = statementQR(data.StatementURL); err != nil {
+			return RenderedEmail{}, err
+		}
+		data.StatementQR = true
+	}
+
+	tier := templateTier()
+	if at == accountBusiness {
+		tier = businessTemplate
+	}
+	// a summary of nothing but zeros reads like an error, so say so plainly instead
+	if ft.NoActivityEmail && s.CreditCount+s.DebitCount == 0 {
+		tier = noActivityTemplate
+	}
+
+	t, err := getTemplate(tier, template.FuncMap{
+		"money":    cf.Format,
+		"base":     cf.FormatCode,
+		"t":        lang.T,
+		"truncate": truncator(envInt("DESCRIPTION_MAX_LENGTH", defaultDescriptionLength)),
+	})
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return RenderedEmail{}, err
+	}
+	body := buf.String()
+
+	// relays reject oversized messages outright, so a long table goes out as a file instead
+	var att []byte
+	if max := envInt("MAX_BODY_BYTES", 0); max > 0 && len(body) > max && len(data.Transactions) > 0 {
+		if att, err = transactionsCSV(data.Transactions); err != nil {
+			return RenderedEmail{}, err
+		}
+		logJSON("info", "email body over MAX_BODY_BYTES, attaching transactions", map[string]interface{}{
+			"account":      rc.AccountID,
+			"body_bytes":   len(body),
+			"max":          max,
+			"transactions": len(data.Transactions),
+		})
+		data.Transactions, data.TransactionsAttached = nil, true
+		buf.Reset()
+		if err := t.Execute(buf, data); err != nil {
+			return RenderedEmail{}, err
+		}
+		body = buf.String()
+	}
+
+	now := time.Now().UTC()
+	msgID, err := messageID(from)
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+
+	// spam filters penalize messages missing any of From, To, Date or Message-ID
+	var hdr strings.Builder
+	hdr.WriteString("From: " + (&mail.Address{Name: getenv("FROM_NAME"), Address: from}).String() + "\n")
+	hdr.WriteString("To: " + (&mail.Address{Name: rc.Name, Address: rc.Email}).String() + "\n")
+	hdr.WriteString("Date: " + now.Format(time.RFC1123Z) + "\n")
+	hdr.WriteString("Message-ID: " + msgID + "\n")
+	hdr.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", getenv("SUBJECT_PREFIX")+lang.T("Transaction Summary")) + "\n")
+	// replies should reach a monitored mailbox rather than the sending account
+	replyTo, err := envAddress("REPLY_TO")
+	if err != nil {
+		return RenderedEmail{}, err
+	}
+	if replyTo != "" {
+		hdr.WriteString("Reply-To: " + replyTo + "\n")
+	}
+	hdr.WriteString("MIME-Version: 1.0\n")
+	contentType, mb := `text/html; charset="UTF-8"`, []byte(body)
+	if qr != nil {
+		if contentType, mb, err = relatedBody(body, statementQRID, qr); err != nil {
+			return RenderedEmail{}, err
+		}
+	}
+	if att != nil {
+		if contentType, mb, err = multipartBody(contentType, mb, transactionsAttachment, att); err != nil {
+			return RenderedEmail{}, err
+		}
+	}
+	hdr.WriteString("Content-Type: " + contentType + "\n")
+	msg := append([]byte(hdr.String()+"\n"), mb...)
+
+	return RenderedEmail{Body: body, MessageID: msgID, Date: now, Message: msg}, nil
+}
+
+// send renders `s` and delivers it to `rc`.
+func (es *emailSender) send(s Summaries, rc Recipient) (sentEmail, error) {
+	r, err := RenderEmail(s, rc, es.ea.Username, es.fx)
+	if err != nil {
+		return sentEmail{}, err
+	}
+
+	// the BCC only goes in the envelope, so recipients never see the archive address
+	rcpts := []string{rc.Email}
+	bcc, err := envAddress("BCC_ADDRESS")
+	if err != nil {
+		return sentEmail{}, err
+	}
+	if bcc != "" {
+		rcpts = append(rcpts, bcc)
+	}
+
+	reply, err := es.mailer.Send(rcpts, r.Message)
+	if err != nil {
+		return sentEmail{}, err
+	}
+
+	sent := sentEmail{Account: rc.AccountID, To: rc.Email, Body: r.Body, SentAt: r.Date, MessageID: r.MessageID, ProviderID: providerMessageID(reply)}
+	logJSON("info", "email sent", map[string]interface{}{
+		"account":     sent.Account,
+		"to":          sent.To,
+		"message_id":  sent.MessageID,
+		"provider_id": sent.ProviderID,
+		"smtp_reply":  reply,
+	})
+	es.run.record(s, sent)
+	return sent, nil
+}
+
+// messageID generates an RFC 5322 Message-ID on the sender's domain, like `<1634200000.1a2b...@example.com>`.
+func messageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndexByte(from, '@'); i >= 0 && i < len(from)-1 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), hex.EncodeToString(b), domain), nil
+}
+
+// extraFields merges EMAIL_EXTRA, a JSON object of strings, with the recipient's Extra, which wins.
+func extraFields(rc Recipient) (map[string]string, error) {
+	extra := make(map[string]string)
+	if v := getenv("EMAIL_EXTRA"); v != "" {
+		if err := json.Unmarshal([]byte(v), &extra); err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_EXTRA: %w", err)
+		}
+	}
+	for k, v := range rc.Extra {
+		extra[k] = v
+	}
+
+	return extra, nil
+}
+
+// netChangeLabel is the label shown next to the net change line, overridable with TOTAL_LABEL.
+func netChangeLabel(lang language) string {
+	if l := getenv("TOTAL_LABEL"); l != "" {
+		return l
+	}
+	return lang.T("Net Change")
+}
+
+// envAddress reads the email address in the environment variable `name`, returning an empty string
+// when it isn't set and an error when it isn't a valid address.
+func envAddress(name string) (string, error) {
+	v := strings.TrimSpace(getenv(name))
+	if v == "" {
+		return "", nil
+	}
+
+	a, err := mail.ParseAddress(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	return a.Address, nil
+}
+
+// secretBytes returns the payload of `sv`, which Secrets Manager stores in either SecretString or
+// SecretBinary depending on how the secret was created. The SDK has already base64 decoded SecretBinary.
+func secretBytes(sv *secretsmanager.GetSecretValueOutput) ([]byte, error) {
+	if sv.SecretString != nil && *sv.SecretString != "" {
+		return []byte(*sv.SecretString), nil
+	}
+	if len(sv.SecretBinary) > 0 {
+		return sv.SecretBinary, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrEmptySecret, aws.StringValue(sv.Name))
+}
+
+// period formats the dates covered by a summary.
+func period(first, last string) string {
+	if first == last {
+		return first
+	}
+	return first + " – " + last
+}
+
+// statementDate is STATEMENT_DATE, a `YYYY-MM-DD` date for statements that are reissued or dated
+// ahead, or `now` when it isn't set.
+func statementDate(now time.Time) (time.Time, error) {
+	v := getenv("STATEMENT_DATE")
+	if v == "" {
+		return now, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid STATEMENT_DATE %q: %w", v, err)
+	}
+	return t, nil
+}
+
+// roundNotable returns a copy of `nt` with its amount rounded for display, or nil when there is none.
+func roundNotable(nt *NotableTransaction, rm roundingMode) *NotableTransaction {
+	if nt == nil {
+		return nil
+	}
+	c := *nt
+	c.Amount = rm.cents(c.Amount)
+	return &c
+}
+
+// dayOfMonth orders the daily buckets by day, rounding each net amount for display.
+func dayOfMonth(m map[int]DayActivity, rm roundingMode) []DayActivity {
+	days := make([]DayActivity, 0, len(m))
+	for _, d := range m {
+		d.Net = rm.cents(d.Net)
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+
+	return days
+}
+
+// MonthCount is one line of the monthly breakdown in the email.
+type MonthCount struct {
+	Month string
+	Count int
+}
+
+// monthlyBreakdown orders the monthly breakdown `m` by period, oldest first, with each label in
+// `lang`. Months without a year come before those with one, and keys that aren't recognizable month
+// labels go last, by name. Templates range over the result, since a map would come out alphabetical.
+func monthlyBreakdown(m map[string]int, names [12]string, lang language) []MonthCount {
+	index := monthIndex(names)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		yi, mi, oki := parseMonthKey(keys[i], index)
+		yj, mj, okj := parseMonthKey(keys[j], index)
+		switch {
+		case oki != okj:
+			return oki
+		case !oki:
+			return keys[i] < keys[j]
+		case yi != yj:
+			return yi < yj
+		}
+		return mi < mj
+	})
+
+	out := make([]MonthCount, 0, len(keys))
+	seen := make(map[string]int, len(keys))
+	for _, k := range keys {
+		label := lang.month(k)
+		// keys in different forms can translate to the same label
+		if i, ok := seen[label]; ok {
+			out[i].Count += m[k]
+			continue
+		}
+		seen[label] = len(out)
+		out = append(out, MonthCount{Month: label, Count: m[k]})
+	}
+
+	return out
+}
+
+// monthIndex maps each of `names` to its position, January first.
+func monthIndex(names [12]string) map[string]int {
+	index := make(map[string]int, len(names))
+	for i, n := range names {
+		index[n] = i
+	}
+	return index
+}
+
+// parseMonthKey reads a monthly breakdown key like `July` or `July 2021` into its year, 0 when it has
+// none, and its month's position in `index`. It reports false for keys that aren't month labels.
+func parseMonthKey(k string, index map[string]int) (int, int, bool) {
+	if mi, ok := index[k]; ok {
+		return 0, mi, true
+	}
+	i := strings.LastIndexByte(k, ' ')
+	if i < 0 {
+		return 0, 0, false
+	}
+	mi, ok := index[k[:i]]
+	y, err := strconv.Atoi(k[i+1:])
+	if !ok || err != nil {
+		return 0, 0, false
+	}
+	return y, mi, true
+}
+
+// fillMonths returns the monthly breakdown `m` with a zero entry for every month without activity.
+// Breakdowns without years get all twelve months of `names`; ones with years get every month from the
+// earliest to the latest. Keys that aren't recognizable month labels are kept as they are.
+func fillMonths(m map[string]int, names [12]string) map[string]int {
+	index := monthIndex(names)
+
+	out := make(map[string]int, len(m))
+	yearless := false
+	first, last := -1, -1
+	for k, c := range m {
+		out[k] = c
+		y, mi, ok := parseMonthKey(k, index)
+		if !ok {
+			continue
+		}
+		if y == 0 {
+			yearless = true
+			continue
+		}
+		// months since year 0, so the range is easy to walk
+		n := y*12 + mi
+		if first < 0 || n < first {
+			first = n
+		}
+		if n > last {
+			last = n
+		}
+	}
+
+	if yearless {
+		for _, n := range names {
+			setIfMissing(out, n)
+		}
+	}
+	for n := first; first >= 0 && n <= last; n++ {
+		setIfMissing(out, fmt.Sprintf("%s %d", names[n%12], n/12))
+	}
+
+	return out
+}
+
+func setIfMissing(m map[string]int, k string) {
+	if _, ok := m[k]; !ok {
+		m[k] = 0
+	}
+}
+
+// yearlyAverages computes the rounded credit and debit averages for each year, ordered by year.
+// Years without any credits or debits report an average of 0 for that side.
+func yearlyAverages(m map[int]YearTotals, rm roundingMode) []YearAverage {
+	avgs := make([]YearAverage, 0, len(m))
+	for y, yt := range m {
+		ya := YearAverage{Year: y}
+		if yt.CreditCount > 0 {
+			ya.CreditAverage = rm.cents(yt.CreditTotal / float64(yt.CreditCount))
+		}
+		if yt.DebitCount > 0 {
+			ya.DebitAverage = rm.cents(yt.DebitTotal / float64(yt.DebitCount))
+		}
+		avgs = append(avgs, ya)
+	}
+	sort.Slice(avgs, func(i, j int) bool { return avgs[i].Year < avgs[j].Year })
+
+	return avgs
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// renderSample renders sampleTransactions for `rc` with the current settings.
+func renderSample(t *testing.T, rc Recipient) RenderedEmail {
+	t.Helper()
+
+	sm, err := getSummaries(sampleTransactions, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, rc, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// mimeParts splits a multipart message into its decoded parts, keyed by Content-Type without parameters.
+func mimeParts(t *testing.T, msg []byte) map[string]string {
+	t.Helper()
+
+	m, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]string{}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			r = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(b)
+	}
+}
+
+func TestRenderEmailAttachmentOverflow(t *testing.T) {
+	t.Setenv("INCLUDE_TRANSACTIONS", "true")
+	t.Setenv("TRANSACTIONS_MAX_ROWS", "2")
+	t.Setenv("MAX_BODY_BYTES", "1")
+	withConfig(t, nil)
+
+	r := renderSample(t, Recipient{Email: "a@example.com"})
+	for _, want := range []string{"The transactions table is attached.", "and 2 more..."} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+	if strings.Contains(r.Body, "<th>ID</th>") {
+		t.Errorf("body still has the transactions table:\n%s", r.Body)
+	}
+
+	att := mimeParts(t, r.Message)["text/csv"]
+	want := "Id,Date,Amount,Description\n0,7/15,60.50,\n1,7/28,-10.30,\n"
+	if att != want {
+		t.Errorf("attachment = %q, want the %q rows the table would have had", att, want)
+	}
+}
+
+func TestRenderEmailOneSidedAverages(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(sampleTransactions[:1], summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(r.Body, "NaN") {
+		t.Errorf("body has a NaN average:\n%s", r.Body)
+	}
+	for _, want := range []string{"Average debit amount: $0.00", "Average credit amount: $60.50"} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+}
+
+func TestRenderEmailTwoLocales(t *testing.T) {
+	t.Setenv("LARGE_TXN_THRESHOLD", "50")
+	withConfig(t, nil)
+
+	for _, tc := range []struct {
+		locale string
+		want   []string
+	}{
+		{"en-US", []string{
+			"<strong>$60.50</strong> on 7/15 (transaction 0)",
+			"Largest debit: -$20.46 on 8/2",
+			"Largest credit: $60.50 on 7/15",
+		}},
+		{"es-MX", []string{
+			"<strong>$60.50</strong> el 7/15 (movimiento 0)",
+			"Cargo más grande: -$20.46 el 8/2",
+			"Abono más grande: $60.50 el 7/15",
+		}},
+	} {
+		body := renderSample(t, Recipient{Locale: tc.locale}).Body
+		for _, want := range tc.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s body is missing %q:\n%s", tc.locale, want, body)
+			}
+		}
+		if tc.locale != "en-US" && strings.Contains(body, " on ") {
+			t.Errorf("%s body has an untranslated word:\n%s", tc.locale, body)
+		}
+	}
+}
+
+func TestLanguageSentence(t *testing.T) {
+	es := languages["es"]
+
+	if got := es.T("Largest day over day change: %[1]s from %[2]s to %[3]s", "$5.00", "7/1", "7/2"); got != "Mayor cambio de un día a otro: $5.00 del 7/1 al 7/2" {
+		t.Errorf("T = %q", got)
+	}
+	if got := es.T("and %[1]d more...", 3); got != "y 3 más..." {
+		t.Errorf("T = %q", got)
+	}
+	// without arguments a % in the text is left alone
+	if got := languages["en"].T("100% of credits"); got != "100% of credits" {
+		t.Errorf("T = %q", got)
+	}
+	for msg := range es.Messages {
+		if strings.Contains(msg, "%") && strings.Count(msg, "%[") != strings.Count(es.Messages[msg], "%[") {
+			t.Errorf("translation of %q has different placeholders: %q", msg, es.Messages[msg])
+		}
+	}
+}
+
+// fakeSecrets is a Secrets Manager holding `values` by secret id, counting its calls.
+type fakeSecrets struct {
+	secretsmanageriface.SecretsManagerAPI
+	values map[string]*secretsmanager.GetSecretValueOutput
+	calls  int
+}
+
+func (f *fakeSecrets) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls++
+	sv, ok := f.values[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException: secret not found")
+	}
+	return sv, nil
+}
+
+const testEmailSecret = `{"username":"statements@example.com","password":"hunter2","host":"smtp.example.com"}`
+
+func TestEmailAuthStringOrBinary(t *testing.T) {
+	withConfig(t, nil)
+
+	for name, sv := range map[string]*secretsmanager.GetSecretValueOutput{
+		"string": {SecretString: aws.String(testEmailSecret)},
+		"binary": {SecretBinary: []byte(testEmailSecret)},
+		// a secret created as binary comes back with an empty string alongside
+		"binary with empty string": {SecretString: aws.String(""), SecretBinary: []byte(testEmailSecret)},
+	} {
+		ea, err := emailAuth(&fakeSecrets{values: map[string]*secretsmanager.GetSecretValueOutput{"EMAIL_SECRET": sv}})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if ea != (EmailAuth{Username: "statements@example.com", Password: "hunter2", Host: "smtp.example.com"}) {
+			t.Errorf("%s: EmailAuth = %+v", name, ea)
+		}
+	}
+}
+
+func TestEmailAuthErrors(t *testing.T) {
+	withConfig(t, nil)
+
+	empty := &fakeSecrets{values: map[string]*secretsmanager.GetSecretValueOutput{"EMAIL_SECRET": {Name: aws.String("EMAIL_SECRET")}}}
+	if _, err := emailAuth(empty); !errors.Is(err, ErrEmptySecret) || !strings.Contains(err.Error(), "EMAIL_SECRET") {
+		t.Errorf("emailAuth error = %v, want ErrEmptySecret naming the secret", err)
+	}
+	if _, err := emailAuth(&fakeSecrets{}); !errors.Is(err, ErrSecretUnavailable) {
+		t.Errorf("emailAuth error = %v, want ErrSecretUnavailable", err)
+	}
+}
+
+func TestRenderEmailNetPercentOfCredits(t *testing.T) {
+	withConfig(t, nil)
+
+	if body := renderSample(t, Recipient{}).Body; !strings.Contains(body, "Net change as a share of credits: 56.4%") {
+		t.Errorf("body is missing the net share of credits:\n%s", body)
+	}
+
+	sm, err := getSummaries(sampleTransactions[1:3], summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(r.Body, "share of credits") {
+		t.Errorf("body shows a share of credits without any credits:\n%s", r.Body)
+	}
+}
+
+func TestRenderEmailHeaders(t *testing.T) {
+	t.Setenv("FROM_NAME", "Stori Statements")
+	withConfig(t, nil)
+
+	before := time.Now().Truncate(time.Second)
+	r := renderSample(t, Recipient{Email: "ana@example.com", Name: "Ana Pérez"})
+	msg, err := mail.ReadMessage(strings.NewReader(string(r.Message)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if from, err := msg.Header.AddressList("From"); err != nil || len(from) != 1 || from[0].Name != "Stori Statements" || from[0].Address != "statements@example.com" {
+		t.Errorf("From = %v, %v, want Stori Statements <statements@example.com>", from, err)
+	}
+	if to, err := msg.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Name != "Ana Pérez" || to[0].Address != "ana@example.com" {
+		t.Errorf("To = %v, %v, want Ana Pérez <ana@example.com>", to, err)
+	}
+	if date, err := msg.Header.Date(); err != nil || date.Before(before) || date.After(time.Now()) {
+		t.Errorf("Date = %v, %v, want the time of rendering", date, err)
+	}
+	if got := msg.Header.Get("Message-Id"); got != r.MessageID {
+		t.Errorf("Message-ID = %q, want the rendered %q", got, r.MessageID)
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	format := regexp.MustCompile(`^<\d+\.[0-9a-f]{32}@([^>]+)>$`)
+	for from, domain := range map[string]string{
+		"statements@example.com": "example.com",
+		"statements":             "localhost",
+		"statements@":            "localhost",
+	} {
+		id, err := messageID(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := format.FindStringSubmatch(id); m == nil || m[1] != domain {
+			t.Errorf("messageID(%q) = %q, want <unix.hex@%s>", from, id, domain)
+		}
+	}
+
+	a, _ := messageID("statements@example.com")
+	b, _ := messageID("statements@example.com")
+	if a == b {
+		t.Errorf("messageID repeated %q", a)
+	}
+}
+
+func TestFillMonths(t *testing.T) {
+	names := languages[defaultLanguage].Months
+
+	got := fillMonths(map[string]int{"July": 2, "August": 2}, names)
+	if len(got) != 12 || got["July"] != 2 || got["August"] != 2 || got["January"] != 0 {
+		t.Errorf("fillMonths = %v, want all twelve months with July and August kept", got)
+	}
+
+	got = fillMonths(map[string]int{"December 2020": 1, "January 2021": 2, "December 2021": 1, "Other": 3}, names)
+	if len(got) != 14 || got["December 2020"] != 1 || got["June 2021"] != 0 || got["December 2021"] != 1 || got["Other"] != 3 {
+		t.Errorf("fillMonths = %v, want December 2020 to December 2021 and the unknown key kept", got)
+	}
+	if _, ok := got["November 2020"]; ok {
+		t.Errorf("fillMonths = %v, want nothing before the first month", got)
+	}
+}
+
+func TestRenderEmailIncludeEmptyMonths(t *testing.T) {
+	withConfig(t, nil)
+	if body := renderSample(t, Recipient{}).Body; strings.Contains(body, "March: 0") {
+		t.Errorf("body lists empty months by default:\n%s", body)
+	}
+
+	t.Setenv("INCLUDE_EMPTY_MONTHS", "true")
+	withConfig(t, nil)
+	body := renderSample(t, Recipient{}).Body
+	for _, want := range []string{"January: 0", "July: 2", "August: 2", "December: 0"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestRenderEmailRoundsNotableTransactions(t *testing.T) {
+	t.Setenv("ROUNDING_MODE", "down")
+	withConfig(t, nil)
+
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15", Transaction: "+60.509"},
+		{ID: "1", Date: "7/28", Transaction: "-20.468"},
+	}
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// half up would show $60.51 and -$20.47
+	for _, want := range []string{"Largest credit: $60.50 on 7/15", "Largest debit: -$20.46 on 7/28"} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+	if sm.LargestDebit.Amount != -20.468 {
+		t.Errorf("LargestDebit = %v, want the summary left unrounded", sm.LargestDebit.Amount)
+	}
+}
+
+// monthLines returns the `<p>Month: count</p>` lines of the monthly breakdown in `body`, in order.
+func monthLines(body string, names [12]string) []string {
+	var lines []string
+	for _, line := range strings.Split(body, "</p>") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "<p>"))
+		for _, n := range names {
+			if strings.HasPrefix(line, n+":") || strings.HasPrefix(line, n+" ") {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+	return lines
+}
+
+func TestRenderEmailMonthOrder(t *testing.T) {
+	t.Setenv("INCLUDE_EMPTY_MONTHS", "true")
+	withConfig(t, nil)
+	names := languages[defaultLanguage].Months
+
+	got := monthLines(renderSample(t, Recipient{}).Body, names)
+	want := []string{"January: 0", "February: 0", "March: 0", "April: 0", "May: 0", "June: 0", "July: 2", "August: 2", "September: 0", "October: 0", "November: 0", "December: 0"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("months = %q, want them in calendar order %q", got, want)
+	}
+
+	sm, err := getSummaries(multiYear, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = monthLines(r.Body, names)
+	if len(got) != 13 || got[0] != "December 2020: 1" || got[1] != "January 2021: 2" || got[2] != "February 2021: 0" || got[12] != "December 2021: 1" {
+		t.Errorf("months = %q, want December 2020 to December 2021 in order", got)
+	}
+}
+
+func TestMonthlyBreakdown(t *testing.T) {
+	names := languages[defaultLanguage].Months
+	es, err := getLanguage("es")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := monthlyBreakdown(map[string]int{"August 2021": 1, "Other": 4, "April 2021": 2, "December 2020": 3, "May": 5}, names, es)
+	want := []MonthCount{{"mayo", 5}, {"diciembre 2020", 3}, {"abril 2021", 2}, {"agosto 2021", 1}, {"Other", 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("monthlyBreakdown = %v, want %v", got, want)
+	}
+}
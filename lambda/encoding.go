@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// csvEncoding reads the character encoding of incoming files, e.g. `latin1` or `iso-8859-1`.
+// An empty value means the file is already UTF-8.
+func csvEncoding() string {
+	return getenv("CSV_ENCODING")
+}
+
+// decodeReader wraps `r` so that it yields UTF-8 regardless of the source encoding `name`.
+// Names are the labels from the WHATWG encoding spec, which covers the usual legacy exports. Note that
+// the spec decodes `latin1` and `iso-8859-1` as Windows-1252, which is what those exports almost always
+// are: the smart quotes and dashes at 0x91-0x97 come through as ‘’“”–— rather than control characters.
+//
+// `auto` keeps UTF-8 input as is and decodes anything that isn't valid UTF-8 as Windows-1252. It reads
+// the whole file into memory to decide.
+func decodeReader(r io.Reader, name string) (io.Reader, error) {
+	if strings.EqualFold(name, "auto") {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if utf8.Valid(b) {
+			return bytes.NewReader(b), nil
+		}
+		return charmap.Windows1252.NewDecoder().Reader(bytes.NewReader(b)), nil
+	}
+
+	if name == "" || strings.EqualFold(name, "utf-8") || strings.EqualFold(name, "utf8") {
+		return r, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported CSV_ENCODING %q: %w", name, err)
+	}
+	if enc == unicode.UTF8 {
+		return r, nil
+	}
+
+	return enc.NewDecoder().Reader(r), nil
+}
+
+// defaultMaxLineBytes caps a single line of a file when MAX_LINE_BYTES isn't set. Real rows are a few
+// hundred bytes; a megabyte leaves room for very long quoted descriptions.
+const defaultMaxLineBytes = 1 << 20
+
+// lineLimitReader fails with ErrLineTooLong once a line runs past `max` bytes, so a file without line
+// breaks is rejected as soon as it is clearly malformed instead of being read whole as one record.
+// Lines are counted on the raw text, so a quoted field with line breaks only has each of its lines
+// held to the limit.
+type lineLimitReader struct {
+	r    io.Reader
+	max  int
+	run  int
+	line int
+	err  error
+}
+
+func newLineLimitReader(r io.Reader, max int) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &lineLimitReader{r: r, max: max, line: 1}
+}
+
+func (l *lineLimitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	n, err := l.r.Read(p)
+	for i, c := range p[:n] {
+		if c == '\n' {
+			l.run = 0
+			l.line++
+			continue
+		}
+		l.run++
+		if l.run > l.max {
+			// hand over what came before first, the error comes with the next call
+			l.err = fmt.Errorf("%w: line %d is over MAX_LINE_BYTES (%d bytes)", ErrLineTooLong, l.line, l.max)
+			if i > 0 {
+				return i, nil
+			}
+			return 0, l.err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// descriptions reads `content` with readCSV and returns the Description of every row.
+func descriptions(t *testing.T, content string) []string {
+	t.Helper()
+
+	ts, err := readCSV(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ds []string
+	for _, tr := range ts {
+		ds = append(ds, tr.Description)
+	}
+	return ds
+}
+
+func TestReadCSVLatin1(t *testing.T) {
+	t.Setenv("CSV_ENCODING", "iso-8859-1")
+	withConfig(t, nil)
+
+	got := descriptions(t, "Id,Date,Transaction,Description\n0,7/15,+60.5,Caf\xe9 Se\xf1or\n1,7/28,-10.3,Cr\xe8me br\xfbl\xe9e\n")
+	if strings.Join(got, "|") != "Café Señor|Crème brûlée" {
+		t.Errorf("descriptions = %q, want the accents decoded", got)
+	}
+}
+
+func TestReadCSVUTF8ByDefault(t *testing.T) {
+	withConfig(t, nil)
+
+	got := descriptions(t, "Id,Date,Transaction,Description\n0,7/15,+60.5,Café Señor\n")
+	if strings.Join(got, "|") != "Café Señor" {
+		t.Errorf("descriptions = %q, want UTF-8 kept as is", got)
+	}
+}
+
+func TestReadCSVAutoEncoding(t *testing.T) {
+	t.Setenv("CSV_ENCODING", "auto")
+	withConfig(t, nil)
+
+	for name, content := range map[string]string{
+		"utf-8":        "Id,Date,Transaction,Description\n0,7/15,+60.5,Café\n",
+		"windows-1252": "Id,Date,Transaction,Description\n0,7/15,+60.5,Caf\xe9\n",
+	} {
+		if got := descriptions(t, content); len(got) != 1 || got[0] != "Café" {
+			t.Errorf("%s: descriptions = %q, want Café", name, got)
+		}
+	}
+}
+
+func TestReadCSVUnsupportedEncoding(t *testing.T) {
+	t.Setenv("CSV_ENCODING", "klingon")
+	withConfig(t, nil)
+
+	if _, err := readCSV(strings.NewReader("Id,Date,Transaction\n")); err == nil || !strings.Contains(err.Error(), "CSV_ENCODING") {
+		t.Errorf("readCSV error = %v, want the encoding rejected", err)
+	}
+}
+
+func TestReadCSVWindows1252Punctuation(t *testing.T) {
+	content := "Id,Date,Transaction,Description\n0,7/15,+60.5,\x91Joe\x92s\x93 \x94 \x95 \x96 \x97\n"
+	for _, name := range []string{"latin1", "iso-8859-1", "windows-1252", "auto"} {
+		t.Setenv("CSV_ENCODING", name)
+		withConfig(t, nil)
+
+		if got := descriptions(t, content); len(got) != 1 || got[0] != "‘Joe’s“ ” • – —" {
+			t.Errorf("%s: descriptions = %q, want the smart quotes and dashes", name, got)
+		}
+	}
+}
+
+func TestReadCSVLineTooLong(t *testing.T) {
+	t.Setenv("MAX_LINE_BYTES", "40")
+	withConfig(t, nil)
+
+	header := "Id,Date,Transaction,Description\n"
+	long := "1,7/28,-10.3," + strings.Repeat("x", 28) + "\n"
+	for name, content := range map[string]string{
+		"long row":        header + "0,7/15,+60.5,Coffee\n" + long,
+		"no line breaks":  strings.Repeat("x", 100),
+		"long header row": strings.Repeat("Id,", 20) + "\n",
+	} {
+		if _, err := readCSV(strings.NewReader(content)); !errors.Is(err, ErrLineTooLong) {
+			t.Errorf("%s: readCSV error = %v, want ErrLineTooLong", name, err)
+		}
+	}
+	if _, err := readCSV(strings.NewReader(header + "0,7/15,+60.5,Coffee\n" + long)); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("readCSV error = %v, want it to name line 3", err)
+	}
+
+	// exactly at the limit is fine, and so is a quoted field whose every line is
+	atLimit := "1,7/28,-10.3," + strings.Repeat("x", 27)
+	quoted := "2,8/2,-20.46,\"" + strings.Repeat("y", 20) + "\n" + strings.Repeat("y", 20) + "\"\n"
+	ts, err := readCSV(strings.NewReader(header + atLimit + "\n" + quoted))
+	if err != nil || len(ts) != 2 {
+		t.Errorf("readCSV = %d rows, %v, want both rows", len(ts), err)
+	}
+
+	t.Setenv("MAX_LINE_BYTES", "0")
+	withConfig(t, nil)
+	if _, err := readCSV(strings.NewReader(header + long)); err != nil {
+		t.Errorf("readCSV with MAX_LINE_BYTES=0 = %v, want no limit", err)
+	}
+}
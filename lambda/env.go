@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// The helpers below read settings through getenv, so anything the environment leaves unset can
+// come from the S3 config document instead.
+
+// envDefault returns the environment variable `name`, or `def` when it is unset or empty.
+func envDefault(name, def string) string {
+	if v := getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool reports whether the environment variable `name` is set to a true value like `true` or `1`.
+// Anything unset or unparseable is false, so every flag defaults to off.
+func envBool(name string) bool {
+	b, err := strconv.ParseBool(getenv(name))
+	return err == nil && b
+}
+
+// envBoolDefault is envBool for flags that are on unless explicitly turned off.
+func envBoolDefault(name string, def bool) bool {
+	b, err := strconv.ParseBool(getenv(name))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// envDuration parses the environment variable `name` as a duration like `10s`, returning `def` when
+// it is unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(getenv(name))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// envFloat parses the environment variable `name` as a number, returning `def` when it is unset or invalid.
+func envFloat(name string, def float64) float64 {
+	f, err := strconv.ParseFloat(getenv(name), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// envInt parses the environment variable `name` as a positive integer, returning `def` when it is
+// unset, invalid or not positive.
+func envInt(name string, def int) int {
+	return envIntMin(name, def, 1)
+}
+
+// envIntMin is envInt for settings where anything from `min` up is valid, like a retry count of 0.
+func envIntMin(name string, def, min int) int {
+	i, err := strconv.Atoi(getenv(name))
+	if err != nil || i < min {
+		return def
+	}
+	return i
+}
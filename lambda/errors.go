@@ -0,0 +1,38 @@
+package main
+
+import "errors"
+
+var (
+	// ErrBadEvent is returned when the S3 event doesn't reference exactly one object with a bucket and key.
+	ErrBadEvent = errors.New("malformed S3 event")
+	// ErrSourceNotAllowed is returned when the event points at a bucket or key outside ALLOWED_SOURCES.
+	ErrSourceNotAllowed = errors.New("event source is not in the allowlist")
+	// ErrUnauthorizedEvent is returned when an event fails the EVENT_CONFIGURATION_ID or EVENT_MAX_AGE check.
+	ErrUnauthorizedEvent = errors.New("event could not be verified")
+	// ErrEmptyFile is returned when the uploaded object has no content at all.
+	ErrEmptyFile = errors.New("file is empty")
+	// ErrTooManyRows is returned when a file has more rows than MAX_ROWS.
+	ErrTooManyRows = errors.New("file has too many rows")
+	// ErrShortRow is returned when a row doesn't reach the Id, Date or Transaction column.
+	ErrShortRow = errors.New("row is missing required fields")
+	// ErrLineTooLong is returned when a line of a file is longer than MAX_LINE_BYTES.
+	ErrLineTooLong = errors.New("line is too long")
+	// ErrSwappedColumns is returned when the Date and Transaction columns of a file look swapped.
+	ErrSwappedColumns = errors.New("date and amount columns appear swapped")
+	// ErrBadManifest is returned when a manifest object isn't valid JSON in the manifest schema.
+	ErrBadManifest = errors.New("malformed manifest")
+	// ErrManifestKeyMissing is returned when a manifest lists a key that doesn't exist.
+	ErrManifestKeyMissing = errors.New("file in manifest does not exist")
+	// ErrFutureDate is returned for a row dated after the processing date when REJECT_FUTURE_DATES is `error`.
+	ErrFutureDate = errors.New("transaction is dated in the future")
+	// ErrMissingAccount is returned when some rows of a file have an Account and others don't.
+	ErrMissingAccount = errors.New("rows without an account in a multi account file")
+	// ErrNoRecipient is returned when an account in a multi account file has no recipient mapping.
+	ErrNoRecipient = errors.New("no recipient for account")
+	// ErrSMTPHostNotAllowed is returned when the SMTP host in EMAIL_SECRET isn't in SMTP_HOST_ALLOWLIST.
+	ErrSMTPHostNotAllowed = errors.New("smtp host is not in the allowlist")
+	// ErrSecretUnavailable is returned when EMAIL_SECRET can't be fetched from Secrets Manager.
+	ErrSecretUnavailable = errors.New("email secret is unavailable")
+	// ErrEmptySecret is returned when a secret has neither a string nor a binary value.
+	ErrEmptySecret = errors.New("secret has no value")
+)
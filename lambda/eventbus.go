@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+// eventSource is the source of every summary event, for EventBridge rules to match on.
+const eventSource = "stori.summary"
+
+// summaryEvent is the detail of the event published for each summary.
+type summaryEvent struct {
+	// Account is empty for single account files.
+	Account     string  `json:"account,omitempty"`
+	Bucket      string  `json:"bucket"`
+	Key         string  `json:"key"`
+	PeriodStart string  `json:"period_start,omitempty"`
+	PeriodEnd   string  `json:"period_end,omitempty"`
+	CreditCount int     `json:"credit_count"`
+	CreditTotal float64 `json:"credit_total"`
+	DebitCount  int     `json:"debit_count"`
+	DebitTotal  float64 `json:"debit_total"`
+	NetChange   float64 `json:"net_change"`
+}
+
+// publishSummary sends `sm` for `obj` to EVENT_BUS_NAME with EVENT_DETAIL_TYPE.
+func publishSummary(svc eventbridgeiface.EventBridgeAPI, obj s3Object, account string, sm Summaries) error {
+	b, err := json.Marshal(summaryEvent{
+		Account:     account,
+		Bucket:      obj.Bucket,
+		Key:         obj.Key,
+		PeriodStart: sm.FirstDate,
+		PeriodEnd:   sm.LastDate,
+		CreditCount: sm.CreditCount,
+		CreditTotal: sm.CreditTotal,
+		DebitCount:  sm.DebitCount,
+		DebitTotal:  sm.DebitTotal,
+		NetChange:   sm.CreditTotal + sm.DebitTotal,
+	})
+	if err != nil {
+		return err
+	}
+
+	out, err := svc.PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(envDefault("EVENT_BUS_NAME", "default")),
+			DetailType:   aws.String(envDefault("EVENT_DETAIL_TYPE", "TransactionSummary")),
+			Source:       aws.String(eventSource),
+			Detail:       aws.String(string(b)),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// PutEvents reports rejected entries in the response rather than as an error
+	if aws.Int64Value(out.FailedEntryCount) > 0 && len(out.Entries) > 0 {
+		e := out.Entries[0]
+		return fmt.Errorf("event rejected: %s: %s", aws.StringValue(e.ErrorCode), aws.StringValue(e.ErrorMessage))
+	}
+
+	return nil
+}
+
+// maybePublish publishes the summary when PUBLISH_EVENTS is set. Failures are only logged unless
+// EVENT_PUBLISH_FATAL is set.
+func maybePublish(sess *session.Session, obj s3Object, account string, sm Summaries) error {
+	if !features().PublishEvents {
+		return nil
+	}
+
+	err := publishSummary(eventbridge.New(sess), obj, account, sm)
+	if err == nil {
+		return nil
+	}
+	if features().EventPublishFatal {
+		return fmt.Errorf("publishing summary event: %w", err)
+	}
+
+	f := obj.fields()
+	f["account"] = account
+	f["error"] = err.Error()
+	logJSON("warn", "publishing summary event failed", f)
+	return nil
+}
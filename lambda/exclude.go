@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// excludedIDs reads EXCLUDE_IDS, the transaction ids to leave out of every summary. It returns nil when
+// nothing is excluded.
+func excludedIDs(svc s3iface.S3API) (map[string]bool, error) {
+	return idSet(svc, "EXCLUDE_IDS")
+}
+
+// suppressedAccounts reads SUPPRESS_ACCOUNTS, the accounts that are summarized but never emailed, like
+// internal and test accounts. It returns nil when no account is suppressed.
+func suppressedAccounts(svc s3iface.S3API) (map[string]bool, error) {
+	return idSet(svc, "SUPPRESS_ACCOUNTS")
+}
+
+// idSet reads the setting `name` as a set of ids. It is either a comma separated list or an
+// `s3://bucket/key` object with one id per line, for lists too long to keep in the environment.
+func idSet(svc s3iface.S3API, name string) (map[string]bool, error) {
+	v := strings.TrimSpace(getenv(name))
+	if v == "" {
+		return nil, nil
+	}
+
+	ids := make(map[string]bool)
+	if !strings.HasPrefix(v, "s3://") {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids[id] = true
+			}
+		}
+		return ids, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(v, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid %s location %q", name, v)
+	}
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(parts[0]),
+		Key:    aws.String(parts[1]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading %s %s: %w", name, v, err)
+	}
+	defer out.Body.Close()
+
+	sc := bufio.NewScanner(out.Body)
+	for sc.Scan() {
+		if id := strings.TrimSpace(sc.Text()); id != "" {
+			ids[id] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s %s: %w", name, v, err)
+	}
+
+	return ids, nil
+}
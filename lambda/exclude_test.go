@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExcludedIDs(t *testing.T) {
+	t.Setenv("EXCLUDE_IDS", " 1, 3 ,,")
+	withConfig(t, nil)
+
+	ids, err := excludedIDs(&fakeS3{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || !ids["1"] || !ids["3"] {
+		t.Errorf("excludedIDs = %v, want 1 and 3", ids)
+	}
+}
+
+func TestExcludedIDsFromS3(t *testing.T) {
+	f := &fakeS3{}
+	f.put("config", "exclude.txt", storedObject{Body: []byte("1\n\n  3  \n")})
+	t.Setenv("EXCLUDE_IDS", "s3://config/exclude.txt")
+	withConfig(t, nil)
+
+	ids, err := excludedIDs(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || !ids["1"] || !ids["3"] {
+		t.Errorf("excludedIDs = %v, want 1 and 3", ids)
+	}
+
+	for _, v := range []string{"s3://config", "s3:///exclude.txt", "s3://config/missing.txt"} {
+		t.Setenv("EXCLUDE_IDS", v)
+		withConfig(t, nil)
+		if _, err := excludedIDs(f); err == nil || !strings.Contains(err.Error(), "EXCLUDE_IDS") {
+			t.Errorf("%s: excludedIDs error = %v, want it named", v, err)
+		}
+	}
+}
+
+func TestExcludedIDsUnset(t *testing.T) {
+	withConfig(t, nil)
+
+	if ids, err := excludedIDs(&fakeS3{}); ids != nil || err != nil {
+		t.Errorf("excludedIDs = %v, %v, want nil", ids, err)
+	}
+}
+
+func TestGetSummariesExcluded(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(sampleTransactions, summaryOptions{exclude: map[string]bool{"1": true, "3": true, "9": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Excluded != 2 || sm.CreditTotal != 60.5 || sm.DebitTotal != -20.46 {
+		t.Errorf("Excluded, credits, debits = %d, %v, %v, want 2, 60.5, -20.46", sm.Excluded, sm.CreditTotal, sm.DebitTotal)
+	}
+}
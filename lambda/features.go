@@ -0,0 +1,87 @@
+package main
+
+// Features are the optional behaviors that are switched on and off, read together instead of one
+// setting at a time wherever they are checked. Each field is its setting, with the usual precedence of
+// the environment over the config document, and all of them are off by default. Settings that take a
+// value, like DEDUP, keep their own getters since they need validating.
+type Features struct {
+	// parsing
+	StrictAmountFormat bool
+	VariableFields     bool
+
+	// email contents
+	IncludeTransactions bool
+	IncludeEmptyMonths  bool
+	IncludeDayOfMonth   bool
+	IncludeVelocity     bool
+	PerYearAverages     bool
+	NoActivityEmail     bool
+	StatementLink       bool
+	StatementQR         bool
+	CategoryTotals      bool
+
+	// outputs
+	WriteSummary   bool
+	SignSummary    bool
+	CompressOutput bool
+	UploadRendered bool
+	PublishEvents  bool
+	TagProcessed   bool
+	DryRun         bool
+
+	// failure handling
+	Quarantine         bool
+	DeferOnSecretError bool
+	EventPublishFatal  bool
+
+	// delivery
+	SMTPHostStrict bool
+}
+
+// readFeatures reads every feature setting.
+func readFeatures() Features {
+	return Features{
+		StrictAmountFormat:  envBool("STRICT_AMOUNT_FORMAT"),
+		VariableFields:      envBool("VARIABLE_FIELDS"),
+		IncludeTransactions: envBool("INCLUDE_TRANSACTIONS"),
+		IncludeEmptyMonths:  envBool("INCLUDE_EMPTY_MONTHS"),
+		IncludeDayOfMonth:   envBool("INCLUDE_DAY_OF_MONTH"),
+		IncludeVelocity:     envBool("INCLUDE_VELOCITY"),
+		PerYearAverages:     envBool("PER_YEAR_AVERAGES"),
+		NoActivityEmail:     envBool("NO_ACTIVITY_EMAIL"),
+		StatementLink:       envBool("INCLUDE_STATEMENT_LINK"),
+		StatementQR:         envBool("INCLUDE_STATEMENT_QR"),
+		CategoryTotals:      envBool("CATEGORY_TOTALS"),
+		WriteSummary:        envBool("WRITE_SUMMARY"),
+		SignSummary:         envBool("SIGN_SUMMARY"),
+		CompressOutput:      envBool("COMPRESS_OUTPUT"),
+		UploadRendered:      envBool("UPLOAD_RENDERED"),
+		PublishEvents:       envBool("PUBLISH_EVENTS"),
+		TagProcessed:        envBool("TAG_PROCESSED"),
+		DryRun:              envBool("DRY_RUN"),
+		Quarantine:          envBool("QUARANTINE"),
+		DeferOnSecretError:  envBool("DEFER_ON_SECRET_ERROR"),
+		EventPublishFatal:   envBool("EVENT_PUBLISH_FATAL"),
+		SMTPHostStrict:      envBool("SMTP_HOST_STRICT"),
+	}
+}
+
+// features returns the feature flags. They are read once the config document is loaded and cached
+// with it; before that, say when the event itself was rejected, they come from the environment alone
+// and aren't cached.
+func features() Features {
+	config.mu.Lock()
+	f, loaded := config.features, config.loaded != nil
+	config.mu.Unlock()
+	if f != nil {
+		return *f
+	}
+
+	ft := readFeatures()
+	if loaded {
+		config.mu.Lock()
+		config.features = &ft
+		config.mu.Unlock()
+	}
+	return ft
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestFeaturesPrecedence(t *testing.T) {
+	on, off := true, false
+	flags := []struct {
+		env string
+		set func(*Config, *bool)
+		get func(Features) bool
+	}{
+		{"STRICT_AMOUNT_FORMAT", func(c *Config, v *bool) { c.StrictAmountFormat = v }, func(f Features) bool { return f.StrictAmountFormat }},
+		{"VARIABLE_FIELDS", func(c *Config, v *bool) { c.VariableFields = v }, func(f Features) bool { return f.VariableFields }},
+		{"SMTP_HOST_STRICT", func(c *Config, v *bool) { c.SMTPHostStrict = v }, func(f Features) bool { return f.SMTPHostStrict }},
+		{"EVENT_PUBLISH_FATAL", func(c *Config, v *bool) { c.EventPublishFatal = v }, func(f Features) bool { return f.EventPublishFatal }},
+	}
+	for _, fl := range flags {
+		for _, tt := range []struct {
+			name string
+			env  string
+			file *bool
+			want bool
+		}{
+			{"default", "", nil, false},
+			{"file", "", &on, true},
+			{"env", "true", nil, true},
+			{"env over file", "false", &on, false},
+			{"env on over file off", "true", &off, true},
+		} {
+			t.Run(fl.env+"/"+tt.name, func(t *testing.T) {
+				t.Setenv(fl.env, tt.env)
+				c := &Config{}
+				fl.set(c, tt.file)
+				withConfig(t, c)
+
+				if got := fl.get(features()); got != tt.want {
+					t.Errorf("%s = %v, want %v", fl.env, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestFeaturesCachedWithConfig(t *testing.T) {
+	withConfig(t, &Config{})
+	t.Setenv("VARIABLE_FIELDS", "true")
+	if !features().VariableFields {
+		t.Fatal("VARIABLE_FIELDS = false, want true")
+	}
+
+	// a warm invocation keeps what the loaded config was read with
+	t.Setenv("VARIABLE_FIELDS", "false")
+	if !features().VariableFields {
+		t.Error("features were read again while the config stayed loaded")
+	}
+}
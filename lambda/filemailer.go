@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultMailFile is where the file sink writes when MAIL_FILE_PATH isn't set.
+const defaultMailFile = "/tmp/email.eml"
+
+// fileMailer writes each message to a local `.eml` file instead of sending it, so the full MIME
+// message can be opened in a mail client during development. It is safe for concurrent use.
+type fileMailer struct {
+	path string
+
+	mu   sync.Mutex
+	sent int
+}
+
+// Send writes `msg` to the configured path. Further messages in the same invocation get a numbered
+// suffix, like `email-2.eml`, rather than overwriting the first.
+func (m *fileMailer) Send(to []string, msg []byte) (string, error) {
+	m.mu.Lock()
+	m.sent++
+	n := m.sent
+	m.mu.Unlock()
+
+	path := m.path
+	if n > 1 {
+		ext := filepath.Ext(path)
+		path = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+	}
+
+	if err := os.WriteFile(path, msg, 0o644); err != nil {
+		return "", err
+	}
+
+	logJSON("info", "wrote email to file", map[string]interface{}{"path": path, "to": to})
+	return "", nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// flushers holds any emitters that buffer output (metrics, async logs) and must be drained before
+// the handler returns, since Lambda may freeze the environment as soon as it does.
+var flushers struct {
+	mu  sync.Mutex
+	fns []func() error
+}
+
+// registerFlusher adds `f` to the set of functions run by flush at the end of every invocation.
+func registerFlusher(f func() error) {
+	flushers.mu.Lock()
+	defer flushers.mu.Unlock()
+	flushers.fns = append(flushers.fns, f)
+}
+
+// flush runs every registered flusher. Failures are logged rather than returned so that a metrics
+// problem can't turn a successful invocation into a failed one.
+func flush() {
+	flushers.mu.Lock()
+	defer flushers.mu.Unlock()
+	for _, f := range flushers.fns {
+		if err := f(); err != nil {
+			log.Printf("flush failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// futureDates decides what happens to rows dated after the processing date.
+type futureDates string
+
+const (
+	// futureInclude summarizes them like any other row.
+	futureInclude futureDates = ""
+	// futureExclude leaves them out and counts them in Summaries.FutureDated.
+	futureExclude futureDates = "exclude"
+	// futureError fails the file, or records a row error in lenient mode.
+	futureError futureDates = "error"
+)
+
+// getFutureDates reads REJECT_FUTURE_DATES, which includes future dated rows by default.
+func getFutureDates() (futureDates, error) {
+	m := futureDates(strings.ToLower(getenv("REJECT_FUTURE_DATES")))
+	switch m {
+	case futureInclude, futureExclude, futureError:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("unsupported REJECT_FUTURE_DATES %q", m)
+}
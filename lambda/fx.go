@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fxRate converts amounts in the statement's currency into FX_BASE_CURRENCY.
+type fxRate struct {
+	// Currency is the ISO code of the base currency, e.g. `USD`.
+	Currency string
+	// Rate is how many units of the base currency one unit of the statement's currency is worth.
+	Rate float64 `json:"rate"`
+	// Date is the day the rate applies to, shown next to it in the email.
+	Date string `json:"date"`
+}
+
+// fxTimeout bounds the request to FX_RATE_URL.
+const fxTimeout = 5 * time.Second
+
+// getFXRate returns the conversion to FX_BASE_CURRENCY, or nil when it isn't set. The rate comes from
+// FX_RATE and FX_RATE_DATE, or is fetched from FX_RATE_URL, which must answer with JSON like
+// `{"rate": 0.049, "date": "2021-10-14"}`.
+func getFXRate() (*fxRate, error) {
+	code := strings.ToUpper(strings.TrimSpace(getenv("FX_BASE_CURRENCY")))
+	if code == "" {
+		return nil, nil
+	}
+
+	fx := fxRate{Currency: code, Date: getenv("FX_RATE_DATE")}
+	if u := getenv("FX_RATE_URL"); u != "" {
+		c := http.Client{Timeout: fxTimeout}
+		resp, err := c.Get(u)
+		if err != nil {
+			return nil, fmt.Errorf("fetching FX rate: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching FX rate: %s", resp.Status)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&fx); err != nil {
+			return nil, fmt.Errorf("parsing FX rate: %w", err)
+		}
+	} else {
+		r, err := strconv.ParseFloat(getenv("FX_RATE"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("FX_BASE_CURRENCY is set without a valid FX_RATE or FX_RATE_URL")
+		}
+		fx.Rate = r
+	}
+	if fx.Rate <= 0 {
+		return nil, fmt.Errorf("invalid FX rate %v", fx.Rate)
+	}
+	if fx.Date == "" {
+		fx.Date = time.Now().UTC().Format("2006-01-02")
+	}
+
+	return &fx, nil
+}
+
+// BaseTotals are the headline totals converted into the base currency, for the email.
+type BaseTotals struct {
+	Currency    string
+	Rate        float64
+	Date        string
+	NetChange   float64
+	CreditTotal float64
+	DebitTotal  float64
+}
+
+// convert applies the rate to the totals, rounding the results with `rm`.
+func (fx *fxRate) convert(net, credits, debits float64, rm roundingMode) *BaseTotals {
+	return &BaseTotals{
+		Currency:    fx.Currency,
+		Rate:        fx.Rate,
+		Date:        fx.Date,
+		NetChange:   rm.cents(net * fx.Rate),
+		CreditTotal: rm.cents(credits * fx.Rate),
+		DebitTotal:  rm.cents(debits * fx.Rate),
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fxServer answers FX_RATE_URL requests with `status` and a rate of 0.05, counting them.
+func fxServer(t *testing.T, status int) *int32 {
+	t.Helper()
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"rate": 0.05, "date": "2021-10-14"}`)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("FX_BASE_CURRENCY", "usd")
+	t.Setenv("FX_RATE_URL", srv.URL)
+	return &hits
+}
+
+func TestFXRateFetchedOncePerSender(t *testing.T) {
+	hits := fxServer(t, http.StatusOK)
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+
+	sm, err := getSummaries(sampleTransactions, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := newEmailSender(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	for _, to := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := es.fork(context.Background()).send(sm, Recipient{Email: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt32(hits); n != 1 {
+		t.Errorf("fetched the rate %d times for 3 emails, want 1", n)
+	}
+	for _, msg := range m.Messages() {
+		if _, body := msg.Parse(t); !strings.Contains(body, "Converted at 0.05 USD per unit on 2021-10-14") {
+			t.Errorf("body is missing the conversion:\n%s", body)
+		}
+	}
+}
+
+func TestFXRateFetchFailure(t *testing.T) {
+	fxServer(t, http.StatusServiceUnavailable)
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+
+	if _, err := sendEmail(context.Background(), Summaries{}); err == nil || !strings.Contains(err.Error(), "fetching FX rate") {
+		t.Errorf("sendEmail error = %v, want the FX fetch failure", err)
+	}
+	if n := len(m.Messages()); n != 0 {
+		t.Errorf("sent %d messages without a rate, want 0", n)
+	}
+}
+
+func TestFXRateStatic(t *testing.T) {
+	t.Setenv("FX_BASE_CURRENCY", "USD")
+	t.Setenv("FX_RATE", "0.5")
+	t.Setenv("FX_RATE_DATE", "2021-10-01")
+	withConfig(t, nil)
+
+	fx, err := getFXRate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := fx.convert(39.74, 70.5, -30.76, roundHalfEven)
+	if bt.NetChange != 19.87 || bt.CreditTotal != 35.25 || bt.DebitTotal != -15.38 || bt.Date != "2021-10-01" {
+		t.Errorf("convert = %+v", bt)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// highWaterMode decides how a re-uploaded file is matched up with the rows already summarized.
+type highWaterMode string
+
+const (
+	// highWaterID skips every row up to and including the last one summarized, found by its Id.
+	highWaterID highWaterMode = "id"
+	// highWaterDate skips every row dated on or before the latest date summarized.
+	highWaterDate highWaterMode = "date"
+)
+
+// getHighWaterMode reads HIGH_WATER_BY, which defaults to `id`.
+func getHighWaterMode() (highWaterMode, error) {
+	m := highWaterMode(strings.ToLower(envDefault("HIGH_WATER_BY", string(highWaterID))))
+	switch m {
+	case highWaterID, highWaterDate:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("unsupported HIGH_WATER_BY %q", m)
+}
+
+// highWaterMark is how far into an append-only file the last run got, kept in HIGH_WATER_TABLE under
+// the string attribute `Key`, the object's `bucket/key`.
+type highWaterMark struct {
+	Key string `dynamodbav:"Key"`
+	// LastID is the Id of the file's last row and LastDate its latest date, as written in the file.
+	LastID    string `dynamodbav:"LastId"`
+	LastDate  string `dynamodbav:"LastDate"`
+	UpdatedAt string `dynamodbav:"UpdatedAt"`
+}
+
+// newHighWaterDB returns the client for HIGH_WATER_TABLE. It is a variable so the handler can keep its
+// marks somewhere other than DynamoDB.
+var newHighWaterDB = func(sess *session.Session) dynamodbiface.DynamoDBAPI {
+	return dynamodb.New(sess)
+}
+
+// highWaterKey is the item key for `obj`. The ETag is left out on purpose, since every append makes a
+// new one.
+func highWaterKey(obj s3Object) string {
+	return obj.Bucket + "/" + obj.Key
+}
+
+// loadHighWater returns the mark stored for `obj`, and false when the file wasn't seen before.
+func loadHighWater(svc dynamodbiface.DynamoDBAPI, table string, obj s3Object) (highWaterMark, bool, error) {
+	out, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            map[string]*dynamodb.AttributeValue{"Key": {S: aws.String(highWaterKey(obj))}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return highWaterMark{}, false, fmt.Errorf("loading high-water mark for %s: %w", obj, err)
+	}
+	if len(out.Item) == 0 {
+		return highWaterMark{}, false, nil
+	}
+
+	var hw highWaterMark
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &hw); err != nil {
+		return highWaterMark{}, false, fmt.Errorf("reading high-water mark for %s: %w", obj, err)
+	}
+	return hw, true, nil
+}
+
+// saveHighWater stores `hw`, replacing the previous mark.
+func saveHighWater(svc dynamodbiface.DynamoDBAPI, table string, hw highWaterMark) error {
+	item, err := dynamodbattribute.MarshalMap(hw)
+	if err != nil {
+		return err
+	}
+	if _, err := svc.PutItem(&dynamodb.PutItemInput{TableName: aws.String(table), Item: item}); err != nil {
+		return fmt.Errorf("saving high-water mark for %s: %w", hw.Key, err)
+	}
+	return nil
+}
+
+// advanceHighWater saves `hw` for `obj` once its rows are out. A failure is only logged: the email is
+// already sent, so failing the run would send it again on retry, whereas a stale mark just means the
+// next upload summarizes these rows a second time.
+func advanceHighWater(svc dynamodbiface.DynamoDBAPI, table string, obj s3Object, hw highWaterMark) {
+	if err := saveHighWater(svc, table, hw); err != nil {
+		f := obj.fields()
+		f["error"] = err.Error()
+		logJSON("error", "saving high-water mark failed", f)
+	}
+}
+
+// skipSummarized drops the rows of `ts` that the mark stored for `obj` says were already summarized,
+// and returns that mark too. A file without a mark is summarized whole.
+func skipSummarized(svc dynamodbiface.DynamoDBAPI, table string, obj s3Object, ts []TransactionCSV) ([]TransactionCSV, highWaterMark, error) {
+	m, err := getHighWaterMode()
+	if err != nil {
+		return nil, highWaterMark{}, err
+	}
+	hw, ok, err := loadHighWater(svc, table, obj)
+	if err != nil || !ok {
+		return ts, hw, err
+	}
+
+	rest, found := rowsAfter(ts, hw, m)
+	f := obj.fields()
+	f["rows"] = len(ts)
+	f["new_rows"] = len(rest)
+	f["last_id"] = hw.LastID
+	f["last_date"] = hw.LastDate
+	if !found {
+		logJSON("warn", "high-water mark not found in file, summarizing every row", f)
+	} else {
+		logJSON("info", "skipped rows before high-water mark", f)
+	}
+	return rest, hw, nil
+}
+
+// rowsAfter returns the rows of `ts` past `hw`. In id mode it reports false when the last Id isn't in
+// the file at all, which means the file was rewritten rather than appended to, and every row is
+// returned. In date mode rows without a readable date are always kept, and every row dated on the
+// latest date already summarized is dropped, including ones appended since; files that get more rows
+// for the same day after an upload need id mode.
+func rowsAfter(ts []TransactionCSV, hw highWaterMark, m highWaterMode) ([]TransactionCSV, bool) {
+	if m == highWaterDate {
+		last, ok := getDate(hw.LastDate)
+		if !ok {
+			return ts, false
+		}
+		var rest []TransactionCSV
+		for _, t := range ts {
+			if dt, ok := getDate(t.Date); !ok || dt.After(last) {
+				rest = append(rest, t)
+			}
+		}
+		return rest, true
+	}
+
+	// scanning from the end finds the mark even when an Id repeats earlier in the ledger
+	for i := len(ts) - 1; i >= 0; i-- {
+		if ts[i].ID == hw.LastID {
+			return ts[i+1:], true
+		}
+	}
+	return ts, false
+}
+
+// nextMark is the mark to store for `obj` once all of `ts`, the whole file, has been summarized. An
+// empty file keeps the previous mark `prev`.
+func nextMark(obj s3Object, ts []TransactionCSV, prev highWaterMark, now time.Time) highWaterMark {
+	hw := highWaterMark{Key: highWaterKey(obj), LastID: prev.LastID, LastDate: prev.LastDate, UpdatedAt: now.UTC().Format(time.RFC3339)}
+	if len(ts) == 0 {
+		return hw
+	}
+
+	hw.LastID = ts[len(ts)-1].ID
+	latest, ok := getDate(prev.LastDate)
+	for _, t := range ts {
+		if dt, dok := getDate(t.Date); dok && (!ok || dt.After(latest)) {
+			latest, ok, hw.LastDate = dt, true, t.Date
+		}
+	}
+	return hw
+}
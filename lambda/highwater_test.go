@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDynamoDB is an in-memory table keyed by the single string attribute `keyAttr`, `Key` when empty.
+// Only GetItem and PutItem are implemented; anything else panics on the nil embedded interface.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	keyAttr string
+	items   map[string]map[string]*dynamodb.AttributeValue
+	// putErr, when set, fails every PutItem.
+	putErr error
+	puts   int
+}
+
+func (d *fakeDynamoDB) attr() string {
+	if d.keyAttr == "" {
+		return "Key"
+	}
+	return d.keyAttr
+}
+
+func (d *fakeDynamoDB) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: d.items[aws.StringValue(in.Key[d.attr()].S)]}, nil
+}
+
+func (d *fakeDynamoDB) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.puts++
+	if d.putErr != nil {
+		return nil, d.putErr
+	}
+	if d.items == nil {
+		d.items = map[string]map[string]*dynamodb.AttributeValue{}
+	}
+	d.items[aws.StringValue(in.Item[d.attr()].S)] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func ids(ts []TransactionCSV) []string {
+	out := []string{}
+	for _, t := range ts {
+		out = append(out, t.ID)
+	}
+	return out
+}
+
+func TestRowsAfter(t *testing.T) {
+	ts := []TransactionCSV{
+		{ID: "1", Date: "7/1/2021"},
+		{ID: "2", Date: "7/2/2021"},
+		{ID: "1", Date: "7/2/2021"},
+		{ID: "3", Date: "7/3/2021"},
+		{ID: "4", Date: "not a date"},
+	}
+	for _, tt := range []struct {
+		name  string
+		hw    highWaterMark
+		m     highWaterMode
+		want  []string
+		found bool
+	}{
+		{"id from the last occurrence", highWaterMark{LastID: "1"}, highWaterID, []string{"3", "4"}, true},
+		{"id at the end", highWaterMark{LastID: "4"}, highWaterID, []string{}, true},
+		{"id rewritten file", highWaterMark{LastID: "9"}, highWaterID, []string{"1", "2", "1", "3", "4"}, false},
+		{"date drops the latest date", highWaterMark{LastDate: "7/2/2021"}, highWaterDate, []string{"3", "4"}, true},
+		{"date unreadable mark", highWaterMark{LastDate: "soon"}, highWaterDate, []string{"1", "2", "1", "3", "4"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := rowsAfter(ts, tt.hw, tt.m)
+			if !reflect.DeepEqual(ids(got), tt.want) || found != tt.found {
+				t.Errorf("rowsAfter = %v, %v, want %v, %v", ids(got), found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestNextMark(t *testing.T) {
+	now := time.Date(2021, 8, 1, 12, 0, 0, 0, time.UTC)
+	obj := s3Object{Bucket: "b", Key: "ledger.csv"}
+	prev := highWaterMark{LastID: "2", LastDate: "7/20/2021"}
+
+	hw := nextMark(obj, []TransactionCSV{{ID: "3", Date: "7/25/2021"}, {ID: "4", Date: "7/10/2021"}}, prev, now)
+	want := highWaterMark{Key: "b/ledger.csv", LastID: "4", LastDate: "7/25/2021", UpdatedAt: "2021-08-01T12:00:00Z"}
+	if hw != want {
+		t.Errorf("nextMark = %+v, want %+v", hw, want)
+	}
+
+	// an empty file keeps the previous position
+	if hw := nextMark(obj, nil, prev, now); hw.LastID != "2" || hw.LastDate != "7/20/2021" {
+		t.Errorf("nextMark of an empty file = %+v, want the previous mark", hw)
+	}
+}
+
+// TestDeltaSummary uploads an append-only ledger twice and checks the second run only summarizes the
+// appended rows.
+func TestDeltaSummary(t *testing.T) {
+	for _, mode := range []highWaterMode{highWaterID, highWaterDate} {
+		t.Run(string(mode), func(t *testing.T) {
+			t.Setenv("HIGH_WATER_BY", string(mode))
+			withConfig(t, nil)
+			db := &fakeDynamoDB{}
+			obj := s3Object{Bucket: "b", Key: "ledger.csv", ETag: "v1"}
+			first := []TransactionCSV{{ID: "1", Date: "7/1/2021"}, {ID: "2", Date: "7/2/2021"}}
+
+			ts, hw, err := skipSummarized(db, "marks", obj, first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(ts); !reflect.DeepEqual(got, []string{"1", "2"}) {
+				t.Fatalf("first upload summarized %v, want every row", got)
+			}
+			advanceHighWater(db, "marks", obj, nextMark(obj, first, hw, time.Now()))
+
+			obj.ETag = "v2"
+			second := append(first, TransactionCSV{ID: "3", Date: "7/3/2021"}, TransactionCSV{ID: "4", Date: "7/4/2021"})
+			ts, _, err = skipSummarized(db, "marks", obj, second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(ts); !reflect.DeepEqual(got, []string{"3", "4"}) {
+				t.Errorf("second upload summarized %v, want only the appended rows", got)
+			}
+		})
+	}
+}
+
+// useFakeHighWaterDB makes the handler keep its high-water marks in `db` until the test ends.
+func useFakeHighWaterDB(t *testing.T, db *fakeDynamoDB) {
+	t.Helper()
+
+	t.Setenv("HIGH_WATER_TABLE", "marks")
+	prev := newHighWaterDB
+	newHighWaterDB = func(*session.Session) dynamodbiface.DynamoDBAPI { return db }
+	t.Cleanup(func() { newHighWaterDB = prev })
+}
+
+func TestHandleRequestDeltaSummary(t *testing.T) {
+	db := &fakeDynamoDB{}
+	useFakeHighWaterDB(t, db)
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+
+	first := csvFixture{}.build(sampleTransactions[:2])
+	second := csvFixture{}.build(sampleTransactions)
+	objects := map[string][]byte{"uploads/ledger.csv": []byte(first)}
+	useFakeDownloader(t, objects)
+
+	if err := HandleRequest(context.Background(), s3Event("uploads", "ledger.csv", "v1", len(first))); err != nil {
+		t.Fatal(err)
+	}
+	objects["uploads/ledger.csv"] = []byte(second)
+	if err := HandleRequest(context.Background(), s3Event("uploads", "ledger.csv", "v2", len(second))); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := m.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(msgs))
+	}
+	// only the two August rows are new the second time
+	_, body := msgs[1].Parse(t)
+	for _, want := range []string{"Total credits: $10.00", "Total debits: -$20.46", "August: 2"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("second email is missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "July") {
+		t.Errorf("second email counts July rows again:\n%s", body)
+	}
+}
+
+func TestHandleRequestHighWaterSaveFails(t *testing.T) {
+	db := &fakeDynamoDB{putErr: errors.New("throttled")}
+	useFakeHighWaterDB(t, db)
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	content := csvFixture{}.build(sampleTransactions)
+	useFakeDownloader(t, map[string][]byte{"uploads/ledger.csv": []byte(content)})
+
+	// the email is out, so failing now would only send it again on retry
+	if err := HandleRequest(context.Background(), s3Event("uploads", "ledger.csv", "v1", len(content))); err != nil {
+		t.Errorf("HandleRequest = %v, want success when only the mark can't be saved", err)
+	}
+	if db.puts != 1 {
+		t.Errorf("tried to save the mark %d times, want 1", db.puts)
+	}
+	if n := len(m.Messages()); n != 1 {
+		t.Errorf("sent %d messages, want 1", n)
+	}
+}
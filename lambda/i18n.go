@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// language is the copy used to render an email in one language. Messages are keyed by the English
+// text, which is also what's shown for anything without a translation.
+type language struct {
+	// Months are the full month names, January first.
+	Months [12]string
+	// LongDate is the fmt format of a written out date, given the month name, day and year in that order.
+	LongDate string
+	Messages map[string]string
+}
+
+// languages are the email languages we can render, keyed by lower case ISO 639-1 code. English is
+// the text in the templates themselves, so it needs no messages. Sentences with values in them are
+// translated whole rather than word by word, since word order differs between languages.
+var languages = map[string]language{
+	"en": {
+		Months:   [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		LongDate: "%[1]s %[2]d, %[3]d",
+	},
+	"es": {
+		Months:   [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		LongDate: "%[2]d de %[1]s de %[3]d",
+		Messages: map[string]string{
+			"Hello":               "Hola",
+			"Customer":            "cliente",
+			"Thank you!":          "¡Gracias!",
+			"Net Change":          "Cambio neto",
+			"Transaction Summary": "Resumen de movimientos",
+			"Here is a summary of your latest transactions:": "Este es el resumen de tus movimientos más recientes:",
+			"Large transactions:":                            "Movimientos grandes:",
+			"ID":                                             "ID",
+			"Date":                                           "Fecha",
+			"Amount":                                         "Monto",
+			"Subscriptions/Recurring:":                       "Suscripciones/Recurrentes:",
+			"Period":                                         "Periodo",
+			"Total credits":                                  "Total de abonos",
+			"Total debits":                                   "Total de cargos",
+			"Net change as a share of credits":               "Cambio neto como porcentaje de los abonos",
+			"Average transactions per month":                 "Promedio de movimientos por mes",
+			"Average debit amount":                           "Cargo promedio",
+			"Average credit amount":                          "Abono promedio",
+			"Activity by day of month:":                      "Actividad por día del mes:",
+			"Statement date":                                 "Fecha del estado de cuenta",
+			"Refunds":                                        "Reembolsos",
+			"Total receipts":                                 "Total de cobros",
+			"Total payments":                                 "Total de pagos",
+			"Average receipt":                                "Cobro promedio",
+			"Average payment":                                "Pago promedio",
+			"Income":                                         "Ingresos",
+			"Transfers":                                      "Transferencias",
+			"View your statement online":                     "Ver tu estado de cuenta en línea",
+			"Scan to view your statement":                    "Escanea para ver tu estado de cuenta",
+			"Category":                                       "Categoría",
+			"Uncategorized":                                  "Sin categoría",
+			"Credits":                                        "Abonos",
+			"Debits":                                         "Cargos",
+			"Receipts":                                       "Cobros",
+			"Payments":                                       "Pagos",
+			"No transactions this period. There is nothing new on your account since your last summary.": "No hubo movimientos en este periodo. No hay nada nuevo en tu cuenta desde tu último resumen.",
+			"Here is the activity summary for your business account:":                                    "Este es el resumen de actividad de tu cuenta empresarial:",
+			"The transactions table is attached.":                                                        "La tabla de movimientos va adjunta.",
+			"on %[1]s (transaction %[2]s)":                                                               "el %[1]s (movimiento %[2]s)",
+			"Converted at %[1]v %[2]s per unit on %[3]s":                                                 "Convertido a %[1]v %[2]s por unidad el %[3]s",
+			"and %[1]d more...":                                                   "y %[1]d más...",
+			"%[1]d transactions":                                                  "%[1]d movimientos",
+			"Largest debit: %[1]s on %[2]s":                                       "Cargo más grande: %[1]s el %[2]s",
+			"Largest credit: %[1]s on %[2]s":                                      "Abono más grande: %[1]s el %[2]s",
+			"Largest receipt: %[1]s on %[2]s":                                     "Cobro más grande: %[1]s el %[2]s",
+			"Largest payment: %[1]s on %[2]s":                                     "Pago más grande: %[1]s el %[2]s",
+			"%[1]d average debit: %[2]s, average credit: %[3]s":                   "%[1]d cargo promedio: %[2]s, abono promedio: %[3]s",
+			"%[1]d average receipt: %[2]s, average payment: %[3]s":                "%[1]d cobro promedio: %[2]s, pago promedio: %[3]s",
+			"Statement %[1]d: %[2]d transactions, credits %[3]s, debits %[4]s":    "Estado de cuenta %[1]d: %[2]d movimientos, abonos %[3]s, cargos %[4]s",
+			"Statement %[1]d: %[2]d transactions, receipts %[3]s, payments %[4]s": "Estado de cuenta %[1]d: %[2]d movimientos, cobros %[3]s, pagos %[4]s",
+			"%[1]s: %[2]s, %[3]d months":                                          "%[1]s: %[2]s, %[3]d meses",
+			"Weekdays: %[1]d transactions, net %[2]s":                             "Entre semana: %[1]d movimientos, neto %[2]s",
+			"Weekend: %[1]d transactions, net %[2]s":                              "Fin de semana: %[1]d movimientos, neto %[2]s",
+			"Largest day over day change: %[1]s from %[2]s to %[3]s":              "Mayor cambio de un día a otro: %[1]s del %[2]s al %[3]s",
+			"Day %[1]d: %[2]d transactions, net %[3]s":                            "Día %[1]d: %[2]d movimientos, neto %[3]s",
+		},
+	},
+}
+
+// defaultLanguage is used when neither the account nor LOCALE names a language.
+const defaultLanguage = "en"
+
+// languageCode returns the language part of a locale like `es-MX` or `es_MX`, lower cased.
+func languageCode(loc string) string {
+	loc = strings.ToLower(strings.TrimSpace(loc))
+	if i := strings.IndexAny(loc, "-_"); i >= 0 {
+		loc = loc[:i]
+	}
+	return loc
+}
+
+// getLanguage picks the language for an email to a recipient with locale `loc`. A locale we have no
+// translation for falls back to LOCALE and then to English, since an account's data shouldn't be able
+// to stop its email; an unsupported LOCALE is a configuration error.
+func getLanguage(loc string) (language, error) {
+	if l, ok := languages[languageCode(loc)]; ok {
+		return l, nil
+	}
+
+	def := getenv("LOCALE")
+	if def == "" {
+		return languages[defaultLanguage], nil
+	}
+	l, ok := languages[languageCode(def)]
+	if !ok {
+		return language{}, fmt.Errorf("unsupported LOCALE %q", def)
+	}
+	return l, nil
+}
+
+// T translates the English `msg`, returning it unchanged when there is no translation. With `args`,
+// `msg` is a whole sentence with fmt placeholders numbered in English order, e.g. `Largest debit:
+// %[1]s on %[2]s`, so a translation can move them to where its own grammar puts them.
+func (l language) T(msg string, args ...interface{}) string {
+	if t, ok := l.Messages[msg]; ok {
+		msg = t
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// month translates a MonthlyTransactions key such as `January 2021` or `Jan`. Keys that aren't
+// English month names are returned unchanged.
+func (l language) month(key string) string {
+	name, rest := key, ""
+	if i := strings.IndexByte(key, ' '); i >= 0 {
+		name, rest = key[:i], key[i:]
+	}
+
+	for i, en := range languages[defaultLanguage].Months {
+		switch name {
+		case en:
+			return l.Months[i] + rest
+		case shortMonth(en):
+			return shortMonth(l.Months[i]) + rest
+		}
+	}
+	return key
+}
+
+// longDate writes out `t` in the language, e.g. `July 1, 2024` or `1 de julio de 2024`.
+func (l language) longDate(t time.Time) string {
+	return fmt.Sprintf(l.LongDate, l.Months[t.Month()-1], t.Day(), t.Year())
+}
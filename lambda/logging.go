@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// logJSON writes `msg` together with `fields` as a single JSON line, so CloudWatch Logs Insights can
+// filter on individual fields instead of parsing free text.
+func logJSON(level, msg string, fields map[string]interface{}) {
+	entry := map[string]interface{}{"level": level, "msg": msg}
+	for k, v := range fields {
+		entry[k] = v
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("%s: %s %v", level, msg, fields)
+		return
+	}
+	log.Print(string(b))
+}
+
+// s3Object identifies the exact version of the object an invocation processed. The ETag changes
+// whenever the bytes do, so it tells a reprocessed file apart from a re-uploaded one.
+type s3Object struct {
+	Bucket string
+	Key    string
+	ETag   string
+}
+
+// sourceObject returns the object referenced by `ev`. Malformed or hand built events that don't
+// name exactly one object return ErrBadEvent instead of panicking further down.
+func sourceObject(ev events.S3Event) (s3Object, error) {
+	switch {
+	case len(ev.Records) == 0:
+		return s3Object{}, fmt.Errorf("%w: no records", ErrBadEvent)
+	case len(ev.Records) > 1:
+		return s3Object{}, fmt.Errorf("%w: expected 1 record, got %d", ErrBadEvent, len(ev.Records))
+	}
+
+	r := ev.Records[0].S3
+	if r.Bucket.Name == "" {
+		return s3Object{}, fmt.Errorf("%w: missing bucket name", ErrBadEvent)
+	}
+	if r.Object.URLDecodedKey == "" {
+		return s3Object{}, fmt.Errorf("%w: missing object key", ErrBadEvent)
+	}
+
+	return s3Object{Bucket: r.Bucket.Name, Key: r.Object.URLDecodedKey, ETag: r.Object.ETag}, nil
+}
+
+func (o s3Object) String() string {
+	return fmt.Sprintf("s3://%s/%s (etag %s)", o.Bucket, o.Key, o.ETag)
+}
+
+// idempotencyKey names this version of the object, `<key>@<etag>`, for results that must be stored once
+// per upload: a retry of the same bytes lands on the same key, while a re-upload with new content gets
+// its own. Events without an ETag fall back to the key alone.
+func (o s3Object) idempotencyKey() string {
+	if o.ETag == "" {
+		return o.Key
+	}
+	return o.Key + "@" + o.ETag
+}
+
+// fields returns the object as structured log fields.
+func (o s3Object) fields() map[string]interface{} {
+	return map[string]interface{}{"bucket": o.Bucket, "key": o.Key, "etag": o.ETag}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestSourceObject(t *testing.T) {
+	// S3 sends the key URL encoded; the decoded one is what names the object
+	ev := s3Event("uploads", "csv/july 2021.csv", "abc123", 10)
+	ev.Records[0].S3.Object.Key = "csv/july+2021.csv"
+
+	obj, err := sourceObject(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != (s3Object{Bucket: "uploads", Key: "csv/july 2021.csv", ETag: "abc123"}) {
+		t.Errorf("sourceObject = %+v, want the decoded key", obj)
+	}
+	if got := obj.String(); got != "s3://uploads/csv/july 2021.csv (etag abc123)" {
+		t.Errorf("String = %q", got)
+	}
+}
+
+func TestSourceObjectMalformed(t *testing.T) {
+	two := s3Event("uploads", "a.csv", "e", 1)
+	two.Records = append(two.Records, two.Records[0])
+	noBucket := s3Event("", "a.csv", "e", 1)
+	noKey := s3Event("uploads", "", "e", 1)
+
+	for name, tc := range map[string]struct {
+		ev   events.S3Event
+		want string
+	}{
+		"no records":     {events.S3Event{}, "no records"},
+		"two records":    {two, "expected 1 record, got 2"},
+		"missing bucket": {noBucket, "missing bucket name"},
+		"missing key":    {noKey, "missing object key"},
+	} {
+		_, err := sourceObject(tc.ev)
+		if !errors.Is(err, ErrBadEvent) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: sourceObject error = %v, want ErrBadEvent with %q", name, err, tc.want)
+		}
+
+		// the handler fails the same way rather than panicking
+		withConfig(t, nil)
+		if err := HandleRequest(context.Background(), tc.ev); !errors.Is(err, ErrBadEvent) {
+			t.Errorf("%s: HandleRequest error = %v, want ErrBadEvent", name, err)
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	if got := (s3Object{Key: "a.csv", ETag: "e1"}).idempotencyKey(); got != "a.csv@e1" {
+		t.Errorf("idempotencyKey = %q", got)
+	}
+	if got := (s3Object{Key: "a.csv"}).idempotencyKey(); got != "a.csv" {
+		t.Errorf("idempotencyKey without an ETag = %q", got)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// defaultMaxRetries and defaultRetryBaseMS are used when MAX_RETRIES and RETRY_BASE_MS aren't set.
+const (
+	defaultMaxRetries  = 1
+	defaultRetryBaseMS = 200
+)
+
+// defaultSMTPTimeout bounds all SMTP traffic in an invocation when SMTP_TIMEOUT isn't set.
+const defaultSMTPTimeout = 30 * time.Second
+
+// Mailer delivers a fully formed message to the envelope recipients `to`. It returns the server's
+// final reply to the message, e.g. `250 Ok 0100017c...`, which is empty for sinks without a server.
+type Mailer interface {
+	Send(to []string, msg []byte) (string, error)
+}
+
+// newMailer builds the Mailer used by sendEmail. MAIL_SINK=file or DRY_RUN writes messages to disk
+// instead of dialing SMTP. It is a variable so the SMTP server can be swapped for an in-memory Mailer
+// when running the handler without a mail server.
+var newMailer = func(ctx context.Context, ea EmailAuth) Mailer {
+	if fileSink() {
+		return &fileMailer{path: envDefault("MAIL_FILE_PATH", defaultMailFile)}
+	}
+	return newSMTPMailer(ctx, ea, envDuration("SMTP_TIMEOUT", defaultSMTPTimeout))
+}
+
+// fileSink reports whether emails are only written to MAIL_FILE_PATH, for previews and dry runs.
+func fileSink() bool {
+	return features().DryRun || getenv("MAIL_SINK") == "file"
+}
+
+// smtpMailer sends messages over a single SMTP connection that is kept open for the whole invocation,
+// so batches of emails don't pay for a new dial, TLS handshake and auth on every message.
+type smtpMailer struct {
+	host     string
+	addr     string
+	from     string
+	auth     smtp.Auth
+	deadline time.Time
+
+	client *smtp.Client
+}
+
+// smtpPort reads SMTP_PORT, defaulting to the submission port. Pointing it somewhere else is mostly
+// useful for running the handler against a local capture server.
+func smtpPort() string {
+	if p := getenv("SMTP_PORT"); p != "" {
+		return p
+	}
+	return "587"
+}
+
+// newSMTPMailer prepares a mailer for the server in `ea`. No connection is made until the first send.
+// Every connection it opens shares a deadline of `timeout` from now, or the context deadline if that is sooner.
+func newSMTPMailer(ctx context.Context, ea EmailAuth, timeout time.Duration) *smtpMailer {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	return &smtpMailer{
+		host:     ea.Host,
+		addr:     net.JoinHostPort(ea.Host, smtpPort()),
+		from:     ea.Username,
+		auth:     smtp.PlainAuth("", ea.Username, ea.Password, ea.Host),
+		deadline: deadline,
+	}
+}
+
+// connect dials the server and authenticates, mirroring what smtp.SendMail does for a single message.
+func (m *smtpMailer) connect() error {
+	conn, err := net.DialTimeout("tcp", m.addr, time.Until(m.deadline))
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(m.deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			c.Close()
+			return err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(m.auth); err != nil {
+				c.Close()
+				return err
+			}
+		}
+	}
+
+	m.client = c
+	return nil
+}
+
+// Send delivers `msg` to `to`. Transient failures drop the pooled connection and are retried on a
+// fresh one up to MAX_RETRIES times, backing off exponentially from RETRY_BASE_MS. The last error is
+// returned once retries run out, so the invocation fails and Lambda's own retries and DLQ take over.
+func (m *smtpMailer) Send(to []string, msg []byte) (string, error) {
+	retries := envIntMin("MAX_RETRIES", defaultMaxRetries, 0)
+	delay := time.Duration(envIntMin("RETRY_BASE_MS", defaultRetryBaseMS, 0)) * time.Millisecond
+
+	var (
+		reply string
+		err   error
+	)
+	attempts := 0
+	for {
+		attempts++
+		if reply, err = m.send(to, msg); err == nil || !transientSMTP(err) || attempts > retries {
+			break
+		}
+
+		m.drop()
+		// waiting past the deadline only turns a retry into a timeout
+		if time.Now().Add(delay).After(m.deadline) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	if attempts > 1 || err != nil {
+		logJSON("info", "smtp send retried", map[string]interface{}{"attempts": attempts, "ok": err == nil})
+	}
+	if err != nil {
+		m.drop()
+		return "", fmt.Errorf("sending email after %d attempts: %w", attempts, err)
+	}
+	return reply, nil
+}
+
+// dataError marks a failure once the message body has started going to the server. The server may
+// have queued it anyway, for instance when the connection drops just before the final reply, so
+// retrying would risk sending it twice.
+type dataError struct {
+	err error
+}
+
+func (e dataError) Error() string { return e.err.Error() }
+
+func (e dataError) Unwrap() error { return e.err }
+
+// transientSMTP reports whether `err` is worth retrying. Permanent 5xx replies like an unknown
+// mailbox fail the same way every time; 4xx replies and connection errors usually don't. Nothing
+// after the body is sent is retried, so only dial, auth and envelope failures ever are.
+func transientSMTP(err error) bool {
+	if errors.As(err, &dataError{}) {
+		return false
+	}
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code < 500
+	}
+	return true
+}
+
+// send runs one transaction on the pooled connection. DATA is driven by hand rather than through
+// smtp.Client.Data, whose writer throws away the reply that carries the server's queue id.
+func (m *smtpMailer) send(to []string, msg []byte) (string, error) {
+	if m.client == nil {
+		if err := m.connect(); err != nil {
+			return "", err
+		}
+	}
+	c := m.client
+
+	if err := c.Mail(m.from); err != nil {
+		return "", err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := c.Text.Cmd("DATA")
+	if err != nil {
+		return "", err
+	}
+	c.Text.StartResponse(id)
+	_, _, err = c.Text.ReadResponse(354)
+	c.Text.EndResponse(id)
+	if err != nil {
+		return "", err
+	}
+	w := c.Text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return "", dataError{err}
+	}
+	if err := w.Close(); err != nil {
+		return "", dataError{err}
+	}
+	code, text, err := c.Text.ReadResponse(250)
+	if err != nil {
+		return "", dataError{err}
+	}
+
+	// the message is queued from here on, so failing to leave the connection ready for the next one
+	// must not fail the send and have it retried as a duplicate; the next send just dials again
+	if err := c.Reset(); err != nil {
+		logJSON("warn", "smtp reset failed", map[string]interface{}{"error": err.Error()})
+		m.drop()
+	}
+	return fmt.Sprintf("%d %s", code, text), nil
+}
+
+// providerMessageID picks the provider's id for a message out of the final SMTP reply. SES answers
+// `250 Ok <id>`, and the id is what its dashboards and bounce notifications use. Other servers' replies
+// are returned whole, since most still put their queue id in there somewhere.
+func providerMessageID(reply string) string {
+	if rest := strings.TrimPrefix(reply, "250 Ok "); rest != reply {
+		return strings.TrimSpace(rest)
+	}
+	return reply
+}
+
+// drop throws away the current connection without waiting on the server.
+func (m *smtpMailer) drop() {
+	if m.client != nil {
+		m.client.Close()
+		m.client = nil
+	}
+}
+
+// Close ends the SMTP session politely. It is safe to call when nothing was sent.
+func (m *smtpMailer) Close() error {
+	if m.client == nil {
+		return nil
+	}
+	err := m.client.Quit()
+	m.client = nil
+	return err
+}
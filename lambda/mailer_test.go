@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RecordingMailer is a Mailer that keeps every message instead of sending it, for assertions on
+// exactly what would have gone out. It is safe for concurrent use.
+type RecordingMailer struct {
+	// Err, when set, fails every Send without recording anything.
+	Err error
+
+	mu   sync.Mutex
+	sent []RecordedMessage
+}
+
+// RecordedMessage is one message handed to a RecordingMailer.
+type RecordedMessage struct {
+	To  []string
+	Msg []byte
+}
+
+// Send records `msg` and answers like SES would, with a provider id of `recorded-<n>`.
+func (m *RecordingMailer) Send(to []string, msg []byte) (string, error) {
+	if m.Err != nil {
+		return "", m.Err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, RecordedMessage{To: append([]string(nil), to...), Msg: append([]byte(nil), msg...)})
+	return fmt.Sprintf("250 Ok recorded-%d", len(m.sent)), nil
+}
+
+// Messages returns everything sent so far, in order.
+func (m *RecordingMailer) Messages() []RecordedMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RecordedMessage(nil), m.sent...)
+}
+
+// Parse reads the recorded message back as a mail message with its decoded body.
+func (r RecordedMessage) Parse(t *testing.T) (*mail.Message, string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(r.Msg)))
+	if err != nil {
+		t.Fatalf("reading recorded message: %v", err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatalf("reading recorded body: %v", err)
+	}
+	return msg, string(body)
+}
+
+// useRecordingMailer makes every emailSender in the test send through the returned RecordingMailer,
+// with `ea` standing in for the credentials in EMAIL_SECRET.
+func useRecordingMailer(t *testing.T, ea EmailAuth) *RecordingMailer {
+	t.Helper()
+
+	m := &RecordingMailer{}
+	mailer, auth := newMailer, loadEmailAuth
+	newMailer = func(context.Context, EmailAuth) Mailer { return m }
+	loadEmailAuth = func() (EmailAuth, error) { return ea, nil }
+	t.Cleanup(func() { newMailer, loadEmailAuth = mailer, auth })
+
+	return m
+}
+
+func TestSendEmailRecordingMailer(t *testing.T) {
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+
+	sm, err := getSummaries(sampleTransactions, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err := sendEmail(context.Background(), sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := m.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+	if got := strings.Join(msgs[0].To, ","); got != "statements@example.com" {
+		t.Errorf("envelope recipients = %s, want statements@example.com", got)
+	}
+	if sent.ProviderID != "recorded-1" {
+		t.Errorf("ProviderID = %q, want the id from the mailer's reply", sent.ProviderID)
+	}
+
+	hdr, body := msgs[0].Parse(t)
+	if got := hdr.Header.Get("Message-Id"); got != sent.MessageID {
+		t.Errorf("Message-ID = %q, want %q", got, sent.MessageID)
+	}
+	for _, want := range []string{"Total credits: $70.50", "Total debits: -$30.76", "Net Change: $39.74"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestSendEmailMailerError(t *testing.T) {
+	withConfig(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	m.Err = fmt.Errorf("relay unavailable")
+
+	if _, err := sendEmail(context.Background(), Summaries{}); err == nil || !strings.Contains(err.Error(), "relay unavailable") {
+		t.Errorf("sendEmail error = %v, want the mailer's error", err)
+	}
+	if n := len(m.Messages()); n != 0 {
+		t.Errorf("recorded %d messages after a failed send, want 0", n)
+	}
+}
+
+// fakeSMTPServer is a minimal SMTP server on localhost that advertises neither STARTTLS nor AUTH and
+// counts the messages it receives. `hangup` picks where it drops the connection, to check what the
+// mailer retries.
+type fakeSMTPServer struct {
+	// hangup is `after-250` to close right after accepting a message, `before-reply` to close once the
+	// body is in without answering, or `mail-421` to refuse MAIL FROM on the first connection only.
+	hangup string
+
+	mu          sync.Mutex
+	connections int
+	deliveries  int
+}
+
+// start serves on a free port, which it returns, until the test ends.
+func (s *fakeSMTPServer) start(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.connections++
+			n := s.connections
+			s.mu.Unlock()
+			go s.serve(conn, n)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn, n int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		if i := strings.IndexByte(cmd, ' '); i >= 0 {
+			cmd = cmd[:i]
+		}
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 fake")
+		case "MAIL":
+			if s.hangup == "mail-421" && n == 1 {
+				reply("421 try again later")
+				return
+			}
+			reply("250 Ok")
+		case "RCPT", "RSET", "NOOP":
+			reply("250 Ok")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.deliveries++
+			id := s.deliveries
+			s.mu.Unlock()
+			if s.hangup == "before-reply" {
+				return
+			}
+			reply(fmt.Sprintf("250 Ok queued-%d", id))
+			if s.hangup == "after-250" {
+				return
+			}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) counts() (connections, deliveries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, s.deliveries
+}
+
+// fakeSMTPMailer returns an smtpMailer for a fakeSMTPServer with `hangup`, retrying twice without
+// backing off.
+func fakeSMTPMailer(t *testing.T, hangup string) (*smtpMailer, *fakeSMTPServer) {
+	t.Helper()
+
+	srv := &fakeSMTPServer{hangup: hangup}
+	t.Setenv("SMTP_PORT", srv.start(t))
+	t.Setenv("MAX_RETRIES", "2")
+	t.Setenv("RETRY_BASE_MS", "0")
+	withConfig(t, nil)
+
+	m := newSMTPMailer(context.Background(), EmailAuth{Host: "127.0.0.1", Username: "from@example.com"}, 5*time.Second)
+	t.Cleanup(func() { m.Close() })
+	return m, srv
+}
+
+var testMessage = []byte("Subject: test\r\n\r\nhello\r\n")
+
+func TestSMTPMailerDropAfterAccept(t *testing.T) {
+	m, srv := fakeSMTPMailer(t, "after-250")
+
+	reply, err := m.Send([]string{"to@example.com"}, testMessage)
+	if err != nil {
+		t.Fatalf("Send = %v, want success once the server accepted the message", err)
+	}
+	if reply != "250 Ok queued-1" {
+		t.Errorf("reply = %q, want the 250 reply", reply)
+	}
+	if _, deliveries := srv.counts(); deliveries != 1 {
+		t.Errorf("server received %d messages, want exactly 1", deliveries)
+	}
+
+	// the dead connection is dropped, so the next message dials again
+	if _, err := m.Send([]string{"to@example.com"}, testMessage); err != nil {
+		t.Fatal(err)
+	}
+	if connections, deliveries := srv.counts(); connections != 2 || deliveries != 2 {
+		t.Errorf("connections, deliveries = %d, %d, want 2, 2", connections, deliveries)
+	}
+}
+
+func TestSMTPMailerNoRetryAfterData(t *testing.T) {
+	m, srv := fakeSMTPMailer(t, "before-reply")
+
+	if _, err := m.Send([]string{"to@example.com"}, testMessage); err == nil {
+		t.Fatal("Send succeeded without a reply to the message")
+	}
+	if connections, deliveries := srv.counts(); connections != 1 || deliveries != 1 {
+		t.Errorf("connections, deliveries = %d, %d, want 1, 1 with no retry", connections, deliveries)
+	}
+}
+
+func TestSMTPMailerRetryBeforeData(t *testing.T) {
+	m, srv := fakeSMTPMailer(t, "mail-421")
+
+	if _, err := m.Send([]string{"to@example.com"}, testMessage); err != nil {
+		t.Fatalf("Send = %v, want a retry on a fresh connection", err)
+	}
+	if connections, deliveries := srv.counts(); connections != 2 || deliveries != 1 {
+		t.Errorf("connections, deliveries = %d, %d, want 2, 1", connections, deliveries)
+	}
+}
+
+// countEmailAuth replaces loadEmailAuth for the test with one that counts its calls instead of asking
+// Secrets Manager.
+func countEmailAuth(t *testing.T) *int {
+	t.Helper()
+
+	calls := 0
+	prev := loadEmailAuth
+	loadEmailAuth = func() (EmailAuth, error) {
+		calls++
+		return EmailAuth{Username: "statements@example.com"}, nil
+	}
+	t.Cleanup(func() { loadEmailAuth = prev })
+	return &calls
+}
+
+func TestSendEmailFileSinkSkipsSecret(t *testing.T) {
+	for name, env := range map[string][2]string{
+		"DRY_RUN":        {"DRY_RUN", "true"},
+		"MAIL_SINK=file": {"MAIL_SINK", "file"},
+	} {
+		path := filepath.Join(t.TempDir(), "email.eml")
+		t.Setenv(env[0], env[1])
+		t.Setenv("MAIL_FILE_PATH", path)
+		withConfig(t, nil)
+		calls := countEmailAuth(t)
+
+		if _, err := sendEmail(context.Background(), Summaries{}); err != nil {
+			t.Fatal(err)
+		}
+		if *calls != 0 {
+			t.Errorf("%s: loaded the email secret %d times, want never", name, *calls)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(strings.NewReader(string(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Header.Get("To"); got != "<"+defaultPreviewAddress+">" {
+			t.Errorf("%s: To = %q, want the preview address", name, got)
+		}
+		t.Setenv(env[0], "")
+	}
+}
+
+func TestNewEmailSenderLoadsSecret(t *testing.T) {
+	withConfig(t, nil)
+	calls := countEmailAuth(t)
+
+	es, err := newEmailSender(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	if *calls != 1 || es.ea.Username != "statements@example.com" {
+		t.Errorf("loaded the email secret %d times for %q, want once", *calls, es.ea.Username)
+	}
+}
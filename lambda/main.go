@@ -1,267 +1,1184 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/csv"
-	"encoding/json"
-	"html/template"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"math"
-	"net/smtp"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var months map[int]string
-
-type EmailAuth struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Host     string `json:"host"`
-}
-
+// Summaries holds the running aggregates for a file. Totals are accumulated with compensated summation
+// and are accurate to the cent up to maxSafeTotal, roughly 70 trillion in either direction.
 type Summaries struct {
 	CreditCount         int
 	CreditTotal         float64
 	DebitCount          int
 	DebitTotal          float64
 	MonthlyTransactions map[string]int
+	// DailyTransactions only counts rows with a full `M/D/YYYY` date, since a bare `M/D` could be from
+	// any year the file spans.
+	DailyTransactions map[int]DayActivity
+	// Yearly splits the credit and debit aggregates by year, for files whose dates include one.
+	Yearly map[int]YearTotals
+	// FirstDate and LastDate are the earliest and latest dates in the file as written there, empty
+	// when no counted row had a readable date.
+	FirstDate string `json:",omitempty"`
+	LastDate  string `json:",omitempty"`
+	// MonthlyAverage is the number of transactions per distinct month in the file.
+	MonthlyAverage float64
+	// NetPercentOfCredits is the net change as a percentage of total credits, nil without any credits.
+	NetPercentOfCredits *float64 `json:",omitempty"`
+	// DailyNet is the net amount for each calendar day, keyed by dayKeyLayout.
+	DailyNet map[string]float64
+	// LargestSwing is the biggest day over day change in DailyNet, nil with fewer than two days.
+	LargestSwing *DaySwing
+	// Weekdays and Weekend split the transactions by day of the week. Only rows with a full
+	// `M/D/YYYY` date are counted, since a `M/D` date has no weekday.
+	Weekdays WeekActivity
+	Weekend  WeekActivity
+	// Sections has the totals of each statement in a concatenated file, in file order. It is only
+	// set when REPEATED_HEADERS is `sections`.
+	Sections []SectionTotals `json:",omitempty"`
+	// RowErrors lists the rows left out in lenient mode. It is always empty in strict mode.
+	RowErrors []RowError `json:",omitempty"`
+	// LargeTransactions are the transactions whose absolute amount is over LARGE_TXN_THRESHOLD, in file order.
+	LargeTransactions []NotableTransaction `json:",omitempty"`
+	// Transactions lists the counted transactions in file order when INCLUDE_TRANSACTIONS is set, up to
+	// TRANSACTIONS_MAX_ROWS of them. TransactionsOmitted is how many more there were.
+	Transactions        []NotableTransaction `json:",omitempty"`
+	TransactionsOmitted int                  `json:",omitempty"`
+	// Recurring are the description and amount pairs seen in at least RECURRING_MIN_MONTHS distinct
+	// months, which are likely subscriptions. It is empty for files without descriptions.
+	Recurring []RecurringCharge `json:",omitempty"`
+	// AmountPercentiles are streaming estimates over the absolute amounts of the counted transactions,
+	// nil when there weren't any.
+	AmountPercentiles *AmountPercentiles `json:",omitempty"`
+	// Excluded is how many rows were left out because their id is in EXCLUDE_IDS.
+	Excluded int `json:",omitempty"`
+	// FutureDated is how many rows dated after the processing date were left out, always 0 unless
+	// REJECT_FUTURE_DATES is `exclude`.
+	FutureDated int `json:",omitempty"`
+	// Deduped is how many duplicate rows were dropped, always 0 unless DEDUP is set.
+	Deduped int `json:",omitempty"`
+	// Refunds and RefundTotal are the credits that match an earlier debit's description and amount
+	// within REFUND_WINDOW_DAYS. They are still counted in the credit totals too.
+	Refunds     int     `json:",omitempty"`
+	RefundTotal float64 `json:",omitempty"`
+	// Transfers and TransferTotal are the credits that look like money moved in from the customer's
+	// own accounts under the TRANSFER_ rules. They are still counted in the credit totals too, so income
+	// is CreditTotal less TransferTotal.
+	Transfers     int     `json:",omitempty"`
+	TransferTotal float64 `json:",omitempty"`
+	// Categories splits the credits and debits by the Category column when CATEGORY_TOTALS is enabled.
+	// Rows without a category are under Uncategorized.
+	Categories map[string]CategoryTotals `json:",omitempty"`
+	// LargestCredit and LargestDebit are nil when the file has no credits or debits respectively.
+	LargestCredit *NotableTransaction
+	LargestDebit  *NotableTransaction
 }
 
-type EmailSummary struct {
-	Total               float64
-	MonthlyTransactions map[string]int
-	CreditAverage       float64
-	DebitAverage        float64
+// SectionTotals are the aggregates for one statement of a concatenated file. Section is numbered from 1.
+type SectionTotals struct {
+	Section     int
+	Count       int
+	CreditTotal float64
+	DebitTotal  float64
+}
+
+// RowError describes a single row that couldn't be summarized. Row is 1 based and doesn't count the header.
+type RowError struct {
+	Row    int    `json:"row"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// NotableTransaction is a single transaction worth calling out in the email.
+type NotableTransaction struct {
+	ID          string
+	Date        string
+	Amount      float64
+	Description string
+}
+
+// YearTotals are the credit and debit aggregates for a single calendar year.
+type YearTotals struct {
+	CreditCount int
+	CreditTotal float64
+	DebitCount  int
+	DebitTotal  float64
+}
+
+// YearAverage is the average credit and debit for one year, as shown in the email.
+type YearAverage struct {
+	Year          int
+	CreditAverage float64
+	DebitAverage  float64
+}
+
+// WeekActivity is the number of transactions and their net amount on one part of the week.
+type WeekActivity struct {
+	Count int
+	Net   float64
+}
+
+// DayActivity is the number of transactions and their net amount on a given day of the month.
+type DayActivity struct {
+	Day   int
+	Count int
+	Net   float64
 }
 
 type TransactionCSV struct {
 	ID          string
 	Date        string
 	Transaction string
+	// Status is empty when the file has no Status column, which is treated as settled.
+	Status string
+	// Description comes from an optional Description, Memo or Reference column.
+	Description string
+	// Account is empty when the file has no Account column.
+	Account string
+	// Category is empty when the file has no Category column.
+	Category string
+	// Section numbers the statements in a concatenated file from 0, when REPEATED_HEADERS is `sections`.
+	Section int
+	// Row is where the transaction is in its file, from 1 after the header like RowError. Rows that
+	// were skipped or left out still count, so it can be ahead of the transaction's index.
+	Row int
 }
 
-func HandleRequest(ctx context.Context, ev events.S3Event) error {
-	file, err := getFile(ev)
+// rowNumber is the row RowError reports for `t`, the `i`th transaction summarized. Transactions that
+// didn't come from a file are numbered by their index.
+func (t TransactionCSV) rowNumber(i int) int {
+	if t.Row > 0 {
+		return t.Row
+	}
+	return i + 1
+}
+
+func HandleRequest(ctx context.Context, ev events.S3Event) (err error) {
+	defer flush()
+
+	// one searchable line of what the run produced, whatever else it writes, including events rejected
+	// before there is an object to name
+	ctx, rs := withRunSummary(ctx)
+	var obj s3Object
+	defer func() { rs.log(obj, err) }()
+
+	obj, err = sourceObject(ev)
 	if err != nil {
+		logJSON("error", "rejected event", map[string]interface{}{"error": err.Error()})
 		return err
 	}
+	logJSON("info", "processing object", obj.fields())
+
+	ctx, sp := tracer.Start(ctx, "HandleRequest", trace.WithAttributes(
+		attribute.String("s3.bucket", obj.Bucket),
+		attribute.String("s3.key", obj.Key),
+		attribute.String("s3.etag", obj.ETag),
+	))
+	err = handle(ctx, ev, obj)
+	endSpan(sp, err)
+
+	// tags are for lifecycle rules and audits; they never change the outcome of the run
+	if features().TagProcessed {
+		if terr := tagObject(obj, err); terr != nil {
+			f := obj.fields()
+			f["error"] = terr.Error()
+			logJSON("warn", "tagging object failed", f)
+		}
+	}
 
-	ts, err := readCSV(file)
+	if err != nil {
+		f := obj.fields()
+		f["error"] = err.Error()
+		logJSON("error", "processing failed", f)
+
+		// a file that can't be parsed fails the same way every time, so get it out of the way of retries
+		var perr parseError
+		if features().Quarantine && errors.As(err, &perr) {
+			key, qerr := quarantineObject(obj, err)
+			if qerr == nil {
+				rs.quarantine()
+				f["quarantine_key"] = key
+				logJSON("warn", "file quarantined", f)
+				return nil
+			}
+			f["quarantine_error"] = qerr.Error()
+			logJSON("warn", "quarantining file failed", f)
+		}
+		return fmt.Errorf("%s: %w", obj, err)
+	}
+
+	return nil
+}
+
+// handle runs the whole pipeline for `obj`, the object in `ev`.
+func handle(ctx context.Context, ev events.S3Event, obj s3Object) (err error) {
+	sess, err := session.NewSession(&aws.Config{})
 	if err != nil {
 		return err
 	}
 
-	sums, err := getSummaries(ts)
+	if err := loadConfig(s3.New(sess)); err != nil {
+		return err
+	}
+
+	if err := checkSource(obj.Bucket, obj.Key, allowedSources()); err != nil {
+		return err
+	}
+	if err := checkEvent(ev.Records[0], time.Now()); err != nil {
+		return err
+	}
+
+	// a summary left over from a failed send can go straight out again
+	if features().DeferOnSecretError {
+		sums, ok, err := loadPending(s3.New(sess), obj)
+		if err != nil {
+			return err
+		}
+		if ok {
+			logJSON("info", "sending pending summary", obj.fields())
+			runSummaryFrom(ctx).read(sums.CreditCount + sums.DebitCount)
+			return deliver(ctx, sess, obj, sums, nil)
+		}
+	}
+
+	var ts []TransactionCSV
+	if isManifest(obj.Key) {
+		if ts, err = readManifestFiles(ctx, sess, obj); err != nil {
+			return err
+		}
+	} else {
+		// the event carries the object's content length, which saves a HeadObject just for progress logs
+		if ts, err = downloadCSV(ctx, sess, obj, ev.Records[0].S3.Object.Size); err != nil {
+			return err
+		}
+	}
+
+	// an append-only ledger is uploaded whole every time, but only the new rows belong in the summary
+	if table := getenv("HIGH_WATER_TABLE"); table != "" {
+		db := newHighWaterDB(sess)
+		all := ts
+		var hw highWaterMark
+		if ts, hw, err = skipSummarized(db, table, obj, ts); err != nil {
+			return err
+		}
+		// the mark only moves once the new rows are out, so a failed run sees them again on retry
+		defer func() {
+			if err == nil {
+				advanceHighWater(db, table, obj, nextMark(obj, all, hw, time.Now()))
+			}
+		}()
+	}
+
+	runSummaryFrom(ctx).read(len(ts))
+
+	exclude, err := excludedIDs(s3.New(sess))
+	if err != nil {
+		return err
+	}
+	months, err := monthNames()
+	if err != nil {
+		return err
+	}
+	number, err := amountFormat(obj.Key)
+	if err != nil {
+		return err
+	}
+	opts := summaryOptions{
+		sections: getenv("REPEATED_HEADERS") == "sections",
+		exclude:  exclude,
+		asOf:     ev.Records[0].EventTime,
+		months:   months,
+		number:   number,
+	}
+
+	// in digest mode the email goes out later, from the scheduled handler
+	if w, ok := digestWindow(); ok {
+		return storeDigest(s3manager.NewUploader(sess), obj, opts, ts, w)
+	}
+
+	// files with an Account column get one email per account when there is somewhere to look up who
+	// each account belongs to
+	if table := getenv("RECIPIENTS_TABLE"); table != "" {
+		ids, groups, err := groupByAccount(ts)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			return handleAccounts(ctx, sess, s3.New(sess), obj, opts, newDynamoRecipients(dynamodb.New(sess), table), ids, groups)
+		}
+	}
+
+	cp := newCheckpointer(s3.New(sess), ev)
+	opts.checkpoint = cp
+
+	_, sp := tracer.Start(ctx, "summarize")
+	sums, err := getSummaries(ts, opts)
+	endSpan(sp, err)
 	if err != nil {
 		return err
 	}
+	if sums.Excluded > 0 {
+		f := obj.fields()
+		f["excluded"] = sums.Excluded
+		logJSON("info", "excluded rows by id", f)
+	}
+	if sums.Deduped > 0 {
+		f := obj.fields()
+		f["deduped"] = sums.Deduped
+		logJSON("info", "dropped duplicate rows", f)
+	}
+	if sums.FutureDated > 0 {
+		f := obj.fields()
+		f["future_dated"] = sums.FutureDated
+		logJSON("warn", "dropped future dated rows", f)
+	}
+
+	if features().WriteSummary {
+		var signKey []byte
+		if features().SignSummary {
+			if signKey, err = signingKey(secretsmanager.New(sess)); err != nil {
+				return err
+			}
+		}
+		if err := writeSummary(s3manager.NewUploader(sess), obj, sums, signKey); err != nil {
+			return err
+		}
+	}
+
+	return deliver(ctx, sess, obj, sums, cp)
+}
+
+// deliver emails `sums` for `obj` and cleans up after it. A summary that can't be sent because the
+// email secret is unreachable is kept in `pending/` when DEFER_ON_SECRET_ERROR is set, so the retry
+// sends it without reading the file again. The summary event goes out before the email, so a fatal
+// publish failure retries the invocation without having already emailed anyone.
+func deliver(ctx context.Context, sess *session.Session, obj s3Object, sums Summaries, cp *checkpointer) error {
+	deferrable := features().DeferOnSecretError
+
+	if err := maybePublish(sess, obj, "", sums); err != nil {
+		return err
+	}
 
-	if err = sendEmail(sums); err != nil {
+	sctx, sp := tracer.Start(ctx, "send")
+	sent, err := sendEmail(sctx, sums)
+	endSpan(sp, err)
+	if err != nil {
+		if deferrable && errors.Is(err, ErrSecretUnavailable) {
+			if perr := savePending(s3manager.NewUploader(sess), obj, sums); perr != nil {
+				f := obj.fields()
+				f["error"] = perr.Error()
+				logJSON("error", "saving pending summary failed", f)
+			} else {
+				f := obj.fields()
+				f["error"] = err.Error()
+				logJSON("warn", "summary computed but delivery deferred", f)
+			}
+		}
 		return err
 	}
+	if deferrable {
+		if err := clearPending(s3.New(sess), obj); err != nil {
+			log.Printf("clearing pending summary: %v", err)
+		}
+	}
+	// the email is already out, so a failed preview upload must not fail the invocation and cause a resend
+	if features().UploadRendered {
+		if err := uploadRendered(s3.New(sess), obj, sent); err != nil {
+			f := obj.fields()
+			f["error"] = err.Error()
+			logJSON("warn", "uploading rendered email failed", f)
+		}
+	}
+
+	// the file is done, so a later upload with the same key must start over
+	if cp != nil {
+		if err := cp.Clear(); err != nil {
+			log.Printf("clearing checkpoint: %v", err)
+		}
+	}
 
 	return nil
 }
 
-// getFile will retrieve the file referenced in the S3Event and return a pointer to a local copy of the file.
-func getFile(ev events.S3Event) (*os.File, error) {
-	sess, err := session.NewSession(&aws.Config{})
+// downloadCSV downloads and parses `obj`, with a span for each step.
+func downloadCSV(ctx context.Context, sess *session.Session, obj s3Object, size int64) ([]TransactionCSV, error) {
+	_, sp := tracer.Start(ctx, "download", trace.WithAttributes(attribute.String("s3.key", obj.Key)))
+	file, err := getFile(obj, size, newDownloader(sess))
+	endSpan(sp, err)
 	if err != nil {
 		return nil, err
 	}
+	defer removeFile(file)
 
-	// we're making some assumptions here, but for this code challenge purpose we should be fine.
-	// The s3 trigger filter ensures we're getting a file with path `csv/somefile.csv`.
-	// And we know there's only 1 file in `ev.Records` because it's triggered by the addition of a single file.
-	name := strings.Split(ev.Records[0].S3.Object.Key, "/")[1]
-	// We should be creating a unique name of some kind instead of just using what's in the key
-	// because os.Create will truncate if the file at that path already exists
-	file, err := os.Create(filepath.Join("/tmp", name))
+	_, sp = tracer.Start(ctx, "parse", trace.WithAttributes(attribute.String("s3.key", obj.Key)))
+	var ts []TransactionCSV
+	if isParquet(obj.Key, file) {
+		ts, err = readParquet(file)
+	} else {
+		ts, err = readCSV(file)
+	}
+	endSpan(sp, err)
 	if err != nil {
-		return nil, err
+		return nil, parseError{err}
 	}
+	return ts, nil
+}
 
-	downloader := s3manager.NewDownloader(sess)
+// getFile will retrieve `obj` using `downloader` and return a pointer to a local copy of the file.
+// `size` is the object's content length when known, and only used for progress logs. The copy is a
+// new temporary file, which the caller gets rid of with removeFile; on error nothing is left behind.
+func getFile(obj s3Object, size int64, downloader s3manageriface.DownloaderAPI) (*os.File, error) {
+	// a warm container handles many invocations, so two keys with the same base name must not share a file
+	file, err := os.CreateTemp("", "*-"+filepath.Base(obj.Key))
+	if err != nil {
+		return nil, err
+	}
 
-	bucket := ev.Records[0].S3.Bucket.Name
-	key := ev.Records[0].S3.Object.URLDecodedKey
-	_, err = downloader.Download(file, &s3.GetObjectInput{
+	bucket, key := obj.Bucket, obj.Key
+	pw := newProgressWriter(file, obj, size)
+	n, err := downloader.Download(pw, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
+		removeFile(file)
 		return nil, err
 	}
+	// an interrupted upload can leave a zero byte object that still fires the trigger
+	if n == 0 {
+		removeFile(file)
+		logJSON("warn", "downloaded file is empty", map[string]interface{}{"bucket": bucket, "key": key})
+		return nil, fmt.Errorf("%w: s3://%s/%s", ErrEmptyFile, bucket, key)
+	}
 
 	return file, nil
 }
 
-func getMonth(s string) (string, error) {
-	split := strings.Split(s, "/")
-	intStr := split[0]
-	i, err := strconv.Atoi(intStr)
+// removeFile closes and deletes a downloaded file, since /tmp outlives the invocation in a warm container.
+func removeFile(file *os.File) {
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		log.Printf("removing %s: %v", file.Name(), err)
+	}
+}
+
+// dateParts splits a `M/D` or `M/D/YYYY` date into its fields. Exports sometimes pad dates or
+// double up separators, as in ` 6/1 `, `/6/1` or `6//1`, so surrounding whitespace and empty fields
+// are dropped rather than shifting everything after them.
+func dateParts(s string) []string {
+	var parts []string
+	for _, p := range strings.Split(s, "/") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// monthNames returns the month labels for the monthly breakdown, January first. MONTH_NAMES is a comma
+// separated list of all twelve, for files summarized in another language or with house abbreviations,
+// and defaults to the English names. MONTH_FORMAT `short` cuts each one to three letters.
+func monthNames() ([12]string, error) {
+	names := languages[defaultLanguage].Months
+	if v := getenv("MONTH_NAMES"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != len(names) {
+			return names, fmt.Errorf("MONTH_NAMES needs %d names, got %d", len(names), len(parts))
+		}
+		for i, p := range parts {
+			if names[i] = strings.TrimSpace(p); names[i] == "" {
+				return names, fmt.Errorf("MONTH_NAMES has an empty name for month %d", i+1)
+			}
+		}
+	}
+	if getenv("MONTH_FORMAT") == "short" {
+		for i := range names {
+			names[i] = shortMonth(names[i])
+		}
+	}
+	return names, nil
+}
+
+// getMonth returns the monthly bucket of a date, labelled with `names`.
+func getMonth(s string, names [12]string) (string, error) {
+	split := dateParts(s)
+	if len(split) == 0 {
+		return "", fmt.Errorf("no month in %q", s)
+	}
+	i, err := strconv.Atoi(split[0])
 	if err != nil {
 		return "", err
 	}
+	if i < 1 || i > 12 {
+		return "", fmt.Errorf("month %d out of range", i)
+	}
 
-	return months[i], nil
+	name := names[i-1]
+
+	// months from different years must not share a bucket
+	if y, ok := getYear(s); ok {
+		return fmt.Sprintf("%s %d", name, y), nil
+	}
+
+	return name, nil
+}
+
+// shortMonth abbreviates a month name to its first three letters, e.g. `Jan`.
+func shortMonth(name string) string {
+	r := []rune(name)
+	if len(r) <= 3 {
+		return name
+	}
+	return string(r[:3])
+}
+
+// getYear returns the year from a `M/D/YYYY` date. It reports false for `M/D` dates.
+func getYear(s string) (int, bool) {
+	split := dateParts(s)
+	if len(split) < 3 {
+		return 0, false
+	}
+	y, err := strconv.Atoi(split[2])
+	if err != nil || y < 1 {
+		return 0, false
+	}
+
+	return y, true
+}
+
+// getDay returns the day of the month from a `M/D` or `M/D/YYYY` date.
+// It reports false when the day is missing or out of range rather than failing the whole file.
+func getDay(s string) (int, bool) {
+	split := dateParts(s)
+	if len(split) < 2 {
+		return 0, false
+	}
+	d, err := strconv.Atoi(split[1])
+	if err != nil || d < 1 || d > 31 {
+		return 0, false
+	}
+
+	return d, true
+}
+
+// defaultTransactionsMaxRows caps the transactions table when TRANSACTIONS_MAX_ROWS isn't set.
+const defaultTransactionsMaxRows = 50
+
+// transactionsListMax is how many transactions the email lists, from TRANSACTIONS_MAX_ROWS, or 0 when
+// INCLUDE_TRANSACTIONS is off.
+func transactionsListMax() int {
+	if !features().IncludeTransactions {
+		return 0
+	}
+	return envInt("TRANSACTIONS_MAX_ROWS", defaultTransactionsMaxRows)
+}
+
+// summaryOptions changes how getSummaries treats a file. The zero value is the strict S3 behavior.
+type summaryOptions struct {
+	// checkpoint, when set, saves progress every checkpoint.every rows and picks up a previous run's progress.
+	checkpoint *checkpointer
+	// lenient collects bad rows into Summaries.RowErrors instead of failing on the first one.
+	lenient bool
+	// sections keeps per statement totals for concatenated files.
+	sections bool
+	// exclude are transaction ids to leave out, from EXCLUDE_IDS.
+	exclude map[string]bool
+	// asOf is the processing date that REJECT_FUTURE_DATES compares against. Zero means now.
+	asOf time.Time
+	// months label the monthly breakdown, from monthNames. The zero value means the English names.
+	months [12]string
+	// number is the locale amounts are written in, from amountFormat. Nil means plain `1234.50` amounts.
+	number *currencyFormat
+}
+
+// amountSign reads AMOUNT_SIGN_CONVENTION and returns what amounts must be multiplied by so that
+// credits are positive. Partners that record debits as positive use `debit_positive`.
+func amountSign() (float64, error) {
+	switch v := getenv("AMOUNT_SIGN_CONVENTION"); v {
+	case "", "credit_positive":
+		return 1, nil
+	case "debit_positive":
+		return -1, nil
+	default:
+		return 0, fmt.Errorf("unsupported AMOUNT_SIGN_CONVENTION %q", v)
+	}
+}
+
+// plainDecimal matches amounts written as plain decimals, like `-60.50` or `+10`, and nothing exotic
+// like `1e3`, `0x1p4` or `Inf` that ParseFloat would otherwise accept.
+var plainDecimal = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+
+// normalizeAmount rewrites the trailing sign conventions of accounting and mainframe exports into
+// something ParseFloat reads: `60.50-` becomes `-60.50`, and a `DR` or `CR` suffix is removed and
+// reported as the side, -1 for a debit and 1 for a credit. Side is 0 for unmarked amounts.
+func normalizeAmount(s string) (string, float64) {
+	s = strings.TrimSpace(s)
+	u := strings.ToUpper(s)
+	switch {
+	case strings.HasSuffix(u, "DR"):
+		return strings.TrimSpace(s[:len(s)-2]), -1
+	case strings.HasSuffix(u, "CR"):
+		return strings.TrimSpace(s[:len(s)-2]), 1
+	case len(s) > 1 && strings.HasSuffix(s, "-") && !strings.HasPrefix(s, "-") && !strings.HasPrefix(s, "+"):
+		return "-" + strings.TrimSpace(s[:len(s)-1]), 0
+	}
+	return s, 0
+}
+
+// rowFormat is how the rows of a file are written.
+type rowFormat struct {
+	// strict requires amounts to be plain decimals.
+	strict bool
+	// sign is what amounts not marked `DR` or `CR` are multiplied by, from amountSign.
+	sign float64
+	// months label the month buckets.
+	months [12]string
+	// number, when set, is the locale amounts use for their separators, like `1.234,50`.
+	number *currencyFormat
+}
+
+// parseRow reads the amount and month bucket of `t`, with an error naming the transaction when
+// either can't be used. The amount is returned with credits positive: the sign of `rf` is applied,
+// except to amounts marked `DR` or `CR`, which say for themselves which side they are on.
+func parseRow(t TransactionCSV, rf rowFormat) (float64, string, error) {
+	raw, side := normalizeAmount(t.Transaction)
+	if rf.number != nil {
+		raw = rf.number.plainAmount(raw)
+	}
+	if rf.strict && !plainDecimal.MatchString(raw) {
+		return 0, "", fmt.Errorf("transaction %s: amount %q is not a plain decimal", t.ID, t.Transaction)
+	}
+
+	amt, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("transaction %s: invalid amount %q", t.ID, t.Transaction)
+	}
+	// ParseFloat happily accepts "Inf" and "NaN", which would poison every total after it
+	if math.IsInf(amt, 0) || math.IsNaN(amt) {
+		return 0, "", fmt.Errorf("transaction %s: amount %q is not a finite number", t.ID, t.Transaction)
+	}
+	if side != 0 {
+		amt = math.Abs(amt) * side
+	} else {
+		amt *= rf.sign
+	}
+
+	month, err := getMonth(t.Date, rf.months)
+	if err != nil {
+		return 0, "", fmt.Errorf("transaction %s: invalid date %q", t.ID, t.Date)
+	}
+
+	return amt, month, nil
 }
 
 // getSummaries processes our slice of structs into a single struct in
-func getSummaries(ts []TransactionCSV) (Summaries, error) {
+func getSummaries(ts []TransactionCSV, opts summaryOptions) (Summaries, error) {
+	cp := opts.checkpoint
 	sm := Summaries{}
-	monthTotals := make(map[string]int)
-	for _, t := range ts {
-		month, err := getMonth(t.Date)
+	start := 0
+	var st summaryState
+	if cp != nil {
+		c, ok, err := cp.Load()
 		if err != nil {
 			return Summaries{}, err
 		}
-		monthTotals[month]++
+		if ok {
+			sm, start, st = c.Summary, c.Rows, c.summaryState
+		}
+	}
+	if len(st.Quantiles) != len(summaryQuantiles) {
+		st.Quantiles = make([]p2Quantile, len(summaryQuantiles))
+		for i, p := range summaryQuantiles {
+			st.Quantiles[i] = newP2Quantile(p)
+		}
+	}
+	if st.Recurring == nil {
+		st.Recurring = make(map[string]*recurringSeen)
+	}
+	if st.Debits == nil {
+		st.Debits = make(map[string][]string)
+	}
+	qs := st.Quantiles
+	if sm.MonthlyTransactions == nil {
+		sm.MonthlyTransactions = make(map[string]int)
+	}
+	if sm.DailyTransactions == nil {
+		sm.DailyTransactions = make(map[int]DayActivity)
+	}
+	if sm.Yearly == nil {
+		sm.Yearly = make(map[int]YearTotals)
+	}
+	if sm.DailyNet == nil {
+		sm.DailyNet = make(map[string]float64)
+	}
+	settled := settledStatuses()
+	minAmt := envFloat("MIN_ABS_AMOUNT", 0)
+	largeAmt := envFloat("LARGE_TXN_THRESHOLD", 0)
+	refundWindow := envInt("REFUND_WINDOW_DAYS", defaultRefundWindowDays)
+	transfers := getTransferRules()
+	byCategory := features().CategoryTotals
+	if byCategory && sm.Categories == nil {
+		sm.Categories = make(map[string]CategoryTotals)
+	}
+	listMax := transactionsListMax()
+	sign, err := amountSign()
+	if err != nil {
+		return Summaries{}, err
+	}
+	rf := rowFormat{strict: features().StrictAmountFormat, sign: sign, months: opts.months, number: opts.number}
+	dedup, err := getDedupMode()
+	if err != nil {
+		return Summaries{}, err
+	}
+	future, err := getFutureDates()
+	if err != nil {
+		return Summaries{}, err
+	}
+	if rf.months == ([12]string{}) {
+		rf.months = languages[defaultLanguage].Months
+	}
+	asOf := opts.asOf
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+	// anything during the processing day itself is fine, whatever time zone the file was written in
+	cutoff := asOf.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	seen := make(map[string]bool)
+	if dedup != dedupOff {
+		// rows before a checkpoint were already counted, but later duplicates of them still need dropping
+		for _, t := range ts[:start] {
+			seen[dedup.key(t)] = true
+		}
+	}
+	credits, debits := st.CreditSum, st.DebitSum
+	// a checkpoint from before the compensation was saved only has the totals
+	if credits == (kahanSum{}) && debits == (kahanSum{}) {
+		credits, debits = kahanSum{Sum: sm.CreditTotal}, kahanSum{Sum: sm.DebitTotal}
+	}
 
-		amt, err := strconv.ParseFloat(t.Transaction, 64)
+	for i := start; i < len(ts); i++ {
+		if cp != nil && i > start && (i-start)%cp.every == 0 {
+			sm.CreditTotal, sm.DebitTotal = credits.Value(), debits.Value()
+			st.CreditSum, st.DebitSum = credits, debits
+			if err := cp.Save(i, sm, st); err != nil {
+				return Summaries{}, err
+			}
+		}
+
+		t := ts[i]
+		if opts.exclude[t.ID] {
+			sm.Excluded++
+			continue
+		}
+		if dedup != dedupOff {
+			k := dedup.key(t)
+			if seen[k] {
+				sm.Deduped++
+				continue
+			}
+			seen[k] = true
+		}
+		if t.Status != "" && !settled[strings.ToLower(t.Status)] {
+			continue
+		}
+
+		amt, month, err := parseRow(t, rf)
 		if err != nil {
-			return Summaries{}, err
+			if opts.lenient {
+				sm.RowErrors = append(sm.RowErrors, RowError{Row: t.rowNumber(i), ID: t.ID, Reason: err.Error()})
+				continue
+			}
+			return Summaries{}, parseError{err}
+		}
+		if future != futureInclude {
+			if dt, ok := getDate(t.Date); ok && dt.Year() > 0 && !dt.Before(cutoff) {
+				if future == futureExclude {
+					sm.FutureDated++
+					continue
+				}
+				err := fmt.Errorf("%w: transaction %s dated %s", ErrFutureDate, t.ID, t.Date)
+				if opts.lenient {
+					sm.RowErrors = append(sm.RowErrors, RowError{Row: t.rowNumber(i), ID: t.ID, Reason: err.Error()})
+					continue
+				}
+				return Summaries{}, parseError{err}
+			}
+		}
+		// penny auth checks and test transactions don't belong on a statement
+		if math.Abs(amt) < minAmt {
+			continue
+		}
+		sm.MonthlyTransactions[month]++
+		for i := range qs {
+			qs[i].Add(math.Abs(amt))
+		}
+		if t.Description != "" {
+			k := recurringKey(t.Description, amt)
+			rs, ok := st.Recurring[k]
+			if !ok {
+				rs = &recurringSeen{Description: t.Description, Amount: amt, Months: make(map[string]bool)}
+				st.Recurring[k] = rs
+			}
+			rs.Months[month] = true
+
+			if amt < 0 {
+				st.addDebit(t.Description, amt, t.Date)
+			}
+			if amt > 0 && st.takeRefund(t.Description, amt, t.Date, refundWindow) {
+				sm.Refunds++
+				sm.RefundTotal += amt
+			}
+		}
+		if byCategory {
+			sm.addCategory(strings.TrimSpace(t.Category), amt)
+		}
+		if transfers.isTransfer(t.Description, amt) {
+			sm.Transfers++
+			sm.TransferTotal += amt
 		}
+		if listMax > 0 {
+			if len(sm.Transactions) < listMax {
+				sm.Transactions = append(sm.Transactions, NotableTransaction{ID: t.ID, Date: t.Date, Amount: amt, Description: t.Description})
+			} else {
+				sm.TransactionsOmitted++
+			}
+		}
+		if largeAmt > 0 && math.Abs(amt) > largeAmt {
+			sm.LargeTransactions = append(sm.LargeTransactions, NotableTransaction{ID: t.ID, Date: t.Date, Amount: amt, Description: t.Description})
+		}
+
+		if opts.sections {
+			for len(sm.Sections) <= t.Section {
+				sm.Sections = append(sm.Sections, SectionTotals{Section: len(sm.Sections) + 1})
+			}
+			st := &sm.Sections[t.Section]
+			st.Count++
+			if amt > 0 {
+				st.CreditTotal += amt
+			}
+			if amt < 0 {
+				st.DebitTotal += amt
+			}
+		}
+		// without a year the day is ambiguous in files that span more than one, so it's left out
+		if _, dated := getYear(t.Date); dated {
+			if d, ok := getDay(t.Date); ok {
+				da := sm.DailyTransactions[d]
+				da.Day = d
+				da.Count++
+				da.Net += amt
+				sm.DailyTransactions[d] = da
+			}
+		}
+		if dt, ok := getDate(t.Date); ok {
+			sm.DailyNet[dt.Format(dayKeyLayout)] += amt
+			if first, ok := getDate(sm.FirstDate); !ok || dt.Before(first) {
+				sm.FirstDate = t.Date
+			}
+			if last, ok := getDate(sm.LastDate); !ok || dt.After(last) {
+				sm.LastDate = t.Date
+			}
+			if dt.Year() > 0 {
+				wa := &sm.Weekdays
+				if wd := dt.Weekday(); wd == time.Saturday || wd == time.Sunday {
+					wa = &sm.Weekend
+				}
+				wa.Count++
+				wa.Net += amt
+			}
+		}
+
 		if amt > 0 {
 			sm.CreditCount++
-			sm.CreditTotal += amt
+			credits.Add(amt)
+			if sm.LargestCredit == nil || amt > sm.LargestCredit.Amount {
+				sm.LargestCredit = &NotableTransaction{ID: t.ID, Date: t.Date, Amount: amt, Description: t.Description}
+			}
 		}
 		if amt < 0 {
 			sm.DebitCount++
-			sm.DebitTotal += amt
+			debits.Add(amt)
+			if sm.LargestDebit == nil || amt < sm.LargestDebit.Amount {
+				sm.LargestDebit = &NotableTransaction{ID: t.ID, Date: t.Date, Amount: amt, Description: t.Description}
+			}
+		}
+
+		if y, ok := getYear(t.Date); ok {
+			yt := sm.Yearly[y]
+			if amt > 0 {
+				yt.CreditCount++
+				yt.CreditTotal += amt
+			}
+			if amt < 0 {
+				yt.DebitCount++
+				yt.DebitTotal += amt
+			}
+			sm.Yearly[y] = yt
+		}
+	}
+	sm.CreditTotal = credits.Value()
+	sm.DebitTotal = debits.Value()
+	if math.Abs(sm.CreditTotal) > maxSafeTotal || math.Abs(sm.DebitTotal) > maxSafeTotal {
+		return Summaries{}, parseError{fmt.Errorf("totals exceed the maximum safe magnitude of %d", int64(maxSafeTotal))}
+	}
+
+	if qs[0].Count > 0 {
+		sm.AmountPercentiles = &AmountPercentiles{Median: qs[0].Value(), P90: qs[1].Value(), P95: qs[2].Value()}
+	}
+	sm.Recurring = recurring(st.Recurring, envInt("RECURRING_MIN_MONTHS", defaultRecurringMonths))
+	sm.derive()
+
+	if cp != nil {
+		st.CreditSum, st.DebitSum = credits, debits
+		if err := cp.Save(len(ts), sm, st); err != nil {
+			return Summaries{}, err
 		}
 	}
-	sm.MonthlyTransactions = monthTotals
 
 	return sm, nil
 }
 
-// readCSV takes the content of `f` and puts it in a slice of easy
-// to operate on for applying to the email template.
-func readCSV(f *os.File) ([]TransactionCSV, error) {
-	r := csv.NewReader(f)
-
-	// skip the header
-	if _, err := r.Read(); err != nil {
-		return []TransactionCSV{}, err
+// derive fills in the fields computed from the other aggregates once they are complete.
+func (sm *Summaries) derive() {
+	sm.LargestSwing = largestSwing(sm.DailyNet)
+	sm.NetPercentOfCredits = nil
+	if sm.CreditTotal > 0 {
+		p := (sm.CreditTotal + sm.DebitTotal) / sm.CreditTotal * 100
+		sm.NetPercentOfCredits = &p
 	}
-
-	rows, err := r.ReadAll()
-	if err != nil {
-		return []TransactionCSV{}, nil
+	sm.MonthlyAverage = 0
+	if len(sm.MonthlyTransactions) > 0 {
+		total := 0
+		for _, c := range sm.MonthlyTransactions {
+			total += c
+		}
+		sm.MonthlyAverage = float64(total) / float64(len(sm.MonthlyTransactions))
 	}
+}
 
-	var ts []TransactionCSV
-	for _, r := range rows {
-		// we're trusting there's no blank values
-		ts = append(ts, TransactionCSV{ID: r[0], Date: r[1], Transaction: r[2]})
+// settledStatuses reads SETTLED_STATUSES, the comma separated statuses that count towards a summary.
+// It defaults to `settled,posted`. Keys are lower case.
+func settledStatuses() map[string]bool {
+	v := getenv("SETTLED_STATUSES")
+	if v == "" {
+		v = "settled,posted"
 	}
 
-	return ts, nil
+	set := make(map[string]bool)
+	for _, st := range strings.Split(v, ",") {
+		if st = strings.TrimSpace(st); st != "" {
+			set[strings.ToLower(st)] = true
+		}
+	}
+	return set
 }
 
-// sendEmail uses `s` to send a formatted email from a template
-func sendEmail(s Summaries) error {
-	sess, err := session.NewSession(&aws.Config{})
-	if err != nil {
-		return err
+// isHeader reports whether `row` repeats `header`, which marks the start of another statement in a
+// concatenated file.
+func isHeader(row, header []string) bool {
+	if len(row) != len(header) {
+		return false
 	}
-	ss := secretsmanager.New(sess)
+	for i := range row {
+		if !strings.EqualFold(strings.TrimSpace(row[i]), strings.TrimSpace(header[i])) {
+			return false
+		}
+	}
+	return true
+}
 
-	input := secretsmanager.GetSecretValueInput{
-		SecretId: aws.String("EMAIL_SECRET"),
+// csvDelimiter reads CSV_DELIMITER, a single character that defaults to a comma. Quotes and line
+// breaks are refused, since the reader couldn't tell a quoted field or a new record from a delimiter.
+func csvDelimiter() (rune, error) {
+	d := getenv("CSV_DELIMITER")
+	// `\t` is hard to put in an environment variable as an actual tab
+	if d == `\t` {
+		d = "\t"
 	}
-	sv, err := ss.GetSecretValue(&input)
-	if err != nil {
-		return err
+	if d == "" {
+		return ',', nil
 	}
 
-	var ea EmailAuth
-	if err = json.Unmarshal([]byte(*sv.SecretString), &ea); err != nil {
-		return err
+	c, n := utf8.DecodeRuneInString(d)
+	if n != len(d) || c == utf8.RuneError || c == '"' || c == '\r' || c == '\n' {
+		return 0, fmt.Errorf("unsupported CSV_DELIMITER %q", d)
 	}
+	return c, nil
+}
 
-	auth := smtp.PlainAuth("", ea.Username, ea.Password, ea.Host)
-
-	tpl := `
-	<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN"
-	"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
-	<html>
-
-	</head>
-
-	<body>
-		<p>Hello Customer,</p>
-		<p>Here is a summary of your latest transactions:</p>
-
-		<p>Total Balance: {{.Total}}</p>
-		{{range $month, $count := .MonthlyTransactions}}<p>{{ $month }}: {{ $count }}</p>{{end}}
-		<p>Average debit amount: {{ .DebitAverage }}</p>
-		<p>Average credit amount: {{ .CreditAverage }}</p>
-	</body>
-
-	</html>
-`
-
-	// round the values out to hundreths
-	to := math.Round((s.CreditTotal+s.DebitTotal)*100) / 100
-	ca := math.Round(s.CreditTotal/float64(s.CreditCount)*100) / 100
-	da := math.Round(s.DebitTotal/float64(s.DebitCount)*100) / 100
+// readCSV takes the content of `f` and puts it in a slice of easy
+// to operate on for applying to the email template.
+func readCSV(f io.Reader) ([]TransactionCSV, error) {
+	ts, _, err := parseCSV(f, false)
+	return ts, err
+}
 
-	data := EmailSummary{
-		Total:               to,
-		MonthlyTransactions: s.MonthlyTransactions,
-		CreditAverage:       ca,
-		DebitAverage:        da,
+// parseCSV reads `f` like readCSV. With `lenient`, rows that can't be read or don't reach the Id,
+// Date or Transaction column are left out and returned as RowErrors instead of failing the file.
+// Problems with the file as a whole, like a bad header or too many rows, fail it either way.
+func parseCSV(f io.Reader, lenient bool) ([]TransactionCSV, []RowError, error) {
+	in, err := decodeReader(f, csvEncoding())
+	if err != nil {
+		return []TransactionCSV{}, nil, err
+	}
+	// quoted fields may hold the delimiter and newlines, as in a multi-line description, which the
+	// reader only gets right with LazyQuotes left off
+	r := csv.NewReader(newLineLimitReader(in, envIntMin("MAX_LINE_BYTES", defaultMaxLineBytes, 0)))
+	if r.Comma, err = csvDelimiter(); err != nil {
+		return []TransactionCSV{}, nil, err
+	}
+	trim := envBoolDefault("TRIM_FIELDS", true)
+	r.TrimLeadingSpace = trim
+	// messy exports add or drop trailing columns, which only matters if a column we read is missing
+	if features().VariableFields {
+		r.FieldsPerRecord = -1
 	}
 
-	t, err := template.New("email").Parse(tpl)
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		// a file that decodes to nothing, e.g. only a byte order mark, has no header to read
+		return []TransactionCSV{}, nil, fmt.Errorf("%w: no header row", ErrEmptyFile)
+	}
 	if err != nil {
-		return err
+		return []TransactionCSV{}, nil, fmt.Errorf("reading header row: %w", err)
 	}
+	aliases, err := headerAliases()
+	if err != nil {
+		return []TransactionCSV{}, nil, err
+	}
+	cols := mapColumns(header, aliases)
 
-	buf := new(bytes.Buffer)
-	if err := t.Execute(buf, data); err != nil {
-		return err
+	// rows are read one at a time so a runaway file is stopped before it is all in memory
+	maxRows := envInt("MAX_ROWS", 0)
+	var rows [][]string
+	// lines[i] is the line row i starts on, which drifts from the row number once a field spans lines,
+	// and nums[i] its row number, which is ahead of i once a row was left out
+	var lines, nums []int
+	var rowErrs []RowError
+	for n := 1; ; n++ {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var perr *csv.ParseError
+		if lenient && errors.As(err, &perr) {
+			rowErrs = append(rowErrs, RowError{Row: n, Reason: err.Error()})
+			continue
+		}
+		if err != nil {
+			return []TransactionCSV{}, nil, fmt.Errorf("reading rows: %w", err)
+		}
+		if maxRows > 0 && len(rows) >= maxRows {
+			logJSON("error", "row limit exceeded", map[string]interface{}{"max_rows": maxRows, "rows": len(rows) + 1})
+			return []TransactionCSV{}, nil, fmt.Errorf("%w: more than %d rows", ErrTooManyRows, maxRows)
+		}
+		line, _ := r.FieldPos(0)
+		rows = append(rows, row)
+		lines = append(lines, line)
+		nums = append(nums, n)
+	}
+	need := cols.ID
+	if cols.Date > need {
+		need = cols.Date
+	}
+	if cols.Transaction > need {
+		need = cols.Transaction
 	}
-	body := buf.String()
 
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	subj := "Subject: Transaction Summary\n"
-	msg := []byte(subj + mime + "\n" + body)
+	concatenated := getenv("REPEATED_HEADERS")
+	section := 0
 
-	if err := smtp.SendMail(ea.Host+":587", auth, ea.Username, []string{ea.Username}, msg); err != nil {
-		return err
+	var ts []TransactionCSV
+	for i, r := range rows {
+		// exports sometimes pad fields like ` -60.50 `, which would break date and amount parsing
+		if trim {
+			for i := range r {
+				r[i] = strings.TrimSpace(r[i])
+			}
+		}
+		// some partners concatenate statements into one object, each with its own header row
+		if concatenated != "" && isHeader(r, header) {
+			section++
+			continue
+		}
+		if need >= len(r) {
+			// rows are numbered like RowError, from 1 after the header
+			err := fmt.Errorf("%w: row %d (line %d) has %d fields", ErrShortRow, nums[i], lines[i], len(r))
+			if lenient {
+				id := ""
+				if cols.ID >= 0 && cols.ID < len(r) {
+					id = r[cols.ID]
+				}
+				rowErrs = append(rowErrs, RowError{Row: nums[i], ID: id, Reason: err.Error()})
+				continue
+			}
+			return []TransactionCSV{}, nil, err
+		}
+		// we're trusting there's no blank values
+		t := TransactionCSV{ID: r[cols.ID], Date: r[cols.Date], Transaction: r[cols.Transaction], Row: nums[i]}
+		if concatenated == "sections" {
+			t.Section = section
+		}
+		if cols.Status >= 0 && cols.Status < len(r) {
+			t.Status = strings.TrimSpace(r[cols.Status])
+		}
+		if cols.Description >= 0 && cols.Description < len(r) {
+			t.Description = r[cols.Description]
+		}
+		if cols.Account >= 0 && cols.Account < len(r) {
+			t.Account = r[cols.Account]
+		}
+		if cols.Category >= 0 && cols.Category < len(r) {
+			t.Category = r[cols.Category]
+		}
+		ts = append(ts, t)
+	}
+	if err := checkColumns(ts, header, cols); err != nil {
+		return []TransactionCSV{}, nil, err
 	}
 
-	return nil
+	return ts, rowErrs, nil
 }
 
 func main() {
-	months = map[int]string{
-		1:  "January",
-		2:  "February",
-		3:  "March",
-		4:  "April",
-		5:  "May",
-		6:  "June",
-		7:  "July",
-		8:  "August",
-		9:  "September",
-		10: "October",
-		11: "November",
-		12: "December",
+	if err := setupTracing(context.Background()); err != nil {
+		log.Printf("tracing disabled: %v", err)
+	}
+
+	// the same binary can sit behind API Gateway to validate files synchronously
+	if os.Getenv("HANDLER_MODE") == "api" {
+		lambda.Start(HandleAPIRequest)
+		return
+	}
+	// and on an EventBridge schedule to send digests
+	if os.Getenv("HANDLER_MODE") == "digest" {
+		lambda.Start(HandleDigest)
+		return
 	}
 
 	lambda.Start(HandleRequest)
@@ -0,0 +1,1093 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+func TestMain(m *testing.M) {
+	// every step logs a JSON line, which buries the test output
+	if os.Getenv("TEST_LOGS") == "" {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// withConfig makes `c` the loaded config document until the test ends, nil for none at all. Feature
+// flags are read again afterwards, so set the environment first.
+func withConfig(t *testing.T, c *Config) {
+	t.Helper()
+
+	config.mu.Lock()
+	loaded, ft := config.loaded, config.features
+	config.loaded, config.features = c, nil
+	config.mu.Unlock()
+
+	t.Cleanup(func() {
+		config.mu.Lock()
+		config.loaded, config.features = loaded, ft
+		config.mu.Unlock()
+	})
+}
+
+// csvFixture builds the content of a CSV file from transactions, so tests can describe their input as
+// data instead of keeping fixture files around. The Status, Description, Account and Category columns
+// are only written when some transaction has one, and Row is ignored.
+type csvFixture struct {
+	// Delimiter separates fields, a comma when zero.
+	Delimiter rune
+	// NoHeader leaves out the header row.
+	NoHeader bool
+	// DateLayout, when set, rewrites every date that getDate can read in this time layout, e.g.
+	// `01/02/2006` for zero padded dates.
+	DateLayout string
+}
+
+// build writes `ts` as a CSV file.
+func (f csvFixture) build(ts []TransactionCSV) string {
+	optional := []struct {
+		name string
+		get  func(TransactionCSV) string
+	}{
+		{"Status", func(t TransactionCSV) string { return t.Status }},
+		{"Description", func(t TransactionCSV) string { return t.Description }},
+		{"Account", func(t TransactionCSV) string { return t.Account }},
+		{"Category", func(t TransactionCSV) string { return t.Category }},
+	}
+	header := []string{"Id", "Date", "Transaction"}
+	var cols []func(TransactionCSV) string
+	for _, o := range optional {
+		for _, t := range ts {
+			if o.get(t) != "" {
+				header = append(header, o.name)
+				cols = append(cols, o.get)
+				break
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if f.Delimiter != 0 {
+		w.Comma = f.Delimiter
+	}
+	if !f.NoHeader {
+		w.Write(header)
+	}
+	for _, t := range ts {
+		date := t.Date
+		if dt, ok := getDate(date); ok && f.DateLayout != "" {
+			date = dt.Format(f.DateLayout)
+		}
+		row := []string{t.ID, date, t.Transaction}
+		for _, get := range cols {
+			row = append(row, get(t))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+
+	return b.String()
+}
+
+// sampleTransactions are the rows of sample.csv at the root of the repository.
+var sampleTransactions = []TransactionCSV{
+	{ID: "0", Date: "7/15", Transaction: "+60.5"},
+	{ID: "1", Date: "7/28", Transaction: "-10.3"},
+	{ID: "2", Date: "8/2", Transaction: "-20.46"},
+	{ID: "3", Date: "8/13", Transaction: "+10"},
+}
+
+func TestCSVFixtureRoundTrip(t *testing.T) {
+	withConfig(t, nil)
+
+	ts := []TransactionCSV{
+		{ID: "1", Date: "7/15/2021", Transaction: "+60.5", Description: "Payroll, July", Row: 1},
+		{ID: "2", Date: "7/28/2021", Transaction: "-10.3", Description: `Coffee "to go"`, Status: "posted", Row: 2},
+		{ID: "3", Date: "8/2/2021", Transaction: "-20.46", Description: "Groceries\nand more", Category: "Food", Row: 3},
+	}
+	got, err := readCSV(strings.NewReader(csvFixture{}.build(ts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ts) {
+		t.Errorf("readCSV(build(ts)) = %+v, want %+v", got, ts)
+	}
+}
+
+func TestCSVFixtureSample(t *testing.T) {
+	b, err := os.ReadFile("../sample.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := csvFixture{}.build(sampleTransactions), strings.ReplaceAll(string(b), "\r\n", "\n")
+	if strings.TrimSpace(got) != strings.TrimSpace(want) {
+		t.Errorf("build(sampleTransactions) =\n%s\nwant sample.csv\n%s", got, want)
+	}
+}
+
+func TestCSVFixtureDelimiter(t *testing.T) {
+	t.Setenv("CSV_DELIMITER", ";")
+	withConfig(t, nil)
+
+	ts := []TransactionCSV{{ID: "1", Date: "7/15", Transaction: "1,50", Description: "a;b", Row: 1}}
+	got, err := readCSV(strings.NewReader(csvFixture{Delimiter: ';'}.build(ts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ts) {
+		t.Errorf("readCSV = %+v, want %+v", got, ts)
+	}
+}
+
+func TestCSVFixtureDateLayout(t *testing.T) {
+	withConfig(t, nil)
+
+	content := csvFixture{DateLayout: "01/02/2006"}.build([]TransactionCSV{{ID: "1", Date: "7/5/2021", Transaction: "10"}})
+	got, err := readCSV(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Date != "07/05/2021" {
+		t.Fatalf("readCSV = %+v, want one row dated 07/05/2021", got)
+	}
+
+	sm, err := getSummaries(got, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.MonthlyTransactions["July 2021"] != 1 {
+		t.Errorf("MonthlyTransactions = %v, want July 2021: 1", sm.MonthlyTransactions)
+	}
+}
+
+func TestCSVFixtureNoHeader(t *testing.T) {
+	got := csvFixture{NoHeader: true}.build(sampleTransactions[:1])
+	if want := "0,7/15,+60.5\n"; got != want {
+		t.Errorf("build = %q, want %q", got, want)
+	}
+}
+
+// fakeDownloader serves objects from memory in place of S3, keyed by `bucket/key`.
+type fakeDownloader struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// calls counts the downloads, including ones for missing objects.
+	calls int
+}
+
+func (d *fakeDownloader) Download(w io.WriterAt, in *s3.GetObjectInput, _ ...func(*s3manager.Downloader)) (int64, error) {
+	d.mu.Lock()
+	d.calls++
+	b, ok := d.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	d.mu.Unlock()
+	if !ok {
+		return 0, awserr.New(s3.ErrCodeNoSuchKey, "the specified key does not exist", nil)
+	}
+
+	n, err := w.WriteAt(b, 0)
+	return int64(n), err
+}
+
+func (d *fakeDownloader) DownloadWithContext(_ aws.Context, w io.WriterAt, in *s3.GetObjectInput, opts ...func(*s3manager.Downloader)) (int64, error) {
+	return d.Download(w, in, opts...)
+}
+
+// useFakeDownloader makes the handler read files from `objects`, keyed by `bucket/key`, until the test ends.
+func useFakeDownloader(t *testing.T, objects map[string][]byte) *fakeDownloader {
+	t.Helper()
+
+	d := &fakeDownloader{objects: objects}
+	prev := newDownloader
+	newDownloader = func(*session.Session) s3manageriface.DownloaderAPI { return d }
+	t.Cleanup(func() { newDownloader = prev })
+
+	return d
+}
+
+// fakeS3 is an in-memory S3 that also serves as an s3manager uploader, for the code that reads and
+// writes its own objects. Objects are keyed by `bucket/key`. Calls it doesn't implement panic on the
+// nil embedded interface.
+type fakeS3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string]storedObject
+}
+
+// storedObject is an object in a fakeS3.
+type storedObject struct {
+	Body        []byte
+	ContentType string
+	Metadata    map[string]*string
+}
+
+func (f *fakeS3) put(bucket, key string, o storedObject) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects == nil {
+		f.objects = map[string]storedObject{}
+	}
+	f.objects[bucket+"/"+key] = o
+}
+
+// get returns the object at `bucket/key`, and false when there is none.
+func (f *fakeS3) get(bucket, key string) (storedObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.objects[bucket+"/"+key]
+	return o, ok
+}
+
+// keys returns the keys in `bucket` under `prefix`, sorted.
+func (f *fakeS3) keys(bucket, prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		if rest := strings.TrimPrefix(k, bucket+"/"); rest != k && strings.HasPrefix(rest, prefix) {
+			keys = append(keys, rest)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	o, ok := f.get(aws.StringValue(in.Bucket), aws.StringValue(in.Key))
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "the specified key does not exist", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(o.Body)),
+		ContentLength: aws.Int64(int64(len(o.Body))),
+		ContentType:   aws.String(o.ContentType),
+		Metadata:      o.Metadata,
+	}, nil
+}
+
+func (f *fakeS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.put(aws.StringValue(in.Bucket), aws.StringValue(in.Key), storedObject{Body: b, ContentType: aws.StringValue(in.ContentType), Metadata: in.Metadata})
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	out := &s3.ListObjectsV2Output{}
+	seen := map[string]bool{}
+	delim := aws.StringValue(in.Delimiter)
+	for _, k := range f.keys(aws.StringValue(in.Bucket), aws.StringValue(in.Prefix)) {
+		rest := strings.TrimPrefix(k, aws.StringValue(in.Prefix))
+		if i := strings.Index(rest, delim); delim != "" && i >= 0 {
+			p := aws.StringValue(in.Prefix) + rest[:i+len(delim)]
+			if !seen[p] {
+				seen[p] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(p)})
+			}
+			continue
+		}
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(k)})
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeS3) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, o := range in.Delete.Objects {
+		delete(f.objects, aws.StringValue(in.Bucket)+"/"+aws.StringValue(o.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	o, ok := f.get(aws.StringValue(in.Bucket), aws.StringValue(in.Key))
+	if !ok {
+		return nil, awserr.New("NotFound", "not found", nil)
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(o.Body))),
+		ContentType:   aws.String(o.ContentType),
+		Metadata:      o.Metadata,
+	}, nil
+}
+
+// CopyObject copies within the fake, keeping the source's content type and metadata unless the
+// request replaces them, like S3 does.
+func (f *fakeS3) CopyObject(in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	src, err := url.PathUnescape(aws.StringValue(in.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	i := strings.IndexByte(src, '/')
+	o, ok := f.get(src[:i], src[i+1:])
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "the specified key does not exist", nil)
+	}
+	if aws.StringValue(in.MetadataDirective) == s3.MetadataDirectiveReplace {
+		o.ContentType, o.Metadata = aws.StringValue(in.ContentType), in.Metadata
+	}
+	f.put(aws.StringValue(in.Bucket), aws.StringValue(in.Key), o)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) Upload(in *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.put(aws.StringValue(in.Bucket), aws.StringValue(in.Key), storedObject{Body: b, ContentType: aws.StringValue(in.ContentType), Metadata: in.Metadata})
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (f *fakeS3) UploadWithContext(_ aws.Context, in *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	return f.Upload(in, opts...)
+}
+
+// s3Event is the notification S3 sends for a new object.
+func s3Event(bucket, key, etag string, size int) events.S3Event {
+	return events.S3Event{Records: []events.S3EventRecord{{
+		EventSource: "aws:s3",
+		EventName:   "ObjectCreated:Put",
+		EventTime:   time.Now().UTC(),
+		S3: events.S3Entity{
+			Bucket: events.S3Bucket{Name: bucket},
+			Object: events.S3Object{Key: key, URLDecodedKey: key, ETag: etag, Size: int64(size)},
+		},
+	}}}
+}
+
+func TestHandleRequestEndToEnd(t *testing.T) {
+	withConfig(t, nil)
+	content := csvFixture{}.build(sampleTransactions)
+	d := useFakeDownloader(t, map[string][]byte{"uploads/csv/july.csv": []byte(content)})
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+
+	if err := HandleRequest(context.Background(), s3Event("uploads", "csv/july.csv", "abc123", len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if d.calls != 1 {
+		t.Errorf("downloaded %d times, want 1", d.calls)
+	}
+
+	msgs := m.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+	hdr, body := msgs[0].Parse(t)
+	if got := hdr.Header.Get("To"); got != "<statements@example.com>" {
+		t.Errorf("To = %q, want the EMAIL_SECRET address", got)
+	}
+	if got := hdr.Header.Get("Subject"); got != "Transaction Summary" {
+		t.Errorf("Subject = %q", got)
+	}
+	if got := hdr.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", got)
+	}
+	for _, want := range []string{
+		"Total credits: $70.50",
+		"Total debits: -$30.76",
+		"Net Change: $39.74",
+		"July: 2",
+		"August: 2",
+		"Average credit amount: $35.25",
+		"Average debit amount: -$15.38",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandleRequestMissingObject(t *testing.T) {
+	withConfig(t, nil)
+	useFakeDownloader(t, nil)
+	m := useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+
+	err := HandleRequest(context.Background(), s3Event("uploads", "csv/gone.csv", "abc123", 10))
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != s3.ErrCodeNoSuchKey {
+		t.Errorf("HandleRequest error = %v, want NoSuchKey", err)
+	}
+	if n := len(m.Messages()); n != 0 {
+		t.Errorf("sent %d messages for a missing file, want 0", n)
+	}
+}
+
+func TestGetFileLeavesNothingBehind(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	withConfig(t, nil)
+	d := &fakeDownloader{objects: map[string][]byte{
+		"b/empty.csv":  {},
+		"b/sample.csv": []byte(csvFixture{}.build(sampleTransactions)),
+	}}
+
+	if _, err := getFile(s3Object{Bucket: "b", Key: "missing.csv"}, 0, d); err == nil {
+		t.Error("getFile(missing.csv) succeeded")
+	}
+	if _, err := getFile(s3Object{Bucket: "b", Key: "empty.csv"}, 0, d); !errors.Is(err, ErrEmptyFile) {
+		t.Errorf("getFile(empty.csv) = %v, want ErrEmptyFile", err)
+	}
+
+	f, err := getFile(s3Object{Bucket: "b", Key: "sample.csv"}, 0, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := getFile(s3Object{Bucket: "b", Key: "sample.csv"}, 0, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() == other.Name() {
+		t.Errorf("two downloads of the same key share %s", f.Name())
+	}
+	removeFile(f)
+	removeFile(other)
+
+	left, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("left %d files in the temp dir, want none", len(left))
+	}
+}
+
+func TestGetSummariesNonFinite(t *testing.T) {
+	withConfig(t, nil)
+
+	for _, amt := range []string{"Inf", "-Inf", "+Inf", "NaN", "infinity"} {
+		ts := []TransactionCSV{{ID: "0", Date: "7/15", Transaction: "+60.5"}, {ID: "1", Date: "7/16", Transaction: amt}}
+		_, err := getSummaries(ts, summaryOptions{})
+		if !errors.As(err, &parseError{}) || !strings.Contains(err.Error(), "transaction 1: amount") {
+			t.Errorf("%s: getSummaries error = %v, want a parseError for transaction 1", amt, err)
+		}
+
+		sm, err := getSummaries(ts, summaryOptions{lenient: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sm.RowErrors) != 1 || sm.RowErrors[0].ID != "1" || sm.CreditTotal != 60.5 {
+			t.Errorf("%s: lenient summary = %+v, %v, want only transaction 1 left out", amt, sm.RowErrors, sm.CreditTotal)
+		}
+	}
+}
+
+func TestGetSummariesSettledStatuses(t *testing.T) {
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15", Transaction: "+60.5", Status: "settled"},
+		{ID: "1", Date: "7/16", Transaction: "-10.3", Status: "pending"},
+		{ID: "2", Date: "7/17", Transaction: "-20.46", Status: "POSTED"},
+		{ID: "3", Date: "7/18", Transaction: "+10"},
+		{ID: "4", Date: "7/19", Transaction: "+5", Status: "authorized"},
+	}
+
+	for _, tc := range []struct {
+		settled        string
+		credits, debit float64
+		count          int
+	}{
+		// no Status counts as settled, and the match ignores case
+		{"", 70.5, -20.46, 3},
+		{"pending, authorized", 15, -10.3, 3},
+	} {
+		t.Run(tc.settled, func(t *testing.T) {
+			t.Setenv("SETTLED_STATUSES", tc.settled)
+			withConfig(t, nil)
+
+			sm, err := getSummaries(ts, summaryOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sm.CreditTotal != tc.credits || sm.DebitTotal != tc.debit || sm.CreditCount+sm.DebitCount != tc.count {
+				t.Errorf("credits, debits, count = %v, %v, %d, want %v, %v, %d", sm.CreditTotal, sm.DebitTotal, sm.CreditCount+sm.DebitCount, tc.credits, tc.debit, tc.count)
+			}
+		})
+	}
+}
+
+func TestReadCSVStatusColumn(t *testing.T) {
+	withConfig(t, nil)
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15", Transaction: "+60.5", Status: "settled"},
+		{ID: "1", Date: "7/16", Transaction: "-10.3", Status: "pending"},
+	}
+
+	got, err := readCSV(strings.NewReader(csvFixture{}.build(ts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := getSummaries(got, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.CreditTotal != 60.5 || sm.DebitCount != 0 {
+		t.Errorf("summary counted %v credits and %d debits, want the pending row left out", sm.CreditTotal, sm.DebitCount)
+	}
+}
+
+// multiYear spans December 2020 to December 2021.
+var multiYear = []TransactionCSV{
+	{ID: "0", Date: "12/15/2020", Transaction: "+100"},
+	{ID: "1", Date: "1/5/2021", Transaction: "-40"},
+	{ID: "2", Date: "1/6/2021", Transaction: "+20"},
+	{ID: "3", Date: "12/20/2021", Transaction: "-10"},
+}
+
+func TestGetSummariesMultipleYears(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(multiYear, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"December 2020": 1, "January 2021": 2, "December 2021": 1}
+	if !reflect.DeepEqual(sm.MonthlyTransactions, want) {
+		t.Errorf("MonthlyTransactions = %v, want %v", sm.MonthlyTransactions, want)
+	}
+	// the average is over every month with activity, not just one year's
+	if got := math.Round(sm.MonthlyAverage*1000) / 1000; got != 1.333 {
+		t.Errorf("MonthlyAverage = %v, want 4 transactions over 3 months", sm.MonthlyAverage)
+	}
+	wantYears := map[int]YearTotals{
+		2020: {CreditCount: 1, CreditTotal: 100},
+		2021: {CreditCount: 1, CreditTotal: 20, DebitCount: 2, DebitTotal: -50},
+	}
+	if !reflect.DeepEqual(sm.Yearly, wantYears) {
+		t.Errorf("Yearly = %+v, want %+v", sm.Yearly, wantYears)
+	}
+}
+
+func TestRenderEmailPerYearAverages(t *testing.T) {
+	t.Setenv("PER_YEAR_AVERAGES", "true")
+	withConfig(t, nil)
+
+	sm, err := getSummaries(multiYear, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2020 average debit: $0.00, average credit: $100.00",
+		"2021 average debit: -$25.00, average credit: $20.00",
+	} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+}
+
+const paddedCSV = "Id , Date , Transaction\n 0 , 6/1 , -60.50 \n1,\t7/15\t,+10\n"
+
+func TestReadCSVTrimsFields(t *testing.T) {
+	withConfig(t, nil)
+
+	ts, err := readCSV(strings.NewReader(paddedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TransactionCSV{
+		{ID: "0", Date: "6/1", Transaction: "-60.50", Row: 1},
+		{ID: "1", Date: "7/15", Transaction: "+10", Row: 2},
+	}
+	if !reflect.DeepEqual(ts, want) {
+		t.Errorf("readCSV = %+v, want %+v", ts, want)
+	}
+	if _, err := getSummaries(ts, summaryOptions{}); err != nil {
+		t.Errorf("getSummaries = %v on trimmed fields", err)
+	}
+}
+
+func TestReadCSVTrimFieldsOff(t *testing.T) {
+	t.Setenv("TRIM_FIELDS", "false")
+	withConfig(t, nil)
+
+	// the header still matches, but the values are left exactly as written
+	ts, err := readCSV(strings.NewReader(paddedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 || ts[0].Transaction != " -60.50 " {
+		t.Fatalf("readCSV = %+v, want the padding kept", ts)
+	}
+}
+
+func TestGetSummariesMinAbsAmount(t *testing.T) {
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15", Transaction: "+60.5"},
+		{ID: "1", Date: "7/16", Transaction: "+0.01"},
+		{ID: "2", Date: "7/17", Transaction: "-0.99"},
+		{ID: "3", Date: "7/18", Transaction: "-1.00"},
+	}
+
+	for _, tc := range []struct {
+		min            string
+		credits, debit float64
+		count          int
+	}{
+		{"", 60.51, -1.99, 4},
+		{"0", 60.51, -1.99, 4},
+		// an amount of exactly the threshold stays
+		{"1", 60.5, -1, 2},
+	} {
+		t.Run("min="+tc.min, func(t *testing.T) {
+			t.Setenv("MIN_ABS_AMOUNT", tc.min)
+			withConfig(t, nil)
+
+			sm, err := getSummaries(ts, summaryOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(sm.CreditTotal-tc.credits) > 1e-9 || math.Abs(sm.DebitTotal-tc.debit) > 1e-9 || sm.CreditCount+sm.DebitCount != tc.count {
+				t.Errorf("credits, debits, count = %v, %v, %d, want %v, %v, %d", sm.CreditTotal, sm.DebitTotal, sm.CreditCount+sm.DebitCount, tc.credits, tc.debit, tc.count)
+			}
+			if n := sm.MonthlyTransactions["July"]; n != tc.count {
+				t.Errorf("July has %d transactions, want %d", n, tc.count)
+			}
+		})
+	}
+}
+
+// concatenatedCSV is two daily files joined into one object, each with its own header.
+const concatenatedCSV = "Id,Date,Transaction\n0,7/15,+60.5\n1,7/16,-10.3\nId,Date,Transaction\n2,7/17,-20.46\n3,7/18,+10\n"
+
+func TestReadCSVRepeatedHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		mode     string
+		sections []int
+	}{
+		{"merge", []int{0, 0, 0, 0}},
+		{"sections", []int{0, 0, 1, 1}},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			t.Setenv("REPEATED_HEADERS", tc.mode)
+			withConfig(t, nil)
+
+			ts, err := readCSV(strings.NewReader(concatenatedCSV))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			var sections []int
+			for _, tr := range ts {
+				ids, sections = append(ids, tr.ID), append(sections, tr.Section)
+			}
+			if strings.Join(ids, ",") != "0,1,2,3" || !reflect.DeepEqual(sections, tc.sections) {
+				t.Errorf("readCSV ids, sections = %v, %v, want 0-3 in sections %v", ids, sections, tc.sections)
+			}
+		})
+	}
+}
+
+func TestReadCSVRepeatedHeadersOff(t *testing.T) {
+	withConfig(t, nil)
+
+	// without the mode the second header is just a row with a bad amount
+	ts, err := readCSV(strings.NewReader(concatenatedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getSummaries(ts, summaryOptions{}); err == nil || !strings.Contains(err.Error(), `invalid amount "Transaction"`) {
+		t.Errorf("getSummaries error = %v, want the repeated header rejected", err)
+	}
+}
+
+func TestRenderEmailSections(t *testing.T) {
+	t.Setenv("REPEATED_HEADERS", "sections")
+	withConfig(t, nil)
+
+	ts, err := readCSV(strings.NewReader(concatenatedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := getSummaries(ts, summaryOptions{sections: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SectionTotals{{Section: 1, Count: 2, CreditTotal: 60.5, DebitTotal: -10.3}, {Section: 2, Count: 2, CreditTotal: 10, DebitTotal: -20.46}}
+	if !reflect.DeepEqual(sm.Sections, want) {
+		t.Errorf("Sections = %+v, want %+v", sm.Sections, want)
+	}
+	if sm.CreditTotal != 70.5 {
+		t.Errorf("CreditTotal = %v, want both statements together", sm.CreditTotal)
+	}
+
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Statement 1: 2 transactions, credits $60.50, debits -$10.30",
+		"Statement 2: 2 transactions, credits $10.00, debits -$20.46",
+	} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+}
+
+func TestGetSummariesDebitPositive(t *testing.T) {
+	t.Setenv("AMOUNT_SIGN_CONVENTION", "debit_positive")
+	withConfig(t, nil)
+
+	// the same file as sampleTransactions from a partner that records debits as positive
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15", Transaction: "-60.5"},
+		{ID: "1", Date: "7/28", Transaction: "10.3"},
+		{ID: "2", Date: "8/2", Transaction: "+20.46"},
+		{ID: "3", Date: "8/13", Transaction: "-10"},
+	}
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.CreditTotal != 70.5 || sm.DebitTotal != -30.76 || sm.CreditCount != 2 || sm.DebitCount != 2 {
+		t.Errorf("credits, debits = %v (%d), %v (%d), want 70.5 (2), -30.76 (2)", sm.CreditTotal, sm.CreditCount, sm.DebitTotal, sm.DebitCount)
+	}
+	if sm.LargestDebit == nil || sm.LargestDebit.ID != "2" || sm.LargestDebit.Amount != -20.46 {
+		t.Errorf("LargestDebit = %+v, want transaction 2 as a debit", sm.LargestDebit)
+	}
+}
+
+func TestAmountSign(t *testing.T) {
+	for v, want := range map[string]float64{"": 1, "credit_positive": 1, "debit_positive": -1} {
+		t.Setenv("AMOUNT_SIGN_CONVENTION", v)
+		withConfig(t, nil)
+
+		if got, err := amountSign(); err != nil || got != want {
+			t.Errorf("%q: amountSign = %v, %v, want %v", v, got, err, want)
+		}
+	}
+
+	t.Setenv("AMOUNT_SIGN_CONVENTION", "debits_positive")
+	withConfig(t, nil)
+	if _, err := getSummaries(sampleTransactions, summaryOptions{}); err == nil || !strings.Contains(err.Error(), "AMOUNT_SIGN_CONVENTION") {
+		t.Errorf("getSummaries error = %v, want the convention rejected", err)
+	}
+}
+
+func TestParseRowStrictAmountFormat(t *testing.T) {
+	strict := rowFormat{strict: true, sign: 1}
+	for _, amt := range []string{"1e3", "0x1p4", "Inf", "-Inf", "NaN", "1_000", "1,000.00"} {
+		if _, _, err := parseRow(TransactionCSV{ID: "0", Date: "7/15", Transaction: amt}, strict); err == nil || !strings.Contains(err.Error(), "plain decimal") {
+			t.Errorf("%q: parseRow error = %v, want it rejected as not a plain decimal", amt, err)
+		}
+	}
+	for amt, want := range map[string]float64{"-60.50": -60.5, "+10": 10, "10.": 10, ".5": 0.5, "60.50 DR": -60.5} {
+		got, _, err := parseRow(TransactionCSV{ID: "0", Date: "7/15", Transaction: amt}, strict)
+		if err != nil || got != want {
+			t.Errorf("%q: parseRow = %v, %v, want %v", amt, got, err, want)
+		}
+	}
+
+	// without it ParseFloat's wider syntax is read as written
+	if got, _, err := parseRow(TransactionCSV{ID: "0", Date: "7/15", Transaction: "1e3"}, rowFormat{sign: 1}); err != nil || got != 1000 {
+		t.Errorf("lenient parseRow(1e3) = %v, %v, want 1000", got, err)
+	}
+}
+
+func TestGetSummariesStrictAmountFormat(t *testing.T) {
+	t.Setenv("STRICT_AMOUNT_FORMAT", "true")
+	withConfig(t, nil)
+
+	ts := append([]TransactionCSV{}, sampleTransactions...)
+	ts[1].Transaction = "-1e1"
+	if _, err := getSummaries(ts, summaryOptions{}); err == nil || !strings.Contains(err.Error(), "transaction 1") {
+		t.Errorf("getSummaries error = %v, want transaction 1 rejected", err)
+	}
+	if _, err := getSummaries(sampleTransactions, summaryOptions{}); err != nil {
+		t.Errorf("getSummaries of plain decimals = %v", err)
+	}
+}
+
+func TestGetSummariesNetPercentOfCredits(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(sampleTransactions, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := sm.NetPercentOfCredits; p == nil || math.Abs(*p-39.74/70.5*100) > 1e-9 {
+		t.Errorf("NetPercentOfCredits = %v, want 39.74 / 70.50", p)
+	}
+
+	// only debits: there is nothing to take a share of
+	sm, err = getSummaries(sampleTransactions[1:3], summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.NetPercentOfCredits != nil {
+		t.Errorf("NetPercentOfCredits = %v without any credits, want nil", *sm.NetPercentOfCredits)
+	}
+}
+
+// dated runs up to the day after 30 June 2021, with one row without a year.
+var dated = []TransactionCSV{
+	{ID: "0", Date: "6/29/2021", Transaction: "+100"},
+	{ID: "1", Date: "6/30/2021", Transaction: "-40"},
+	{ID: "2", Date: "7/1/2021", Transaction: "-20"},
+	{ID: "3", Date: "12/20", Transaction: "+5"},
+}
+
+var datedAsOf = time.Date(2021, 6, 30, 23, 0, 0, 0, time.UTC)
+
+func TestGetSummariesFutureDates(t *testing.T) {
+	for _, tc := range []struct {
+		mode        string
+		futureDated int
+		debits      float64
+	}{
+		{"", 0, -60},
+		{"exclude", 1, -40},
+		{"Exclude", 1, -40},
+	} {
+		t.Setenv("REJECT_FUTURE_DATES", tc.mode)
+		withConfig(t, nil)
+
+		sm, err := getSummaries(dated, summaryOptions{asOf: datedAsOf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the processing day itself and rows without a year are never in the future
+		if sm.FutureDated != tc.futureDated || sm.DebitTotal != tc.debits || sm.CreditTotal != 105 {
+			t.Errorf("REJECT_FUTURE_DATES=%q: FutureDated, debits, credits = %d, %v, %v, want %d, %v, 105",
+				tc.mode, sm.FutureDated, sm.DebitTotal, sm.CreditTotal, tc.futureDated, tc.debits)
+		}
+	}
+}
+
+func TestGetSummariesFutureDatesError(t *testing.T) {
+	t.Setenv("REJECT_FUTURE_DATES", "error")
+	withConfig(t, nil)
+
+	_, err := getSummaries(dated, summaryOptions{asOf: datedAsOf})
+	if !errors.Is(err, ErrFutureDate) || !strings.Contains(err.Error(), "transaction 2") {
+		t.Errorf("getSummaries error = %v, want ErrFutureDate for transaction 2", err)
+	}
+
+	sm, err := getSummaries(dated, summaryOptions{asOf: datedAsOf, lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.RowErrors) != 1 || sm.RowErrors[0].ID != "2" || sm.DebitTotal != -40 {
+		t.Errorf("lenient RowErrors, debits = %+v, %v, want transaction 2 recorded and left out", sm.RowErrors, sm.DebitTotal)
+	}
+
+	t.Setenv("REJECT_FUTURE_DATES", "reject")
+	withConfig(t, nil)
+	if _, err := getSummaries(dated, summaryOptions{asOf: datedAsOf}); err == nil || !strings.Contains(err.Error(), "REJECT_FUTURE_DATES") {
+		t.Errorf("getSummaries error = %v, want the mode rejected", err)
+	}
+}
+
+func TestReadCSVEmptyFile(t *testing.T) {
+	withConfig(t, nil)
+
+	if _, err := readCSV(strings.NewReader("")); !errors.Is(err, ErrEmptyFile) {
+		t.Errorf("readCSV error = %v, want ErrEmptyFile", err)
+	}
+}
+
+func TestReadCSVMalformedHeader(t *testing.T) {
+	withConfig(t, nil)
+
+	_, err := readCSV(strings.NewReader("Id,\"Date,Transaction\n0,7/15,+60.5\n"))
+	var perr *csv.ParseError
+	if !errors.As(err, &perr) || errors.Is(err, ErrEmptyFile) || !strings.Contains(err.Error(), "header row") {
+		t.Errorf("readCSV error = %v, want a parse error reading the header row", err)
+	}
+}
+
+// raggedCSV has a row that drops the trailing Description column and one that adds a column.
+const raggedCSV = "Id,Date,Transaction,Description\n0,7/15,+60.5\n1,7/28,-10.3,Coffee,ref 123\n"
+
+func TestReadCSVVariableFields(t *testing.T) {
+	withConfig(t, nil)
+	var perr *csv.ParseError
+	if _, err := readCSV(strings.NewReader(raggedCSV)); !errors.As(err, &perr) {
+		t.Errorf("readCSV error = %v, want the field count rejected by default", err)
+	}
+
+	t.Setenv("VARIABLE_FIELDS", "true")
+	withConfig(t, nil)
+	ts, err := readCSV(strings.NewReader(raggedCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 || ts[0].Transaction != "+60.5" || ts[0].Description != "" || ts[1].Description != "Coffee" {
+		t.Errorf("readCSV = %+v, want both rows with the columns they have", ts)
+	}
+
+	// a row still has to reach every column we read
+	if _, err := readCSV(strings.NewReader(raggedCSV + "2,8/2\n")); !errors.Is(err, ErrShortRow) {
+		t.Errorf("readCSV error = %v, want ErrShortRow for a row without a Transaction", err)
+	}
+}
+
+func TestReadCSVMaxRows(t *testing.T) {
+	content := csvFixture{}.build(sampleTransactions)
+
+	for max, wantErr := range map[string]bool{"": false, "0": false, "4": false, "3": true} {
+		t.Setenv("MAX_ROWS", max)
+		withConfig(t, nil)
+
+		ts, err := readCSV(strings.NewReader(content))
+		if wantErr {
+			if !errors.Is(err, ErrTooManyRows) || len(ts) != 0 {
+				t.Errorf("MAX_ROWS=%s: readCSV = %d rows, %v, want ErrTooManyRows", max, len(ts), err)
+			}
+			continue
+		}
+		if err != nil || len(ts) != 4 {
+			t.Errorf("MAX_ROWS=%s: readCSV = %d rows, %v, want all 4", max, len(ts), err)
+		}
+	}
+}
+
+func TestGetMonthStraySeparators(t *testing.T) {
+	names := languages[defaultLanguage].Months
+	for in, want := range map[string]string{
+		"6/1":           "June",
+		"/6/1":          "June",
+		"6/1/":          "June",
+		" 6/1 ":         "June",
+		"6//1":          "June",
+		" 6 / 1 / 2021": "June 2021",
+		"//6/1/2021/":   "June 2021",
+	} {
+		if got, err := getMonth(in, names); err != nil || got != want {
+			t.Errorf("getMonth(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "/", " / ", "13/1", "June/1"} {
+		if got, err := getMonth(in, names); err == nil {
+			t.Errorf("getMonth(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestNormalizeAmount(t *testing.T) {
+	for in, want := range map[string]struct {
+		amount string
+		side   float64
+	}{
+		"60.50":     {"60.50", 0},
+		"-60.50":    {"-60.50", 0},
+		"60.50-":    {"-60.50", 0},
+		" 60.50- ":  {"-60.50", 0},
+		"60.50 DR":  {"60.50", -1},
+		"60.50dr":   {"60.50", -1},
+		"60.50 CR":  {"60.50", 1},
+		"-60.50 CR": {"-60.50", 1},
+		"-":         {"-", 0},
+		"-60.50-":   {"-60.50-", 0},
+	} {
+		if amt, side := normalizeAmount(in); amt != want.amount || side != want.side {
+			t.Errorf("normalizeAmount(%q) = %q, %v, want %q, %v", in, amt, side, want.amount, want.side)
+		}
+	}
+}
+
+func TestParseRowTrailingSigns(t *testing.T) {
+	for _, sign := range []float64{1, -1} {
+		rf := rowFormat{sign: sign}
+		for in, want := range map[string]float64{
+			"60.50-": -60.5 * sign,
+			// DR and CR say which side they are on whatever the sign convention or a stray sign
+			"60.50 DR":  -60.5,
+			"60.50 CR":  60.5,
+			"-60.50 CR": 60.5,
+		} {
+			if got, _, err := parseRow(TransactionCSV{ID: "0", Date: "7/15", Transaction: in}, rf); err != nil || got != want {
+				t.Errorf("sign %v: parseRow(%q) = %v, %v, want %v", sign, in, got, err, want)
+			}
+		}
+	}
+
+	if _, _, err := parseRow(TransactionCSV{ID: "0", Date: "7/15", Transaction: "-60.50-"}, rowFormat{sign: 1}); err == nil {
+		t.Error("parseRow accepted an amount with two signs")
+	}
+}
+
+// multilineCSV has a quoted description that spans two lines, with a comma in it.
+const multilineCSV = "Id,Date,Transaction,Description\n0,7/15,+60.5,\"Coffee,\nand cake\"\n1,7/28,-10.3,Rent\n"
+
+func TestReadCSVEmbeddedNewline(t *testing.T) {
+	withConfig(t, nil)
+
+	ts, err := readCSV(strings.NewReader(multilineCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 || ts[0].Description != "Coffee,\nand cake" || ts[1].ID != "1" || ts[1].Row != 2 {
+		t.Fatalf("readCSV = %+v, want the description kept whole and the next row numbered 2", ts)
+	}
+
+	// rows are still numbered from the header, but the line is where the row really starts
+	t.Setenv("VARIABLE_FIELDS", "true")
+	withConfig(t, nil)
+	_, err = readCSV(strings.NewReader(multilineCSV + "2,8/2\n"))
+	if !errors.Is(err, ErrShortRow) || !strings.Contains(err.Error(), "row 3 (line 5)") {
+		t.Errorf("readCSV error = %v, want row 3 on line 5", err)
+	}
+}
+
+func TestGetSummariesDayOfMonthSkipsYearless(t *testing.T) {
+	withConfig(t, nil)
+
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15/2021", Transaction: "+60.5"},
+		{ID: "1", Date: "8/15/2021", Transaction: "-10.3"},
+		{ID: "2", Date: "7/15", Transaction: "-20.46"},
+		{ID: "3", Date: "8/13", Transaction: "+10"},
+	}
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]DayActivity{15: {Day: 15, Count: 2, Net: 50.2}}
+	if len(sm.DailyTransactions) != 1 || sm.DailyTransactions[15].Count != 2 || math.Abs(sm.DailyTransactions[15].Net-50.2) > 1e-9 {
+		t.Errorf("DailyTransactions = %v, want %v from the dated rows only", sm.DailyTransactions, want)
+	}
+	// the rows without a year still count everywhere else
+	if sm.CreditTotal != 70.5 || sm.DebitTotal != -30.76 {
+		t.Errorf("credits, debits = %v, %v, want 70.5, -30.76", sm.CreditTotal, sm.DebitTotal)
+	}
+}
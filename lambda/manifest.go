@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// manifestSuffix marks an uploaded object as a manifest instead of a CSV.
+const manifestSuffix = ".manifest.json"
+
+// manifest lists CSVs that are summarized together into one email, e.g.
+//
+//	{"bucket": "statements", "keys": ["csv/2021-10-01.csv", "csv/2021-10-02.csv"]}
+//
+// The files are read in the order listed and their transactions concatenated.
+type manifest struct {
+	// Bucket holds the listed files. It defaults to the manifest's own bucket.
+	Bucket string   `json:"bucket,omitempty"`
+	Keys   []string `json:"keys"`
+}
+
+func isManifest(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix)
+}
+
+// getManifest downloads and parses the manifest at `obj`.
+func getManifest(svc s3iface.S3API, obj s3Object) (manifest, error) {
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return manifest{}, fmt.Errorf("loading manifest %s: %w", obj, err)
+	}
+	defer out.Body.Close()
+
+	var m manifest
+	dec := json.NewDecoder(out.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return manifest{}, parseError{fmt.Errorf("%w: %s: %v", ErrBadManifest, obj, err)}
+	}
+	if len(m.Keys) == 0 {
+		return manifest{}, parseError{fmt.Errorf("%w: %s lists no keys", ErrBadManifest, obj)}
+	}
+	if m.Bucket == "" {
+		m.Bucket = obj.Bucket
+	}
+
+	return m, nil
+}
+
+// readManifestFiles downloads and parses every CSV listed in the manifest at `obj`, returning all of
+// their transactions. Each listed file is held to ALLOWED_SOURCES like an uploaded one.
+func readManifestFiles(ctx context.Context, sess *session.Session, obj s3Object) ([]TransactionCSV, error) {
+	m, err := getManifest(s3.New(sourceSession(sess)), obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var ts []TransactionCSV
+	for _, key := range m.Keys {
+		if err := checkSource(m.Bucket, key, allowedSources()); err != nil {
+			return nil, err
+		}
+
+		part, err := downloadCSV(ctx, sess, s3Object{Bucket: m.Bucket, Key: key}, 0)
+		if err != nil {
+			var ae awserr.Error
+			if errors.As(err, &ae) && ae.Code() == s3.ErrCodeNoSuchKey {
+				return nil, fmt.Errorf("%w: s3://%s/%s listed in %s", ErrManifestKeyMissing, m.Bucket, key, obj.Key)
+			}
+			return nil, fmt.Errorf("reading s3://%s/%s from manifest: %w", m.Bucket, key, err)
+		}
+		ts = append(ts, part...)
+	}
+
+	f := obj.fields()
+	f["files"] = len(m.Keys)
+	f["rows"] = len(ts)
+	logJSON("info", "read manifest", f)
+
+	return ts, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+// summaryOutput is the JSON document written to `summaries/<key>.json` for downstream consumers.
+type summaryOutput struct {
+	Bucket string
+	Key    string
+	ETag   string
+	// Account is set for the per-account summaries of multi account files.
+	Account string `json:",omitempty"`
+	Summary Summaries
+}
+
+// defaultAccountSummaryKey is where each account's summary goes when ACCOUNT_SUMMARY_KEY isn't set.
+const defaultAccountSummaryKey = "summaries/{account}/{period}.json"
+
+// writeSummary stores `sm` for `obj` next to the source object. With a `signKey` the HMAC-SHA256 of
+// the uncompressed JSON is written alongside it to `summaries/<key>.json.sig` as lower case hex.
+func writeSummary(up s3manageriface.UploaderAPI, obj s3Object, sm Summaries, signKey []byte) error {
+	return writeSummaryTo(up, obj.Bucket, "summaries/"+obj.Key+".json", summaryOutput{Bucket: obj.Bucket, Key: obj.Key, ETag: obj.ETag, Summary: sm}, signKey)
+}
+
+// writeAccountSummary stores the summary `sm` of account `id` in `obj` as an object of its own, at
+// ACCOUNT_SUMMARY_KEY in the source bucket, so each account's consumers can fetch just theirs.
+func writeAccountSummary(up s3manageriface.UploaderAPI, obj s3Object, id string, sm Summaries, signKey []byte, at time.Time) error {
+	key := accountSummaryKey(envDefault("ACCOUNT_SUMMARY_KEY", defaultAccountSummaryKey), obj, id, sm, at)
+	return writeSummaryTo(up, obj.Bucket, key, summaryOutput{Bucket: obj.Bucket, Key: obj.Key, ETag: obj.ETag, Account: id, Summary: sm}, signKey)
+}
+
+// accountSummaryKey fills in the placeholders of `tmpl`: `{account}` is the account id, `{key}` the
+// source key, `{date}` the processing date and `{period}` the month of the account's last dated
+// transaction as `YYYY-MM`, or the processing month when none has a full date. The account id is
+// escaped so it can't add path segments.
+func accountSummaryKey(tmpl string, obj s3Object, id string, sm Summaries, at time.Time) string {
+	return strings.NewReplacer(
+		"{account}", url.PathEscape(id),
+		"{key}", obj.Key,
+		"{date}", at.UTC().Format("2006-01-02"),
+		"{period}", summaryPeriod(sm, at),
+	).Replace(tmpl)
+}
+
+// summaryPeriod is the month `sm` covers as `YYYY-MM`, that of its last dated transaction, or the
+// month of `at` when none has a full date.
+func summaryPeriod(sm Summaries, at time.Time) string {
+	if last, ok := getDate(sm.LastDate); ok && last.Year() > 0 {
+		return last.Format("2006-01")
+	}
+	return at.UTC().Format("2006-01")
+}
+
+// writeSummaryTo uploads `so` to `key`, signed like writeSummary when there is a `signKey`.
+func writeSummaryTo(up s3manageriface.UploaderAPI, bucket, key string, so summaryOutput, signKey []byte) error {
+	b, err := json.Marshal(so)
+	if err != nil {
+		return err
+	}
+
+	if err := writeOutput(up, bucket, key, "application/json", b, features().CompressOutput); err != nil {
+		return err
+	}
+	if signKey == nil {
+		return nil
+	}
+
+	return writeOutput(up, bucket, key+".sig", "text/plain", []byte(sign(signKey, b)), false)
+}
+
+// writeOutput uploads `body` to `key`. With `compress` it is gzipped on the way and tagged with
+// `Content-Encoding: gzip`, so clients that honor the header still read plain JSON.
+func writeOutput(up s3manageriface.UploaderAPI, bucket, key, contentType string, body []byte, compress bool) error {
+	in := &s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        bytes.NewReader(body),
+	}
+	if !compress {
+		_, err := up.Upload(in)
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		if _, err := zw.Write(body); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+
+	in.Body = pr
+	in.ContentEncoding = aws.String("gzip")
+	_, err := up.Upload(in)
+	// unblock the writer if the upload gave up before reading everything
+	pr.Close()
+	return err
+}
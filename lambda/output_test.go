@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAccountSummaryKey(t *testing.T) {
+	obj := s3Object{Bucket: "in", Key: "csv/multi.csv"}
+	at := time.Date(2021, 9, 3, 23, 30, 0, 0, time.FixedZone("CST", -6*3600))
+	dated := Summaries{LastDate: "8/13/2021"}
+
+	for _, tc := range []struct {
+		tmpl, id string
+		sm       Summaries
+		want     string
+	}{
+		{defaultAccountSummaryKey, "acct-1", dated, "summaries/acct-1/2021-08.json"},
+		// without a full date the processing month is used, in UTC
+		{defaultAccountSummaryKey, "acct-1", Summaries{LastDate: "8/13"}, "summaries/acct-1/2021-09.json"},
+		{"out/{date}/{key}/{account}.json", "acct-1", dated, "out/2021-09-04/csv/multi.csv/acct-1.json"},
+		// an account id can't add path segments
+		{defaultAccountSummaryKey, "../acct/2", dated, "summaries/..%2Facct%2F2/2021-08.json"},
+	} {
+		if got := accountSummaryKey(tc.tmpl, obj, tc.id, tc.sm, at); got != tc.want {
+			t.Errorf("accountSummaryKey(%q, %q) = %q, want %q", tc.tmpl, tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestWriteAccountSummaries(t *testing.T) {
+	withConfig(t, nil)
+	ids, groups, err := groupByAccount(accountTransactions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeS3{}
+	obj := s3Object{Bucket: "in", Key: "multi.csv", ETag: "abc"}
+	at := time.Date(2021, 9, 3, 0, 0, 0, 0, time.UTC)
+	for _, id := range ids {
+		sm, err := getSummaries(groups[id], summaryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeAccountSummary(f, obj, id, sm, nil, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := f.keys("in", "")
+	if len(keys) != 3 {
+		t.Fatalf("stored %q, want one summary per account", keys)
+	}
+	for id, credits := range map[string]float64{"acct-1": 60.5, "acct-2": 0, "acct-3": 10} {
+		o, ok := f.get("in", "summaries/"+id+"/2021-09.json")
+		if !ok {
+			t.Errorf("no summary for %s in %q", id, keys)
+			continue
+		}
+		var so summaryOutput
+		if err := json.Unmarshal(o.Body, &so); err != nil {
+			t.Fatal(err)
+		}
+		if so.Account != id || so.Key != "multi.csv" || so.ETag != "abc" || so.Summary.CreditTotal != credits {
+			t.Errorf("%s summary = %+v, want its own totals and the source object", id, so)
+		}
+	}
+}
+
+func TestWriteAccountSummaryKeySetting(t *testing.T) {
+	t.Setenv("ACCOUNT_SUMMARY_KEY", "accounts/{account}/{key}.json")
+	withConfig(t, nil)
+
+	f := &fakeS3{}
+	if err := writeAccountSummary(f, s3Object{Bucket: "in", Key: "multi.csv"}, "acct-1", Summaries{}, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.get("in", "accounts/acct-1/multi.csv.json"); !ok {
+		t.Errorf("stored %q, want the ACCOUNT_SUMMARY_KEY location", f.keys("in", ""))
+	}
+}
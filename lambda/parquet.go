@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// parquetMagic starts and ends every Parquet file.
+const parquetMagic = "PAR1"
+
+// isParquet reports whether `f`, downloaded from `key`, is a Parquet file rather than a CSV, by its
+// extension or, for exports that don't name them that way, by its leading magic bytes.
+func isParquet(key string, f *os.File) bool {
+	if strings.HasSuffix(strings.ToLower(key), ".parquet") {
+		return true
+	}
+
+	b := make([]byte, len(parquetMagic))
+	n, _ := f.ReadAt(b, 0)
+	return n == len(b) && string(b) == parquetMagic
+}
+
+// parquetFile adapts a downloaded file to the reader's source interface. The reader opens another
+// handle for every column it reads.
+type parquetFile struct {
+	*os.File
+}
+
+func (pf parquetFile) Open(string) (source.ParquetFile, error) {
+	f, err := os.Open(pf.Name())
+	if err != nil {
+		return nil, err
+	}
+	return parquetFile{f}, nil
+}
+
+func (pf parquetFile) Create(string) (source.ParquetFile, error) {
+	return nil, fmt.Errorf("parquet files are read only")
+}
+
+// readParquet reads the transactions in a Parquet file. Its top level columns are matched to our
+// fields by name exactly like a CSV header, so HEADER_ALIASES covers Parquet exports too. String,
+// integer, floating point, DECIMAL, DATE and TIMESTAMP columns are turned into the text a CSV of the
+// same data would have, so the rest of the pipeline can't tell the difference.
+func readParquet(f *os.File) ([]TransactionCSV, error) {
+	pr, err := reader.NewParquetColumnReader(parquetFile{f}, 1)
+	if err != nil {
+		return []TransactionCSV{}, fmt.Errorf("reading parquet footer: %w", err)
+	}
+	defer pr.ReadStop()
+
+	rows := pr.GetNumRows()
+	if rows == 0 {
+		return []TransactionCSV{}, fmt.Errorf("%w: no rows", ErrEmptyFile)
+	}
+	if maxRows := envInt("MAX_ROWS", 0); maxRows > 0 && rows > int64(maxRows) {
+		logJSON("error", "row limit exceeded", map[string]interface{}{"max_rows": maxRows, "rows": rows})
+		return []TransactionCSV{}, fmt.Errorf("%w: more than %d rows", ErrTooManyRows, maxRows)
+	}
+
+	// nested columns have no CSV equivalent, so only the top level ones work as a header
+	sh := pr.SchemaHandler
+	var header []string
+	var elems []*parquet.SchemaElement
+	var paths []string
+	for _, p := range sh.ValueColumns {
+		if len(common.StrToPath(p)) != 2 {
+			continue
+		}
+		i := sh.MapIndex[p]
+		header = append(header, sh.Infos[i].ExName)
+		elems = append(elems, sh.SchemaElements[i])
+		paths = append(paths, p)
+	}
+	aliases, err := headerAliases()
+	if err != nil {
+		return []TransactionCSV{}, err
+	}
+	cols := mapColumns(header, aliases)
+
+	trim := envBoolDefault("TRIM_FIELDS", true)
+	column := func(i int) ([]string, error) {
+		if i < 0 {
+			return nil, nil
+		}
+		if i >= len(paths) {
+			return nil, fmt.Errorf("%w: file has %d columns", ErrShortRow, len(paths))
+		}
+		vals, _, _, err := pr.ReadColumnByPath(paths[i], rows)
+		if err != nil {
+			return nil, fmt.Errorf("reading parquet column %q: %w", header[i], err)
+		}
+		out := make([]string, rows)
+		for r := range out {
+			if r >= len(vals) {
+				break
+			}
+			s, err := parquetText(vals[r], elems[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %q: %w", r+1, header[i], err)
+			}
+			if trim {
+				s = strings.TrimSpace(s)
+			}
+			out[r] = s
+		}
+		return out, nil
+	}
+
+	var data [7][]string
+	for i, c := range []int{cols.ID, cols.Date, cols.Transaction, cols.Status, cols.Description, cols.Account, cols.Category} {
+		if data[i], err = column(c); err != nil {
+			return []TransactionCSV{}, err
+		}
+	}
+	at := func(col []string, r int) string {
+		if col == nil {
+			return ""
+		}
+		return col[r]
+	}
+
+	ts := make([]TransactionCSV, rows)
+	for r := range ts {
+		ts[r] = TransactionCSV{
+			ID:          at(data[0], r),
+			Date:        at(data[1], r),
+			Transaction: at(data[2], r),
+			Status:      at(data[3], r),
+			Description: at(data[4], r),
+			Account:     at(data[5], r),
+			Category:    at(data[6], r),
+			Row:         r + 1,
+		}
+	}
+	if err := checkColumns(ts, header, cols); err != nil {
+		return []TransactionCSV{}, err
+	}
+
+	return ts, nil
+}
+
+// parquetText writes a Parquet value the way it would look in one of our CSVs. Nulls are empty.
+func parquetText(v interface{}, el *parquet.SchemaElement) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+
+	switch el.GetConvertedType() {
+	case parquet.ConvertedType_DATE:
+		if d, ok := v.(int32); ok {
+			return csvDate(time.Unix(int64(d)*86400, 0)), nil
+		}
+	case parquet.ConvertedType_TIMESTAMP_MILLIS:
+		if ms, ok := v.(int64); ok {
+			return csvDate(time.UnixMilli(ms)), nil
+		}
+	case parquet.ConvertedType_TIMESTAMP_MICROS:
+		if us, ok := v.(int64); ok {
+			return csvDate(time.UnixMicro(us)), nil
+		}
+	case parquet.ConvertedType_DECIMAL:
+		switch n := v.(type) {
+		case int32:
+			return decimalText(big.NewInt(int64(n)), el.GetScale()), nil
+		case int64:
+			return decimalText(big.NewInt(n), el.GetScale()), nil
+		}
+		return "", fmt.Errorf("unsupported DECIMAL encoding %T", v)
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int32:
+		return strconv.FormatInt(int64(x), 10), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value type %T", v)
+}
+
+// csvDate writes `t` as the `M/D/YYYY` dates our CSVs use.
+func csvDate(t time.Time) string {
+	return t.UTC().Format("1/2/2006")
+}
+
+// decimalText writes the unscaled DECIMAL `n` with `scale` digits after the point.
+func decimalText(n *big.Int, scale int32) string {
+	if scale <= 0 {
+		return n.String()
+	}
+	return new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)).FloatString(int(scale))
+}
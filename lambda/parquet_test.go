@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is how a partner's Parquet export lays out a transaction, with a DATE column, a DECIMAL
+// amount in cents and an optional description.
+type parquetRow struct {
+	ID          string  `parquet:"name=Id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Date        int32   `parquet:"name=Date, type=INT32, convertedtype=DATE"`
+	Amount      int64   `parquet:"name=Transaction, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	Description *string `parquet:"name=Description, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+// parquetFixture writes `rows` to a Parquet file named `name` in a temporary directory and opens it
+// for reading.
+func parquetFixture(t *testing.T, name string, rows []parquetRow) *os.File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := writer.NewParquetWriterFromWriter(out, new(parquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := pw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// parquetDay is `m/d/2021` as a Parquet DATE, in days since the epoch.
+func parquetDay(m time.Month, d int) int32 {
+	return int32(time.Date(2021, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+func TestReadParquet(t *testing.T) {
+	withConfig(t, nil)
+
+	coffee := "Coffee"
+	f := parquetFixture(t, "export.bin", []parquetRow{
+		{ID: "0", Date: parquetDay(7, 15), Amount: 6050},
+		{ID: "1", Date: parquetDay(7, 28), Amount: -1030, Description: &coffee},
+		{ID: "2", Date: parquetDay(8, 2), Amount: -2046},
+		{ID: "3", Date: parquetDay(8, 13), Amount: 1000},
+	})
+	if !isParquet("export.bin", f) {
+		t.Fatal("isParquet = false for a Parquet file without the extension")
+	}
+
+	ts, err := readParquet(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 4 || ts[0].Date != "7/15/2021" || ts[0].Transaction != "60.50" || ts[1].Transaction != "-10.30" || ts[1].Description != "Coffee" || ts[2].Description != "" {
+		t.Fatalf("readParquet = %+v, want the rows as a CSV would have them", ts)
+	}
+
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.CreditTotal != 70.5 || sm.DebitTotal != -30.76 {
+		t.Errorf("credits, debits = %v, %v, want 70.5, -30.76 like the CSV", sm.CreditTotal, sm.DebitTotal)
+	}
+}
+
+func TestReadParquetLimits(t *testing.T) {
+	withConfig(t, nil)
+	if _, err := readParquet(parquetFixture(t, "empty.parquet", nil)); !errors.Is(err, ErrEmptyFile) {
+		t.Errorf("readParquet error = %v, want ErrEmptyFile", err)
+	}
+
+	t.Setenv("MAX_ROWS", "1")
+	withConfig(t, nil)
+	f := parquetFixture(t, "two.parquet", []parquetRow{{ID: "0", Amount: 1}, {ID: "1", Amount: 2}})
+	if _, err := readParquet(f); !errors.Is(err, ErrTooManyRows) {
+		t.Errorf("readParquet error = %v, want ErrTooManyRows", err)
+	}
+}
+
+func TestIsParquetCSV(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString(csvFixture{}.build(sampleTransactions))
+
+	if isParquet("july.csv", f) {
+		t.Error("isParquet = true for a CSV")
+	}
+	if !isParquet("july.PARQUET", f) {
+		t.Error("isParquet = false for a .parquet key")
+	}
+}
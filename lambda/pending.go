@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+// pendingKey is where the summary of `obj` waits when its email couldn't be sent.
+func pendingKey(obj s3Object) string {
+	return "pending/" + obj.Key + ".json"
+}
+
+// savePending stores `sm` so that a retry of `obj` can send it without parsing the file again.
+func savePending(up s3manageriface.UploaderAPI, obj s3Object, sm Summaries) error {
+	b, err := json.Marshal(summaryOutput{Bucket: obj.Bucket, Key: obj.Key, ETag: obj.ETag, Summary: sm})
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(up, obj.Bucket, pendingKey(obj), "application/json", b, false)
+}
+
+// loadPending returns the summary saved by savePending, if any. One saved for a different version of
+// the object is ignored.
+func loadPending(svc s3iface.S3API, obj s3Object) (Summaries, bool, error) {
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(pendingKey(obj)),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return Summaries{}, false, nil
+		}
+		return Summaries{}, false, err
+	}
+	defer out.Body.Close()
+
+	var so summaryOutput
+	if err := json.NewDecoder(out.Body).Decode(&so); err != nil {
+		return Summaries{}, false, err
+	}
+	if so.ETag != obj.ETag {
+		return Summaries{}, false, nil
+	}
+
+	return so.Summary, true, nil
+}
+
+// clearPending removes the saved summary once it has been sent.
+func clearPending(svc s3iface.S3API, obj s3Object) error {
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(pendingKey(obj)),
+	})
+	return err
+}
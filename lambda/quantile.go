@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// AmountPercentiles are estimates of the size of a typical transaction, taken over absolute amounts
+// so credits and debits are ranked together.
+type AmountPercentiles struct {
+	Median float64
+	P90    float64
+	P95    float64
+}
+
+// summaryQuantiles are the percentiles tracked for AmountPercentiles, in field order.
+var summaryQuantiles = []float64{0.5, 0.9, 0.95}
+
+// p2Quantile estimates a single quantile of a stream with the P² algorithm (Jain and Chlamtac, 1985),
+// which keeps five markers instead of every value. Memory is constant however long the file is, at
+// the cost of being an estimate: on smooth distributions it is typically within a percent or two of
+// the exact value, and on small or very lumpy inputs it can be further off. So until p2WarmUp values
+// are in, it keeps them all and reports the exact nearest rank, then seeds the markers from them.
+//
+// The fields are exported so that the state survives a checkpoint.
+type p2Quantile struct {
+	P     float64
+	Count int
+	// Q are the marker heights, N their actual positions, Want the desired positions and Step how
+	// far each desired position moves per value.
+	Q, N, Want, Step [5]float64
+	// Exact holds every value during the warm-up, and is empty after it.
+	Exact []float64 `json:",omitempty"`
+}
+
+// p2WarmUp is how many values a p2Quantile keeps exactly before it starts estimating. Most statement
+// files are shorter than this, so their percentiles are exact.
+const p2WarmUp = 100
+
+func newP2Quantile(p float64) p2Quantile {
+	return p2Quantile{
+		P:    p,
+		N:    [5]float64{1, 2, 3, 4, 5},
+		Want: [5]float64{1, 1 + 2*p, 1 + 4*p, 3 + 2*p, 5},
+		Step: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// Add records `x`.
+func (e *p2Quantile) Add(x float64) {
+	if e.Count < p2WarmUp {
+		e.Exact = append(e.Exact, x)
+		e.Count++
+		if e.Count == p2WarmUp {
+			e.seed()
+		}
+		return
+	}
+	e.Count++
+
+	// find the cell x falls in, stretching the outer markers if it's a new extreme
+	var k int
+	switch {
+	case x < e.Q[0]:
+		e.Q[0] = x
+		k = 0
+	case x >= e.Q[4]:
+		e.Q[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3 && x >= e.Q[k+1]; k++ {
+		}
+	}
+
+	for i := k + 1; i < 5; i++ {
+		e.N[i]++
+	}
+	for i := range e.Want {
+		e.Want[i] += e.Step[i]
+	}
+
+	// nudge the middle markers towards where they should be
+	for i := 1; i < 4; i++ {
+		d := e.Want[i] - e.N[i]
+		if (d >= 1 && e.N[i+1]-e.N[i] > 1) || (d <= -1 && e.N[i-1]-e.N[i] < -1) {
+			d = math.Copysign(1, d)
+			q := e.parabolic(i, d)
+			if e.Q[i-1] < q && q < e.Q[i+1] {
+				e.Q[i] = q
+			} else {
+				e.Q[i] = e.linear(i, d)
+			}
+			e.N[i] += d
+		}
+	}
+}
+
+// seed places the markers on the warm-up values at the ranks they would have reached, then lets go of
+// the values.
+func (e *p2Quantile) seed() {
+	vs := e.Exact
+	sort.Float64s(vs)
+	n := float64(len(vs))
+	prev := -1.0
+	for i, f := range [5]float64{0, e.P / 2, e.P, (1 + e.P) / 2, 1} {
+		// markers need distinct positions, and room left for the ones after them
+		pos := math.Min(math.Max(math.Round((n-1)*f), prev+1), n-float64(5-i))
+		e.Q[i], e.N[i], e.Want[i] = vs[int(pos)], pos+1, (n-1)*f+1
+		prev = pos
+	}
+	e.Exact = nil
+}
+
+func (e *p2Quantile) parabolic(i int, d float64) float64 {
+	return e.Q[i] + d/(e.N[i+1]-e.N[i-1])*
+		((e.N[i]-e.N[i-1]+d)*(e.Q[i+1]-e.Q[i])/(e.N[i+1]-e.N[i])+
+			(e.N[i+1]-e.N[i]-d)*(e.Q[i]-e.Q[i-1])/(e.N[i]-e.N[i-1]))
+}
+
+func (e *p2Quantile) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.Q[i] + d*(e.Q[j]-e.Q[i])/(e.N[j]-e.N[i])
+}
+
+// Value returns the current estimate, or 0 before anything was added.
+func (e *p2Quantile) Value() float64 {
+	if e.Count == 0 {
+		return 0
+	}
+	if e.Count < p2WarmUp {
+		return nearestRank(e.Exact, e.P)
+	}
+	return e.Q[2]
+}
+
+// nearestRank returns the exact `p` quantile of `vs` by the nearest rank method.
+func nearestRank(vs []float64, p float64) float64 {
+	vs = append([]float64(nil), vs...)
+	sort.Float64s(vs)
+	i := int(math.Ceil(p*float64(len(vs)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return vs[i]
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestP2QuantileExactDuringWarmUp(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 4, 5, 6, 17, 50, p2WarmUp - 1} {
+		vs := make([]float64, n)
+		for i := range vs {
+			// lumpy on purpose, which is where the markers are furthest off
+			vs[i] = math.Round(r.ExpFloat64()*1000) / 100
+		}
+		for _, p := range summaryQuantiles {
+			e := newP2Quantile(p)
+			for _, v := range vs {
+				e.Add(v)
+			}
+			if got, want := e.Value(), nearestRank(vs, p); got != want {
+				t.Errorf("n=%d p=%v: Value = %v, want the exact %v", n, p, got, want)
+			}
+		}
+	}
+}
+
+func TestP2QuantileEstimate(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	vs := make([]float64, 20000)
+	for i := range vs {
+		vs[i] = r.Float64() * 500
+	}
+	for _, p := range summaryQuantiles {
+		e := newP2Quantile(p)
+		for _, v := range vs {
+			e.Add(v)
+		}
+		want := nearestRank(vs, p)
+		if got := e.Value(); math.Abs(got-want)/want > 0.02 {
+			t.Errorf("p=%v: Value = %v, want within 2%% of the exact %v", p, got, want)
+		}
+	}
+}
+
+func TestP2QuantileCheckpointed(t *testing.T) {
+	vs := make([]float64, 3*p2WarmUp)
+	for i := range vs {
+		vs[i] = float64((i * 37) % 101)
+	}
+	for _, split := range []int{p2WarmUp / 2, 2 * p2WarmUp} {
+		whole, resumed := newP2Quantile(0.9), newP2Quantile(0.9)
+		for _, v := range vs {
+			whole.Add(v)
+		}
+		for _, v := range vs[:split] {
+			resumed.Add(v)
+		}
+		b, err := json.Marshal(resumed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed = p2Quantile{}
+		if err := json.Unmarshal(b, &resumed); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range vs[split:] {
+			resumed.Add(v)
+		}
+		if resumed.Value() != whole.Value() {
+			t.Errorf("split at %d: resumed Value = %v, want %v", split, resumed.Value(), whole.Value())
+		}
+	}
+}
+
+func TestP2QuantileEmpty(t *testing.T) {
+	e := newP2Quantile(0.5)
+	if v := e.Value(); v != 0 {
+		t.Errorf("Value = %v, want 0 before anything is added", v)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// parseError marks an error as coming from the contents of the file rather than from AWS or the
+// configuration, so retrying the same object can't fix it.
+type parseError struct {
+	err error
+}
+
+func (e parseError) Error() string { return e.err.Error() }
+
+func (e parseError) Unwrap() error { return e.err }
+
+// maxErrorMetadata caps the error recorded on a quarantined object, well under the 2 KB S3 allows for
+// all user metadata.
+const maxErrorMetadata = 1024
+
+// quarantineObject moves `obj` under QUARANTINE_PREFIX using a fresh session, since the run's own may
+// never have been created.
+func quarantineObject(obj s3Object, runErr error) (string, error) {
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return "", err
+	}
+	return quarantine(s3.New(sourceSession(sess)), obj, runErr)
+}
+
+// quarantine copies `obj` to the same key under QUARANTINE_PREFIX, `quarantine/` by default, with
+// `runErr` in its `error` metadata, and then deletes the original. It returns the new key. The copy
+// keeps the object's tags, content headers and user metadata, so tag before quarantining.
+func quarantine(svc s3iface.S3API, obj s3Object, runErr error) (string, error) {
+	key := envDefault("QUARANTINE_PREFIX", "quarantine/") + obj.Key
+
+	// replacing the metadata to add the error drops everything else S3 stores with it, so carry it over
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading the metadata of %s: %w", obj, err)
+	}
+
+	// user metadata travels as HTTP headers, which must be ASCII
+	msg := strconv.QuoteToASCII(runErr.Error())
+	msg = msg[1 : len(msg)-1]
+	if len(msg) > maxErrorMetadata {
+		msg = msg[:maxErrorMetadata]
+	}
+
+	metadata := map[string]*string{}
+	for k, v := range head.Metadata {
+		// the SDK capitalizes the names it reads back, and an earlier error is replaced by this one
+		if !strings.EqualFold(k, "error") {
+			metadata[k] = v
+		}
+	}
+	metadata["error"] = aws.String(msg)
+
+	if _, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:             aws.String(obj.Bucket),
+		Key:                aws.String(key),
+		CopySource:         aws.String((&url.URL{Path: obj.Bucket + "/" + obj.Key}).EscapedPath()),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		Metadata:           metadata,
+		ContentType:        head.ContentType,
+		ContentEncoding:    head.ContentEncoding,
+		ContentDisposition: head.ContentDisposition,
+		ContentLanguage:    head.ContentLanguage,
+		CacheControl:       head.CacheControl,
+	}); err != nil {
+		return "", fmt.Errorf("copying to s3://%s/%s: %w", obj.Bucket, key, err)
+	}
+
+	if _, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	}); err != nil {
+		return key, fmt.Errorf("deleting the original after copying to s3://%s/%s: %w", obj.Bucket, key, err)
+	}
+
+	return key, nil
+}
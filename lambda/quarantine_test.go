@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestQuarantineKeepsMetadata(t *testing.T) {
+	withConfig(t, nil)
+	store := &fakeS3{}
+	store.put("uploads", "csv/july.csv", storedObject{
+		Body:        []byte("Id,Date,Transaction\n"),
+		ContentType: "text/csv",
+		Metadata:    map[string]*string{"Partner": aws.String("acme"), "Error": aws.String("an earlier failure")},
+	})
+
+	key, err := quarantine(store, s3Object{Bucket: "uploads", Key: "csv/july.csv"}, parseError{errors.New("row 3: bad amount “x”")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "quarantine/csv/july.csv" {
+		t.Errorf("key = %q", key)
+	}
+	if _, ok := store.get("uploads", "csv/july.csv"); ok {
+		t.Error("the original is still there")
+	}
+
+	o, ok := store.get("uploads", key)
+	if !ok {
+		t.Fatalf("nothing at %s", key)
+	}
+	if o.ContentType != "text/csv" {
+		t.Errorf("Content-Type = %q, want the original's", o.ContentType)
+	}
+	if got := aws.StringValue(o.Metadata["Partner"]); got != "acme" {
+		t.Errorf("partner metadata = %q, want the original's", got)
+	}
+	if got := aws.StringValue(o.Metadata["error"]); got != `row 3: bad amount \u201cx\u201d` {
+		t.Errorf("error metadata = %q, want this run's error in ASCII", got)
+	}
+	if _, ok := o.Metadata["Error"]; ok || len(o.Metadata) != 2 {
+		t.Errorf("metadata = %v, want the earlier error replaced", o.Metadata)
+	}
+}
+
+func TestQuarantineMissingObject(t *testing.T) {
+	withConfig(t, nil)
+	store := &fakeS3{}
+
+	_, err := quarantine(store, s3Object{Bucket: "uploads", Key: "gone.csv"}, errors.New("bad"))
+	if err == nil || !strings.Contains(err.Error(), "gone.csv") {
+		t.Errorf("quarantine = %v, want an error naming the object", err)
+	}
+	if n := len(store.keys("uploads", "")); n != 0 {
+		t.Errorf("left %d objects behind", n)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Recipient is who receives the statement for an account.
+type Recipient struct {
+	AccountID string `dynamodbav:"AccountId"`
+	Email     string `dynamodbav:"Email"`
+	Name      string `dynamodbav:"Name"`
+	Locale    string `dynamodbav:"Locale"`
+	// AccountType is `personal` or `business`, from an optional `AccountType` attribute. Empty means
+	// ACCOUNT_TYPE decides.
+	AccountType string `dynamodbav:"AccountType"`
+	// Extra are custom template fields for this recipient, from an optional `Extra` map attribute.
+	Extra map[string]string `dynamodbav:"Extra"`
+}
+
+// RecipientLookup resolves the recipient for an account id. It returns ErrNoRecipient when the
+// account has no mapping.
+type RecipientLookup interface {
+	Lookup(accountID string) (Recipient, error)
+}
+
+// dynamoRecipients looks recipients up in a DynamoDB table keyed by the string attribute `AccountId`,
+// with `Email`, `Name` and `Locale` attributes. Results, including misses, are cached for the life of
+// the value, which is a single invocation. It is safe for concurrent use.
+type dynamoRecipients struct {
+	svc   dynamodbiface.DynamoDBAPI
+	table string
+
+	mu    sync.Mutex
+	cache map[string]Recipient
+}
+
+func newDynamoRecipients(svc dynamodbiface.DynamoDBAPI, table string) *dynamoRecipients {
+	return &dynamoRecipients{svc: svc, table: table, cache: make(map[string]Recipient)}
+}
+
+func (d *dynamoRecipients) Lookup(accountID string) (Recipient, error) {
+	d.mu.Lock()
+	rc, ok := d.cache[accountID]
+	d.mu.Unlock()
+	if ok {
+		if rc.Email == "" {
+			return Recipient{}, fmt.Errorf("%w: account %s", ErrNoRecipient, accountID)
+		}
+		return rc, nil
+	}
+
+	out, err := d.svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"AccountId": {S: aws.String(accountID)},
+		},
+	})
+	if err != nil {
+		return Recipient{}, fmt.Errorf("looking up recipient for account %s: %w", accountID, err)
+	}
+
+	if len(out.Item) > 0 {
+		if err := dynamodbattribute.UnmarshalMap(out.Item, &rc); err != nil {
+			return Recipient{}, fmt.Errorf("reading recipient for account %s: %w", accountID, err)
+		}
+	}
+	rc.AccountID = accountID
+	d.mu.Lock()
+	d.cache[accountID] = rc
+	d.mu.Unlock()
+
+	if rc.Email == "" {
+		return Recipient{}, fmt.Errorf("%w: account %s", ErrNoRecipient, accountID)
+	}
+	return rc, nil
+}
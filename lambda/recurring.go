@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultRecurringMonths is how many distinct months a charge must appear in when RECURRING_MIN_MONTHS
+// isn't set.
+const defaultRecurringMonths = 3
+
+// RecurringCharge is a description and amount that keeps coming back, like a subscription.
+type RecurringCharge struct {
+	Description string
+	Amount      float64
+	// Months is how many distinct months it appeared in.
+	Months int
+}
+
+// recurringSeen tracks the months one description and amount pair appeared in. The fields are
+// exported so that the state survives a checkpoint.
+type recurringSeen struct {
+	Description string
+	Amount      float64
+	Months      map[string]bool
+}
+
+// recurringKey groups transactions whose description differs only in case and spacing, and whose
+// amounts match to the cent.
+func recurringKey(desc string, amt float64) string {
+	return strings.ToLower(strings.Join(strings.Fields(desc), " ")) + "\x00" + strconv.FormatFloat(math.Round(amt*100)/100, 'f', 2, 64)
+}
+
+// recurring lists the pairs in `seen` that appeared in at least `min` months, most frequent first.
+func recurring(seen map[string]*recurringSeen, min int) []RecurringCharge {
+	var out []RecurringCharge
+	for _, rs := range seen {
+		if len(rs.Months) >= min {
+			out = append(out, RecurringCharge{Description: rs.Description, Amount: rs.Amount, Months: len(rs.Months)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Months != out[j].Months {
+			return out[i].Months > out[j].Months
+		}
+		return out[i].Description < out[j].Description
+	})
+
+	return out
+}
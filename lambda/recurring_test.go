@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// subscriptions has a charge in three months under varying case and spacing, one in two months, one
+// repeated within a month and one whose price changed.
+var subscriptions = []TransactionCSV{
+	{ID: "0", Date: "7/1", Transaction: "-15.99", Description: "Netflix"},
+	{ID: "1", Date: "7/5", Transaction: "-30", Description: "Gym"},
+	{ID: "2", Date: "7/9", Transaction: "-3.5", Description: "Coffee"},
+	{ID: "3", Date: "7/10", Transaction: "-3.5", Description: "Coffee"},
+	{ID: "4", Date: "8/1", Transaction: "-15.99", Description: " netflix "},
+	{ID: "5", Date: "8/5", Transaction: "-30", Description: "Gym"},
+	{ID: "6", Date: "9/1", Transaction: "-15.99", Description: "NETFLIX"},
+	{ID: "7", Date: "9/5", Transaction: "-35", Description: "Gym"},
+}
+
+func TestGetSummariesRecurring(t *testing.T) {
+	for _, tc := range []struct {
+		min  string
+		want []RecurringCharge
+	}{
+		{"", []RecurringCharge{{Description: "Netflix", Amount: -15.99, Months: 3}}},
+		{"2", []RecurringCharge{{Description: "Netflix", Amount: -15.99, Months: 3}, {Description: "Gym", Amount: -30, Months: 2}}},
+		{"4", nil},
+	} {
+		t.Setenv("RECURRING_MIN_MONTHS", tc.min)
+		withConfig(t, nil)
+
+		sm, err := getSummaries(subscriptions, summaryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sm.Recurring, tc.want) {
+			t.Errorf("RECURRING_MIN_MONTHS=%q: Recurring = %+v, want %+v", tc.min, sm.Recurring, tc.want)
+		}
+	}
+}
+
+func TestRenderEmailRecurring(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(subscriptions, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Subscriptions/Recurring:", "Netflix: -$15.99, 3 months"} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+	if strings.Contains(r.Body, "Gym:") {
+		t.Errorf("body lists a charge seen in only two months:\n%s", r.Body)
+	}
+}
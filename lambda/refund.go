@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// defaultRefundWindowDays is how long after a debit a matching credit still counts as its refund when
+// REFUND_WINDOW_DAYS isn't set.
+const defaultRefundWindowDays = 30
+
+// addDebit remembers a debit so a later credit can be matched to it as a refund.
+func (st *summaryState) addDebit(desc string, amt float64, date string) {
+	k := recurringKey(desc, -amt)
+	st.Debits[k] = append(st.Debits[k], date)
+}
+
+// takeRefund reports whether the credit `amt` on `date` refunds an earlier debit with the same
+// description and amount at most `window` days before it. A debit is only ever refunded once, so the
+// earliest match is used up. Rows without a readable date are never matched.
+func (st *summaryState) takeRefund(desc string, amt float64, date string, window int) bool {
+	dt, ok := getDate(date)
+	if !ok {
+		return false
+	}
+
+	k := recurringKey(desc, amt)
+	for i, d := range st.Debits[k] {
+		debit, ok := getDate(d)
+		if !ok || debit.After(dt) || dt.Sub(debit) > time.Duration(window)*24*time.Hour {
+			continue
+		}
+		st.Debits[k] = append(st.Debits[k][:i], st.Debits[k][i+1:]...)
+		if len(st.Debits[k]) == 0 {
+			delete(st.Debits, k)
+		}
+		return true
+	}
+	return false
+}
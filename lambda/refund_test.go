@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// refunds has a refund within a month, one six weeks later, a second refund of the same purchase, a
+// credit before its debit, and credits with nothing to match.
+var refunds = []TransactionCSV{
+	{ID: "0", Date: "7/1/2021", Transaction: "-50", Description: "Shoes"},
+	{ID: "1", Date: "7/1/2021", Transaction: "-200", Description: "Hotel"},
+	{ID: "2", Date: "7/2/2021", Transaction: "+15", Description: "Lamp"},
+	{ID: "3", Date: "7/3/2021", Transaction: "-15", Description: "Lamp"},
+	{ID: "4", Date: "7/20/2021", Transaction: "+50", Description: " shoes "},
+	{ID: "5", Date: "7/25/2021", Transaction: "+50", Description: "Shoes"},
+	{ID: "6", Date: "7/26/2021", Transaction: "+50"},
+	{ID: "7", Date: "8/15/2021", Transaction: "+200", Description: "Hotel"},
+}
+
+func TestGetSummariesRefunds(t *testing.T) {
+	for _, tc := range []struct {
+		window  string
+		refunds int
+		total   float64
+	}{
+		{"", 1, 50},
+		{"60", 2, 250},
+		{"10", 0, 0},
+	} {
+		t.Setenv("REFUND_WINDOW_DAYS", tc.window)
+		withConfig(t, nil)
+
+		sm, err := getSummaries(refunds, summaryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Refunds != tc.refunds || sm.RefundTotal != tc.total {
+			t.Errorf("REFUND_WINDOW_DAYS=%q: Refunds, RefundTotal = %d, %v, want %d, %v", tc.window, sm.Refunds, sm.RefundTotal, tc.refunds, tc.total)
+		}
+	}
+}
+
+func TestRenderEmailRefunds(t *testing.T) {
+	withConfig(t, nil)
+
+	sm, err := getSummaries(refunds, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(r.Body, "Refunds: 1, $50.00") {
+		t.Errorf("body is missing the refunds:\n%s", r.Body)
+	}
+
+	if body := renderSample(t, Recipient{}).Body; strings.Contains(body, "Refunds") {
+		t.Errorf("body shows refunds for a file without any:\n%s", body)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// uploadRendered stores the exact HTML body of `sent` at `rendered/<key>.html` next to the source
+// object, so support can see what a customer received. Emails for an account in a multi account file
+// go to `rendered/<key>/<account>.html`.
+func uploadRendered(svc s3iface.S3API, obj s3Object, sent sentEmail) error {
+	key := "rendered/" + obj.Key + ".html"
+	if sent.Account != "" {
+		key = "rendered/" + obj.Key + "/" + sent.Account + ".html"
+	}
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(obj.Bucket),
+		Key:         aws.String(key),
+		Body:        strings.NewReader(sent.Body),
+		ContentType: aws.String("text/html; charset=utf-8"),
+		Metadata: map[string]*string{
+			"recipient":   aws.String(sent.To),
+			"account":     aws.String(sent.Account),
+			"sent-at":     aws.String(sent.SentAt.Format(time.RFC3339)),
+			"message-id":  aws.String(sent.MessageID),
+			"provider-id": aws.String(sent.ProviderID),
+		},
+	})
+	return err
+}
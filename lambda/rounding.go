@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// roundingMode decides how monetary values are rounded to cents for display.
+type roundingMode string
+
+const (
+	// roundHalfUp rounds halves away from zero, which is what math.Round does.
+	roundHalfUp roundingMode = "half_up"
+	// roundHalfEven rounds halves to the nearest even cent, also known as banker's rounding.
+	roundHalfEven roundingMode = "half_even"
+	// roundDown truncates towards zero.
+	roundDown roundingMode = "down"
+	// roundUp rounds away from zero.
+	roundUp roundingMode = "up"
+)
+
+// getRoundingMode reads ROUNDING_MODE, defaulting to half_up.
+func getRoundingMode() (roundingMode, error) {
+	m := roundingMode(strings.ToLower(getenv("ROUNDING_MODE")))
+	switch m {
+	case "":
+		return roundHalfUp, nil
+	case roundHalfUp, roundHalfEven, roundDown, roundUp:
+		return m, nil
+	}
+
+	return "", fmt.Errorf("unsupported ROUNDING_MODE %q", m)
+}
+
+// cents rounds `v` to two decimal places.
+func (m roundingMode) cents(v float64) float64 {
+	// values like 1.005 are stored as 1.00499999..., so snap away the binary representation error
+	// before deciding which way a half goes
+	x := math.Round(v*100*1e6) / 1e6
+
+	switch m {
+	case roundHalfEven:
+		x = math.RoundToEven(x)
+	case roundDown:
+		x = math.Trunc(x)
+	case roundUp:
+		if x < 0 {
+			x = math.Floor(x)
+		} else {
+			x = math.Ceil(x)
+		}
+	default:
+		x = math.Round(x)
+	}
+
+	return x / 100
+}
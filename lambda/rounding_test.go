@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoundingModeCents(t *testing.T) {
+	for _, tc := range []struct {
+		mode roundingMode
+		v    float64
+		want float64
+	}{
+		{roundHalfUp, 0.005, 0.01},
+		{roundHalfUp, -0.005, -0.01},
+		{roundHalfUp, 0.015, 0.02},
+		{roundHalfUp, 0.025, 0.03},
+		{roundHalfEven, 0.005, 0},
+		{roundHalfEven, -0.005, 0},
+		{roundHalfEven, 0.015, 0.02},
+		{roundHalfEven, 0.025, 0.02},
+		{roundDown, 0.005, 0},
+		{roundDown, -0.005, 0},
+		{roundDown, 0.015, 0.01},
+		{roundDown, 0.025, 0.02},
+		{roundUp, 0.005, 0.01},
+		{roundUp, -0.005, -0.01},
+		{roundUp, 0.015, 0.02},
+		{roundUp, 0.025, 0.03},
+		// stored as 1.00499999..., but still a half
+		{roundHalfUp, 1.005, 1.01},
+		{roundDown, -20.468, -20.46},
+		{roundUp, -20.461, -20.47},
+	} {
+		if got := tc.mode.cents(tc.v); got != tc.want {
+			t.Errorf("%s cents(%v) = %v, want %v", tc.mode, tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestGetRoundingMode(t *testing.T) {
+	for v, want := range map[string]roundingMode{"": roundHalfUp, "half_up": roundHalfUp, "HALF_EVEN": roundHalfEven, "down": roundDown, "up": roundUp} {
+		t.Setenv("ROUNDING_MODE", v)
+		withConfig(t, nil)
+
+		if got, err := getRoundingMode(); err != nil || got != want {
+			t.Errorf("ROUNDING_MODE=%q: getRoundingMode = %q, %v, want %q", v, got, err, want)
+		}
+	}
+
+	t.Setenv("ROUNDING_MODE", "bankers")
+	withConfig(t, nil)
+	if _, err := getRoundingMode(); err == nil || !strings.Contains(err.Error(), "ROUNDING_MODE") {
+		t.Errorf("getRoundingMode error = %v, want the mode rejected", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// runSummary tallies what one invocation read and emailed, for the single line HandleRequest logs at
+// the end of every run whatever else is configured. It is safe for concurrent use, since multi account
+// files send from several workers.
+type runSummary struct {
+	mu     sync.Mutex
+	emails int
+	// transactions counts the rows the run summarized, whether they were emailed, stored for a digest
+	// or belong to a suppressed account.
+	transactions int
+	quarantined  bool
+	credits      kahanSum
+	debits       kahanSum
+	recipient    string
+	messageID    string
+}
+
+type runSummaryKey struct{}
+
+// withRunSummary returns a context carrying a new runSummary, which every emailSender made from it
+// records its sends into.
+func withRunSummary(ctx context.Context) (context.Context, *runSummary) {
+	rs := &runSummary{}
+	return context.WithValue(ctx, runSummaryKey{}, rs), rs
+}
+
+// runSummaryFrom returns the runSummary in `ctx`, or nil outside HandleRequest.
+func runSummaryFrom(ctx context.Context) *runSummary {
+	rs, _ := ctx.Value(runSummaryKey{}).(*runSummary)
+	return rs
+}
+
+// read adds `n` rows to the transactions summarized. A nil runSummary, like every method on one,
+// records nothing.
+func (rs *runSummary) read(n int) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.transactions += n
+}
+
+// quarantine marks the run as having moved its file to quarantine, which HandleRequest reports as
+// success so the file isn't retried.
+func (rs *runSummary) quarantine() {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.quarantined = true
+}
+
+// record adds the email `sent` with the summary `s` to the tally.
+func (rs *runSummary) record(s Summaries, sent sentEmail) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.emails++
+	rs.credits.Add(s.CreditTotal)
+	rs.debits.Add(s.DebitTotal)
+	rs.recipient, rs.messageID = sent.To, sent.MessageID
+}
+
+// log writes the tally for `obj`, with the error the run returned, as one info line, with a `summary` field that reads on its own in a
+// log search, e.g. `transactions=4 credits=70.50 debits=-30.76 net=39.74 recipient=a@example.com
+// message_id=<...>`. Amounts are rounded like the email but always have two decimals and no currency
+// symbol, whatever the email's locale. The recipient and message id are only given for a run that
+// sent exactly one email; with more, each has its own `email sent` line.
+func (rs *runSummary) log(obj s3Object, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	credits, debits := rs.credits.Value(), rs.debits.Value()
+	// rounded like the email, so the two always agree to the cent
+	rm, rerr := getRoundingMode()
+	money := func(v float64) string {
+		if rerr == nil {
+			v = rm.cents(v)
+		}
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	status := "ok"
+	switch {
+	case err != nil:
+		status = "failed"
+	case rs.quarantined:
+		status = "quarantined"
+	}
+
+	f := obj.fields()
+	f["status"] = status
+	f["emails"] = rs.emails
+	f["transactions"] = rs.transactions
+	f["credit_total"] = money(credits)
+	f["debit_total"] = money(debits)
+	f["net"] = money(credits + debits)
+	line := fmt.Sprintf("status=%s emails=%d transactions=%d credits=%s debits=%s net=%s",
+		status, rs.emails, rs.transactions, money(credits), money(debits), money(credits+debits))
+	if rs.emails == 1 {
+		f["recipient"] = rs.recipient
+		f["message_id"] = rs.messageID
+		line += fmt.Sprintf(" recipient=%s message_id=%s", rs.recipient, rs.messageID)
+	}
+	f["summary"] = line
+	logJSON("info", "run summary", f)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// captureLogs collects everything logged until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(w) })
+	return &buf
+}
+
+// runSummaryLine returns the fields of the one `run summary` line in `logs`.
+func runSummaryLine(t *testing.T, logs *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+
+	var found map[string]interface{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if i := strings.IndexByte(line, '{'); i >= 0 && strings.Contains(line, `"run summary"`) {
+			if found != nil {
+				t.Fatal("logged more than one run summary")
+			}
+			if err := json.Unmarshal([]byte(line[i:]), &found); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if found == nil {
+		t.Fatalf("no run summary in:\n%s", logs)
+	}
+	return found
+}
+
+func TestRunSummarySentEmail(t *testing.T) {
+	withConfig(t, nil)
+	content := csvFixture{}.build(sampleTransactions)
+	useFakeDownloader(t, map[string][]byte{"uploads/csv/july.csv": []byte(content)})
+	useRecordingMailer(t, EmailAuth{Username: "statements@example.com"})
+	logs := captureLogs(t)
+
+	if err := HandleRequest(context.Background(), s3Event("uploads", "csv/july.csv", "abc123", len(content))); err != nil {
+		t.Fatal(err)
+	}
+	line := runSummaryLine(t, logs)
+	if got := line["summary"].(string); !strings.HasPrefix(got, "status=ok emails=1 transactions=4 credits=70.50 debits=-30.76 net=39.74 recipient=statements@example.com") {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestRunSummaryRejectedEvent(t *testing.T) {
+	withConfig(t, nil)
+	logs := captureLogs(t)
+
+	if err := HandleRequest(context.Background(), events.S3Event{}); err == nil {
+		t.Fatal("HandleRequest accepted an event without records")
+	}
+	if line := runSummaryLine(t, logs); line["status"] != "failed" {
+		t.Errorf("status = %v, want failed", line["status"])
+	}
+}
+
+func TestRunSummaryStatus(t *testing.T) {
+	obj := s3Object{Bucket: "uploads", Key: "a.csv", ETag: "e"}
+
+	for _, tc := range []struct {
+		name        string
+		quarantined bool
+		err         error
+		want        string
+	}{
+		{"ok", false, nil, "ok"},
+		{"failed", false, ErrEmptyFile, "failed"},
+		{"quarantined", true, nil, "quarantined"},
+	} {
+		logs := captureLogs(t)
+		_, rs := withRunSummary(context.Background())
+		if tc.quarantined {
+			rs.quarantine()
+		}
+		rs.log(obj, tc.err)
+		if got := runSummaryLine(t, logs)["status"]; got != tc.want {
+			t.Errorf("%s: status = %v, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRunSummaryCountsUnsentRows(t *testing.T) {
+	logs := captureLogs(t)
+
+	// a run that stored its rows for a digest, or only had suppressed accounts, emails nothing
+	ctx, rs := withRunSummary(context.Background())
+	runSummaryFrom(ctx).read(4)
+	rs.log(s3Object{Bucket: "uploads", Key: "a.csv"}, nil)
+
+	line := runSummaryLine(t, logs)
+	if line["transactions"] != float64(4) || line["emails"] != float64(0) {
+		t.Errorf("transactions, emails = %v, %v, want 4, 0", line["transactions"], line["emails"])
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// signingKey fetches the HMAC key for summary signatures from the secret named by SIGNING_SECRET.
+// The whole secret payload is the key.
+func signingKey(svc secretsmanageriface.SecretsManagerAPI) ([]byte, error) {
+	id := envDefault("SIGNING_SECRET", "SUMMARY_SIGNING_KEY")
+	sv, err := svc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return nil, fmt.Errorf("fetching signing key: %w", err)
+	}
+
+	return secretBytes(sv)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of `body` under `key`.
+func sign(key, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+func TestSign(t *testing.T) {
+	// RFC 4231, test case 2
+	if got := sign([]byte("Jefe"), []byte("what do ya want for nothing?")); got != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("sign = %s, want the RFC 4231 HMAC-SHA256", got)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	svc := &fakeSecrets{values: map[string]*secretsmanager.GetSecretValueOutput{
+		"SUMMARY_SIGNING_KEY": {SecretString: aws.String("default key")},
+		"partner-signing":     {SecretBinary: []byte("partner key")},
+	}}
+
+	withConfig(t, nil)
+	if key, err := signingKey(svc); err != nil || string(key) != "default key" {
+		t.Errorf("signingKey = %q, %v, want the SUMMARY_SIGNING_KEY secret", key, err)
+	}
+
+	t.Setenv("SIGNING_SECRET", "partner-signing")
+	withConfig(t, nil)
+	if key, err := signingKey(svc); err != nil || string(key) != "partner key" {
+		t.Errorf("signingKey = %q, %v, want the SIGNING_SECRET secret", key, err)
+	}
+
+	t.Setenv("SIGNING_SECRET", "missing")
+	withConfig(t, nil)
+	if _, err := signingKey(svc); err == nil {
+		t.Error("signingKey succeeded for a missing secret")
+	}
+}
+
+func TestWriteSummarySigned(t *testing.T) {
+	obj := s3Object{Bucket: "in", Key: "july.csv", ETag: "abc"}
+	sm, err := getSummaries(sampleTransactions, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compress := range []string{"false", "true"} {
+		t.Setenv("COMPRESS_OUTPUT", compress)
+		withConfig(t, nil)
+
+		f := &fakeS3{}
+		if err := writeSummary(f, obj, sm, []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		out, _ := f.get("in", "summaries/july.csv.json")
+		sig, ok := f.get("in", "summaries/july.csv.json.sig")
+		if !ok {
+			t.Fatalf("COMPRESS_OUTPUT=%s: no signature written, have %q", compress, f.keys("in", ""))
+		}
+
+		body := out.Body
+		if compress == "true" {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = io.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the signature covers the JSON as read back, whether or not it was stored compressed
+		if string(sig.Body) != sign([]byte("secret"), body) {
+			t.Errorf("COMPRESS_OUTPUT=%s: signature %s doesn't match the summary", compress, sig.Body)
+		}
+		if sig.ContentType != "text/plain" {
+			t.Errorf("signature Content-Type = %q, want text/plain", sig.ContentType)
+		}
+	}
+}
+
+func TestWriteSummaryUnsigned(t *testing.T) {
+	withConfig(t, nil)
+
+	f := &fakeS3{}
+	if err := writeSummary(f, s3Object{Bucket: "in", Key: "july.csv"}, Summaries{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if keys := f.keys("in", ""); len(keys) != 1 || keys[0] != "summaries/july.csv.json" {
+		t.Errorf("stored %q, want just the summary", keys)
+	}
+}
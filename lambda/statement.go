@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// statementQRID is the Content-ID the QR code image is inlined under, referenced as `cid:` in templates.
+const statementQRID = "statement-qr"
+
+// statementQRSize is the width and height of the QR code image in pixels.
+const statementQRSize = 256
+
+// statementURL fills in STATEMENT_URL for account `id`, e.g.
+// `https://app.example.com/statements?account={account}&period={period}`. `{account}` is the account
+// id, query escaped, and `{period}` the `YYYY-MM` of `sm` as in ACCOUNT_SUMMARY_KEY. It returns "" when
+// INCLUDE_STATEMENT_LINK is off, and an error for a template that doesn't make an absolute http URL.
+func statementURL(id string, sm Summaries, at time.Time) (string, error) {
+	tmpl := getenv("STATEMENT_URL")
+	if !features().StatementLink || tmpl == "" {
+		return "", nil
+	}
+
+	link := strings.NewReplacer(
+		"{account}", url.QueryEscape(id),
+		"{period}", summaryPeriod(sm, at),
+	).Replace(tmpl)
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("invalid STATEMENT_URL %q", tmpl)
+	}
+	return link, nil
+}
+
+// statementQR encodes `link` as a PNG QR code, for phones to scan straight from a printed or
+// desktop email.
+func statementQR(link string) ([]byte, error) {
+	return qrcode.Encode(link, qrcode.Medium, statementQRSize)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "math"
+
+// maxSafeTotal is the largest magnitude a total can reach while float64 still resolves it to the cent.
+// Past 2^46 the gap between adjacent float64 values is over a cent, so rounding for display can be off.
+const maxSafeTotal = 1 << 46
+
+// kahanSum accumulates float64 values with Neumaier's compensated summation. Adding many cent
+// amounts into a large running total otherwise drops low order digits on every addition; with the
+// compensation term the error stays around one ulp of the result regardless of how many rows are summed.
+// The fields are exported so a checkpoint can carry the compensation along with the running sum.
+type kahanSum struct {
+	Sum float64
+	C   float64
+}
+
+func (k *kahanSum) Add(v float64) {
+	t := k.Sum + v
+	if math.Abs(k.Sum) >= math.Abs(v) {
+		k.C += (k.Sum - t) + v
+	} else {
+		k.C += (v - t) + k.Sum
+	}
+	k.Sum = t
+}
+
+// Value returns the compensated total.
+func (k *kahanSum) Value() float64 {
+	return k.Sum + k.C
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestKahanSumManyCents(t *testing.T) {
+	// a million one cent rows on top of 300 million: naive addition drifts by dollars
+	var k kahanSum
+	k.Add(300_000_000)
+	for i := 0; i < 1_000_000; i++ {
+		k.Add(0.01)
+	}
+	if got := math.Round(k.Value()*100) / 100; got != 300_010_000 {
+		t.Errorf("sum = %.2f, want 300010000.00", k.Value())
+	}
+}
+
+func TestGetSummariesLargeTotals(t *testing.T) {
+	withConfig(t, nil)
+
+	var ts []TransactionCSV
+	for i := 0; i < 50_000; i++ {
+		ts = append(ts,
+			TransactionCSV{ID: fmt.Sprint(2 * i), Date: "7/15", Transaction: "+12345.67"},
+			TransactionCSV{ID: fmt.Sprint(2*i + 1), Date: "7/16", Transaction: "-0.01"},
+		)
+	}
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rm, err := getRoundingMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rm.cents(sm.CreditTotal); got != 617_283_500 {
+		t.Errorf("credits = %.2f, want 617283500.00", got)
+	}
+	if got := rm.cents(sm.DebitTotal); got != -500 {
+		t.Errorf("debits = %.2f, want -500.00", got)
+	}
+	if got := rm.cents(sm.CreditTotal + sm.DebitTotal); got != 617_283_000 {
+		t.Errorf("net = %.2f, want 617283000.00", got)
+	}
+}
+
+func TestGetSummariesOverMaxSafeTotal(t *testing.T) {
+	withConfig(t, nil)
+
+	ts := []TransactionCSV{
+		{ID: "0", Date: "7/15", Transaction: fmt.Sprint(int64(maxSafeTotal))},
+		{ID: "1", Date: "7/16", Transaction: "+1"},
+	}
+	_, err := getSummaries(ts, summaryOptions{})
+	if !errors.As(err, &parseError{}) {
+		t.Errorf("getSummaries error = %v, want totals past maxSafeTotal rejected", err)
+	}
+
+	ts[1].Transaction = "-1"
+	if _, err := getSummaries(ts, summaryOptions{}); err != nil {
+		t.Errorf("getSummaries = %v at exactly maxSafeTotal", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// defaultTagKeys are the tags written by tagProcessed, keyed by what they record. TAG_KEYS renames them.
+var defaultTagKeys = map[string]string{
+	"processed":    "processed",
+	"processed_at": "processed_at",
+	"status":       "status",
+}
+
+// tagKeys returns defaultTagKeys with the renames in TAG_KEYS, a JSON object like
+// `{"status": "stori-status"}`, applied.
+func tagKeys() (map[string]string, error) {
+	keys := make(map[string]string, len(defaultTagKeys))
+	for k, v := range defaultTagKeys {
+		keys[k] = v
+	}
+
+	v := getenv("TAG_KEYS")
+	if v == "" {
+		return keys, nil
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal([]byte(v), &custom); err != nil {
+		return nil, fmt.Errorf("invalid TAG_KEYS: %w", err)
+	}
+	for k, name := range custom {
+		if _, ok := defaultTagKeys[k]; !ok {
+			return nil, fmt.Errorf("invalid TAG_KEYS: unknown tag %q", k)
+		}
+		keys[k] = name
+	}
+
+	return keys, nil
+}
+
+// tagObject tags `obj` with the outcome of a run using a fresh session, since the run's own may never
+// have been created.
+func tagObject(obj s3Object, runErr error) error {
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return err
+	}
+	return tagProcessed(s3.New(sess), obj, runErr)
+}
+
+// tagProcessed records on `obj` that it was processed, when, and whether `runErr` was nil. Tags the
+// object already has under other keys are kept, since PutObjectTagging replaces the whole set.
+func tagProcessed(svc s3iface.S3API, obj s3Object, runErr error) error {
+	keys, err := tagKeys()
+	if err != nil {
+		return err
+	}
+
+	status := "ok"
+	if runErr != nil {
+		status = "error"
+	}
+	ours := map[string]string{
+		keys["processed"]:    "true",
+		keys["processed_at"]: time.Now().UTC().Format(time.RFC3339),
+		keys["status"]:       status,
+	}
+
+	cur, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(obj.Bucket),
+		Key:    aws.String(obj.Key),
+	})
+	if err != nil {
+		return err
+	}
+
+	var tags []*s3.Tag
+	for _, t := range cur.TagSet {
+		if _, ok := ours[aws.StringValue(t.Key)]; !ok {
+			tags = append(tags, t)
+		}
+	}
+	for k, v := range ours {
+		tags = append(tags, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err = svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(obj.Bucket),
+		Key:     aws.String(obj.Key),
+		Tagging: &s3.Tagging{TagSet: tags},
+	})
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+)
+
+// defaultTemplate is used when no tier is configured, and matches the original single email layout.
+const defaultTemplate = "detailed"
+
+// noActivityTemplate replaces the tier's layout when a period has no qualifying transactions.
+const noActivityTemplate = "no_activity"
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// templateFuncs are the helpers available to every layout. Templates are parsed before the
+// configuration is known, so these are placeholders that getTemplate replaces per render.
+var templateFuncs = template.FuncMap{
+	"money":    func(float64) string { return "" },
+	"truncate": func(string) string { return "" },
+	"base":     func(float64, string) string { return "" },
+	"t":        func(string) string { return "" },
+}
+
+// templates holds every embedded email layout keyed by file name without extension, e.g. `minimal`.
+// They all render the same EmailSummary, so adding a layout is just adding a file.
+var templates = template.Must(template.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.html"))
+
+// getTemplate returns the named email template with `funcs` bound. An empty name selects the default layout.
+func getTemplate(name string, funcs template.FuncMap) (*template.Template, error) {
+	if name == "" {
+		name = defaultTemplate
+	}
+
+	ts, err := templates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t := ts.Funcs(funcs).Lookup(name + ".html")
+	if t == nil {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	return t, nil
+}
+
+// templateTier reads the customer tier that decides which layout is rendered.
+func templateTier() string {
+	return getenv("EMAIL_TIER")
+}
+
+// defaultDescriptionLength is how many characters of a description are shown when
+// DESCRIPTION_MAX_LENGTH isn't set.
+const defaultDescriptionLength = 80
+
+// truncator returns a template func that shortens strings to at most `n` characters, replacing the
+// end with an ellipsis. It counts runes rather than bytes so multibyte characters are never split.
+func truncator(n int) func(string) string {
+	return func(s string) string {
+		r := []rune(s)
+		if len(r) <= n {
+			return s
+		}
+		if n <= 1 {
+			return "…"
+		}
+		return string(r[:n-1]) + "…"
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncatorRuneSafe(t *testing.T) {
+	long := "Café Nagô 東京駅 ☕ 🍣 пельмени"
+
+	for n := 1; n <= utf8.RuneCountInString(long); n++ {
+		got := truncator(n)(long)
+		if !utf8.ValidString(got) {
+			t.Fatalf("truncate to %d split a character: %q", n, got)
+		}
+		if c := utf8.RuneCountInString(got); c > n {
+			t.Errorf("truncate to %d = %q, %d characters", n, got, c)
+		}
+		if n < utf8.RuneCountInString(long) && !strings.HasSuffix(got, "…") {
+			t.Errorf("truncate to %d = %q, want an ellipsis", n, got)
+		}
+	}
+	if got := truncator(100)(long); got != long {
+		t.Errorf("truncate = %q, want short strings unchanged", got)
+	}
+	if got := truncator(8)(long); got != "Café Na…" {
+		t.Errorf("truncate to 8 = %q", got)
+	}
+}
+
+func TestRenderEmailTruncatesDescription(t *testing.T) {
+	t.Setenv("DESCRIPTION_MAX_LENGTH", "6")
+	withConfig(t, nil)
+
+	ts := append([]TransactionCSV(nil), sampleTransactions...)
+	ts[2].Description = "東京駅のお弁当屋さん"
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(r.Body, "Largest debit: -$20.46 on 8/2 (東京駅のお…)") {
+		t.Errorf("body doesn't have the shortened description:\n%s", r.Body)
+	}
+}
+
+// useTemplate adds a layout named `name` with `body` for the rest of the test and selects it.
+func useTemplate(t *testing.T, name, body string) {
+	t.Helper()
+
+	prev := templates
+	templates = template.Must(template.Must(prev.Clone()).New(name + ".html").Parse(body))
+	t.Cleanup(func() { templates = prev })
+	t.Setenv("EMAIL_TIER", name)
+}
+
+func TestExtraFields(t *testing.T) {
+	t.Setenv("EMAIL_EXTRA", `{"accountNumber":"default","branch":"Centro"}`)
+	withConfig(t, nil)
+
+	got, err := extraFields(Recipient{Extra: map[string]string{"accountNumber": "****1234"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["accountNumber"] != "****1234" || got["branch"] != "Centro" {
+		t.Errorf("extraFields = %v, want EMAIL_EXTRA with the recipient's fields on top", got)
+	}
+
+	t.Setenv("EMAIL_EXTRA", `{"accountNumber":1234}`)
+	withConfig(t, nil)
+	if _, err := extraFields(Recipient{}); err == nil || !strings.Contains(err.Error(), "EMAIL_EXTRA") {
+		t.Errorf("extraFields error = %v, want EMAIL_EXTRA rejected", err)
+	}
+}
+
+func TestRenderEmailExtra(t *testing.T) {
+	t.Setenv("EMAIL_EXTRA", `{"branch":"Centro"}`)
+	useTemplate(t, "extra", `<p>Account {{ index .Extra "accountNumber" }} at {{ index .Extra "branch" }}{{ index .Extra "missing" }}.</p>`)
+	withConfig(t, nil)
+
+	r := renderSample(t, Recipient{Extra: map[string]string{"accountNumber": "****1234"}})
+	if !strings.Contains(r.Body, "<p>Account ****1234 at Centro.</p>") {
+		t.Errorf("body = %s, want the extra fields filled in and the missing one empty", r.Body)
+	}
+}
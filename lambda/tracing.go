@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the pipeline spans. It goes through the global provider, so spans are dropped
+// until setupTracing installs a real one.
+var tracer = otel.Tracer("stori")
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or the traces specific
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, and does nothing otherwise. The exporter reads the
+// endpoint and the rest of the standard OTEL_EXPORTER_OTLP_* variables itself.
+func setupTracing(ctx context.Context) error {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("stori"))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// batched spans have to leave before Lambda freezes the environment
+	registerFlusher(func() error { return tp.ForceFlush(context.Background()) })
+
+	return nil
+}
+
+// extractTrace continues the trace in `headers`, such as a `traceparent` sent by an API caller.
+func extractTrace(ctx context.Context, headers map[string]string) context.Context {
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// endSpan ends `sp`, marking it failed when `err` is set.
+func endSpan(sp trace.Span, err error) {
+	if err != nil {
+		sp.RecordError(err)
+		sp.SetStatus(codes.Error, err.Error())
+	}
+	sp.End()
+}
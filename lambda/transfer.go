@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// defaultTransferRoundTo is what a large credit has to be a multiple of to look like a transfer when
+// TRANSFER_ROUND_TO isn't set.
+const defaultTransferRoundTo = 100
+
+// transferRules decide which credits are money moved in from the customer's own accounts rather than
+// income. Nothing counts as a transfer when neither a threshold nor any description is set.
+type transferRules struct {
+	// threshold is the smallest credit that can be a transfer by amount alone, 0 to never match on amount.
+	threshold float64
+	// roundTo is what those credits have to be a whole multiple of, 0 for any amount at all.
+	roundTo float64
+	// descriptions are lowercased substrings that mark a credit as a transfer whatever its amount.
+	descriptions []string
+}
+
+// getTransferRules reads TRANSFER_THRESHOLD, TRANSFER_ROUND_TO and TRANSFER_DESCRIPTIONS.
+func getTransferRules() transferRules {
+	r := transferRules{
+		threshold: envFloat("TRANSFER_THRESHOLD", 0),
+		roundTo:   envFloat("TRANSFER_ROUND_TO", defaultTransferRoundTo),
+	}
+	for _, d := range strings.Split(getenv("TRANSFER_DESCRIPTIONS"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			r.descriptions = append(r.descriptions, strings.ToLower(d))
+		}
+	}
+	return r
+}
+
+// isTransfer reports whether the credit `amt` with description `desc` is a transfer: either its
+// description contains one of the configured ones, ignoring case, or it is at least the threshold and
+// a round multiple of roundTo. Debits are never transfers.
+func (r transferRules) isTransfer(desc string, amt float64) bool {
+	if amt <= 0 {
+		return false
+	}
+
+	lower := strings.ToLower(desc)
+	for _, d := range r.descriptions {
+		if strings.Contains(lower, d) {
+			return true
+		}
+	}
+	if r.threshold <= 0 || amt < r.threshold {
+		return false
+	}
+	return r.roundTo <= 0 || isMultiple(amt, r.roundTo)
+}
+
+// isMultiple reports whether `amt` is a whole multiple of `n`, to the cent.
+func isMultiple(amt, n float64) bool {
+	q := amt / n
+	return math.Abs(q-math.Round(q))*n < 0.005
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsTransfer(t *testing.T) {
+	r := transferRules{threshold: 1000, roundTo: 100, descriptions: []string{"xfer from"}}
+	for _, tc := range []struct {
+		desc string
+		amt  float64
+		want bool
+	}{
+		{"", 1000, true},
+		{"", 999.99, false},
+		{"", 900, false},
+		{"", 1500, true},
+		{"", 1550, false},
+		{"", 2000.001, true},
+		{"", 2000.01, false},
+		{"", -5000, false},
+		{"XFER FROM savings", 12.34, true},
+		{"Xfer from savings", -12.34, false},
+		{"Payroll", 3000, true},
+	} {
+		if got := r.isTransfer(tc.desc, tc.amt); got != tc.want {
+			t.Errorf("isTransfer(%q, %v) = %v, want %v", tc.desc, tc.amt, got, tc.want)
+		}
+	}
+
+	// without a threshold only descriptions match, and a roundTo of 0 takes any amount over it
+	if (transferRules{roundTo: 100}).isTransfer("", 5000) {
+		t.Error("isTransfer matched on amount without a threshold")
+	}
+	if !(transferRules{threshold: 1000}).isTransfer("", 1234.56) {
+		t.Error("isTransfer = false over the threshold with no rounding")
+	}
+}
+
+func TestGetTransferRules(t *testing.T) {
+	t.Setenv("TRANSFER_THRESHOLD", "500")
+	t.Setenv("TRANSFER_DESCRIPTIONS", " Transfer From , ,Internal")
+	withConfig(t, nil)
+
+	r := getTransferRules()
+	if r.threshold != 500 || r.roundTo != defaultTransferRoundTo || strings.Join(r.descriptions, "|") != "transfer from|internal" {
+		t.Errorf("getTransferRules = %+v, want threshold 500, the default rounding and both descriptions lowercased", r)
+	}
+}
+
+func TestGetSummariesTransfers(t *testing.T) {
+	t.Setenv("TRANSFER_THRESHOLD", "1000")
+	t.Setenv("TRANSFER_DESCRIPTIONS", "from savings")
+	withConfig(t, nil)
+
+	ts := append(append([]TransactionCSV{}, sampleTransactions...),
+		TransactionCSV{ID: "4", Date: "8/20", Transaction: "+2000"},
+		TransactionCSV{ID: "5", Date: "8/21", Transaction: "+25", Description: "Transfer from Savings"},
+		TransactionCSV{ID: "6", Date: "8/22", Transaction: "+1234.56"},
+	)
+	sm, err := getSummaries(ts, summaryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Transfers != 2 || sm.TransferTotal != 2025 {
+		t.Errorf("Transfers, TransferTotal = %d, %v, want 2, 2025", sm.Transfers, sm.TransferTotal)
+	}
+
+	r, err := RenderEmail(sm, Recipient{}, "statements@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Income: $1,305.06", "Transfers: 2, $2,025.00"} {
+		if !strings.Contains(r.Body, want) {
+			t.Errorf("body is missing %q:\n%s", want, r.Body)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dayKeyLayout is how DailyNet keys are written. Dates without a year are stored with year 0.
+const dayKeyLayout = "2006-01-02"
+
+// DaySwing is the change in net amount from one day with activity to the next.
+type DaySwing struct {
+	From   string
+	To     string
+	Change float64
+}
+
+// getDate returns the calendar date of a `M/D` or `M/D/YYYY` value. It reports false when the
+// month or day can't be read.
+func getDate(s string) (time.Time, bool) {
+	split := dateParts(s)
+	if len(split) < 2 {
+		return time.Time{}, false
+	}
+	m, err := strconv.Atoi(split[0])
+	if err != nil || m < 1 || m > 12 {
+		return time.Time{}, false
+	}
+	d, ok := getDay(s)
+	if !ok {
+		return time.Time{}, false
+	}
+	y, _ := getYear(s)
+
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC), true
+}
+
+// displayDay turns a DailyNet key back into the `M/D` or `M/D/YYYY` form used in the files.
+func displayDay(key string) string {
+	t, err := time.Parse(dayKeyLayout, key)
+	if err != nil {
+		return key
+	}
+	if t.Year() == 0 {
+		return t.Format("1/2")
+	}
+	return t.Format("1/2/2006")
+}
+
+// largestSwing finds the biggest absolute change in net amount between consecutive days with
+// activity. It returns nil when there are fewer than two such days.
+func largestSwing(daily map[string]float64) *DaySwing {
+	days := make([]string, 0, len(daily))
+	for d := range daily {
+		days = append(days, d)
+	}
+	// the ISO layout sorts chronologically as plain strings
+	sort.Strings(days)
+
+	var best *DaySwing
+	for i := 1; i < len(days); i++ {
+		change := daily[days[i]] - daily[days[i-1]]
+		if best == nil || math.Abs(change) > math.Abs(best.Change) {
+			best = &DaySwing{From: displayDay(days[i-1]), To: displayDay(days[i]), Change: change}
+		}
+	}
+
+	return best
+}